@@ -0,0 +1,30 @@
+package db
+
+// KVBatch - interface of batch operations
+// results are in the order of keys or kvs, one for each of them,
+// as returned by Get, Set and Delete
+type KVBatch interface {
+	MGet(keys []string) []*KVResult
+	MSet(kvs []KVData) []*KVResult
+	MDelete(keys []string) []*KVResult
+}
+
+// batchFailed - same failed result for every item of a batch
+func batchFailed(n int, err error) []*KVResult {
+	res := make([]*KVResult, n)
+	for i := range res {
+		res[i] = &KVResult{
+			Result: false,
+			Info:   err.Error(),
+		}
+	}
+	return res
+}
+
+// notExisted - result of a missing key
+func notExisted() *KVResult {
+	return &KVResult{
+		Result: false,
+		Info:   "data didn't existed",
+	}
+}
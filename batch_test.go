@@ -0,0 +1,143 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+)
+
+func testKVBatch(t *testing.T, db KVMethods) {
+	b, ok := db.(KVBatch)
+	if !ok {
+		t.Fatalf("%s doesn't support batch", db.Name())
+	}
+	kvs := []KVData{{"a", []byte("1")}, {"b", []byte("2")}, {"c", []byte("3")}}
+	for _, r := range b.MSet(kvs) {
+		if !r.Result {
+			t.Fatal(r.Info)
+		}
+	}
+	res := b.MGet([]string{"a", "missing", "c"})
+	if len(res) != 3 || !res[0].Result || res[1].Result || !res[2].Result {
+		t.Fatalf("mget: %v", res)
+	}
+	if string(res[2].Data.([]byte)) != "3" {
+		t.Fatalf("mget c: %s", res[2].Data)
+	}
+	res = b.MDelete([]string{"a", "b", "missing"})
+	if !res[0].Result || !res[1].Result || res[2].Result {
+		t.Fatalf("mdelete: %v", res)
+	}
+	if db.Exists("a") || db.Exists("b") || !db.Exists("c") {
+		t.Fatal("wrong keys deleted")
+	}
+}
+
+func TestKVBatch_Mem(t *testing.T) {
+	db, err := OpenOrGet("mem://batch/batchtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVBatch(t, db)
+}
+
+func TestKVBatch_Bolt(t *testing.T) {
+	db, err := OpenOrGet("bolt://batch.db/batchtest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVBatch(t, db)
+}
+
+// benchKVs - n kvs for benchmarks
+func benchKVs(n int) []KVData {
+	kvs := make([]KVData, n)
+	for i := range kvs {
+		kvs[i] = KVData{"key" + strconv.Itoa(i), []byte("value" + strconv.Itoa(i))}
+	}
+	return kvs
+}
+
+func benchmarkSetLoop(b *testing.B, uri string) {
+	db, err := OpenOrGet(uri)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	kvs := benchKVs(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range kvs {
+			db.Set(&kvs[j])
+		}
+	}
+}
+
+func benchmarkMSet(b *testing.B, uri string) {
+	db, err := OpenOrGet(uri)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	kvs := benchKVs(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.(KVBatch).MSet(kvs)
+	}
+}
+
+func benchmarkGetLoop(b *testing.B, uri string) {
+	db, err := OpenOrGet(uri)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	kvs := benchKVs(100)
+	db.(KVBatch).MSet(kvs)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range kvs {
+			db.Get(kvs[j].Key)
+		}
+	}
+}
+
+func benchmarkMGet(b *testing.B, uri string) {
+	db, err := OpenOrGet(uri)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	kvs := benchKVs(100)
+	db.(KVBatch).MSet(kvs)
+	keys := make([]string, len(kvs))
+	for i := range kvs {
+		keys[i] = kvs[i].Key
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.(KVBatch).MGet(keys)
+	}
+}
+
+func BenchmarkMem_SetLoop(b *testing.B) { benchmarkSetLoop(b, "mem://bench/setloop") }
+func BenchmarkMem_MSet(b *testing.B)    { benchmarkMSet(b, "mem://bench/mset") }
+func BenchmarkMem_GetLoop(b *testing.B) { benchmarkGetLoop(b, "mem://bench/getloop") }
+func BenchmarkMem_MGet(b *testing.B)    { benchmarkMGet(b, "mem://bench/mget") }
+
+func BenchmarkBolt_SetLoop(b *testing.B) {
+	benchmarkSetLoop(b, "bolt://bench.db/setloop?path="+b.TempDir())
+}
+
+func BenchmarkBolt_MSet(b *testing.B) {
+	benchmarkMSet(b, "bolt://bench.db/mset?path="+b.TempDir())
+}
+
+func BenchmarkBolt_GetLoop(b *testing.B) {
+	benchmarkGetLoop(b, "bolt://bench.db/getloop?path="+b.TempDir())
+}
+
+func BenchmarkBolt_MGet(b *testing.B) {
+	benchmarkMGet(b, "bolt://bench.db/mget?path="+b.TempDir())
+}
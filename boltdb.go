@@ -1,15 +1,19 @@
 package db
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
-	jsoniter "github.com/json-iterator/go"
 )
 
 // BoltDB - BoltDB struct
@@ -20,6 +24,13 @@ type BoltDB struct {
 	Bucket string
 	Count  uint
 	DB     *bolt.DB
+	// guard done, closed to stop the expire sweeper
+	sweepMu sync.Mutex
+	done    chan struct{}
+	// watchers of changes
+	watchers watchHub
+	// codec of SetData
+	codec Codec
 }
 
 // DefaultBoltDB - get Default Bolt DB
@@ -36,8 +47,8 @@ func init() {
 }
 
 // NewBoltDB - new bolt db using uri format description
-// format : bolt://<db file>/<bucket>?[count=]&[path=]
-// example bolt://service.db/service?count=20&path=./base
+// format : bolt://<db file>/<bucket>?[count=]&[path=]&[codec=]
+// example bolt://service.db/service?count=20&path=./base&codec=msgpack
 func NewBoltDB(uri string) (KVMethods, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -63,6 +74,10 @@ func NewBoltDB(uri string) (KVMethods, error) {
 		}
 		bolt.Count = uint(i)
 	}
+	bolt.codec, err = codecParam(para.Get("codec"))
+	if err != nil {
+		return nil, err
+	}
 
 	err = bolt.setup()
 	if err != nil {
@@ -91,6 +106,9 @@ func (db *BoltDB) setup() error {
 	if err != nil {
 		return fmt.Errorf("could not set up default buckets, %v", err)
 	}
+	if db.expiring() > 0 {
+		db.startSweeper()
+	}
 	// fmt.Println("DB Setup Done")
 	return nil
 }
@@ -100,6 +118,18 @@ func (db *BoltDB) Name() string {
 	return "Bolt_" + db.Bucket
 }
 
+// Close - close bolt file
+func (db *BoltDB) Close() error {
+	db.sweepMu.Lock()
+	if db.done != nil {
+		close(db.done)
+		db.done = nil
+	}
+	db.sweepMu.Unlock()
+	db.watchers.closeAll()
+	return db.DB.Close()
+}
+
 // DBType - DataBase Type
 func (db *BoltDB) DBType() *KVDBType {
 	return db.Type
@@ -112,7 +142,7 @@ func (db *BoltDB) Exists(key string) bool {
 		if b == nil {
 			return fmt.Errorf("could not open bucket, %s", db.Bucket)
 		}
-		if v := b.Get([]byte(key)); v != nil {
+		if v := db.get(b, []byte(key), time.Now()); v != nil {
 			return nil
 		}
 		return errors.New("Not Existed")
@@ -130,7 +160,7 @@ func (db *BoltDB) Get(key string) *KVResult {
 		if b == nil {
 			return fmt.Errorf("could not open bucket, %s", db.Bucket)
 		}
-		data = b.Get([]byte(key))
+		data = db.get(b, []byte(key), time.Now())
 		if data == nil {
 			return errors.New("no suck key in DB")
 		}
@@ -156,8 +186,12 @@ func (db *BoltDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
 		if b == nil {
 			return fmt.Errorf("could not open bucket, %s", db.Bucket)
 		}
+		now := time.Now()
 		c := b.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !db.alive(b, k, now) {
+				continue
+			}
 			if i := handler(k, v); i.Result {
 				kv = i
 				return nil
@@ -178,7 +212,7 @@ func (db *BoltDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
 func (db *BoltDB) Set(kv *KVData) *KVResult {
 	err := db.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(db.Bucket))
-		err := b.Put([]byte(kv.Key), kv.Value)
+		err := db.put(b, []byte(kv.Key), kv.Value, time.Time{})
 		return err
 	})
 	if err != nil {
@@ -202,8 +236,8 @@ func (db *BoltDB) Delete(key string) *KVResult {
 	}
 	err := db.DB.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(db.Bucket))
-		kv.Value = b.Get([]byte(key))
-		err := b.Delete([]byte(key))
+		kv.Value = db.get(b, []byte(key), time.Now())
+		err := db.del(b, []byte(key))
 		return err
 	})
 	if err != nil {
@@ -222,7 +256,7 @@ func (db *BoltDB) KeyCount() int {
 	if err := db.DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(db.Bucket))
 		stats := b.Stats()
-		number = stats.KeyN
+		number = stats.KeyN - db.expiredCount(b, time.Now())
 		return nil
 	}); err == nil {
 		return number
@@ -230,76 +264,582 @@ func (db *BoltDB) KeyCount() int {
 	return 0
 }
 
-// ListKeys - list keys
-// page - the number of page
-// boltdb.Count define the records in one page
-func (db *BoltDB) ListKeys(page uint) []string {
-	var list []string
-	if err := db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(db.Bucket))
-		if b == nil {
-			return fmt.Errorf("could not open bucket, %s", db.Bucket)
+// Scan - list at most limit kvs after cursor in key order
+func (db *BoltDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	if limit <= 0 {
+		return nil, "", wrongLimit()
+	}
+	after, ok, err := cursorKey(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]KVData, 0, limit)
+	next := ""
+	err = db.view(context.Background(), func(b *bolt.Bucket) error {
+		now := time.Now()
+		c := b.Cursor()
+		k, v := c.First()
+		if ok {
+			k, v = c.Seek([]byte(after))
+			if k != nil && string(k) == after {
+				k, v = c.Next()
+			}
 		}
+		for ; k != nil; k, v = c.Next() {
+			if v == nil || !db.alive(b, k, now) {
+				continue
+			}
+			if len(items) == limit {
+				next = keyCursor(items[limit-1].Key)
+				return nil
+			}
+			items = append(items, KVData{string(k), append([]byte(nil), v...)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
+
+// ScanPrefix - all kvs whose key starts with prefix in key order
+func (db *BoltDB) ScanPrefix(prefix string) ([]KVData, error) {
+	return db.scanFrom(prefix, 0, func(k []byte) bool {
+		return bytes.HasPrefix(k, []byte(prefix))
+	})
+}
+
+// ScanRange - all kvs with start <= key < end in key order
+func (db *BoltDB) ScanRange(start, end string) ([]KVData, error) {
+	return db.scanFrom(start, 0, func(k []byte) bool {
+		return inRange(string(k), end)
+	})
+}
+
+// ScanRangeLimit - at most limit kvs with start <= key < end in key order
+func (db *BoltDB) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	return db.scanFrom(start, limit, func(k []byte) bool {
+		return inRange(string(k), end)
+	})
+}
+
+// scanFrom - kvs from key start in key order while in returned true,
+// at most limit of them, every one for limit 0
+func (db *BoltDB) scanFrom(start string, limit int, in func(k []byte) bool) ([]KVData, error) {
+	items := make([]KVData, 0)
+	err := db.view(context.Background(), func(b *bolt.Bucket) error {
+		now := time.Now()
 		c := b.Cursor()
-		index := uint(0)
-		for k, _ := c.First(); k != nil && index < (page+1)*db.Count; k, _ = c.Next() {
-			if index >= page*db.Count {
-				list = append(list, string(k))
+		for k, v := c.Seek([]byte(start)); k != nil && in(k) && (limit == 0 || len(items) < limit); k, v = c.Next() {
+			if v == nil || !db.alive(b, k, now) {
+				continue
 			}
-			index++
+			items = append(items, KVData{string(k), append([]byte(nil), v...)})
 		}
 		return nil
-	}); err == nil {
-		return list
+	})
+	if err != nil {
+		return nil, err
 	}
-	return []string{}
+	return items, nil
+}
+
+// ListKeys - list keys
+// page - the number of page
+// boltdb.Count define the records in one page
+func (db *BoltDB) ListKeys(page uint) []string {
+	return scanListKeys(db, page, db.Count)
 }
 
 // List - list content that hander returned
 // page - page number
 // boltdb.Count define the records in one page
 func (db *BoltDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
-	data := make([]interface{}, 0)
-	kv := &KVResult{
-		Info:   "",
-		Result: true,
+	return scanList(db, page, db.Count, handler)
+}
+
+// SetData - set data encoded by codec of db
+func (db *BoltDB) SetData(key string, data interface{}) *KVResult {
+	return setData(db, db.codec, key, data)
+}
+
+// GetData - decode value of key into out
+func (db *BoltDB) GetData(key string, out interface{}) *KVResult {
+	return getData(db, key, out)
+}
+
+// Codec - codec used by SetData
+func (db *BoltDB) Codec() Codec {
+	return db.codec
+}
+
+// boltError - map bolt errors to kvdb errors
+func boltError(err error) error {
+	switch err {
+	case bolt.ErrDatabaseNotOpen:
+		return ErrClosed
+	case bolt.ErrTxNotWritable:
+		return ErrReadOnly
 	}
-	err := db.DB.View(func(tx *bolt.Tx) error {
+	return err
+}
+
+// view - read only transaction on bucket of db
+func (db *BoltDB) view(ctx context.Context, fn func(b *bolt.Bucket) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return boltError(db.DB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(db.Bucket))
 		if b == nil {
-			return fmt.Errorf("could not open bucket, %s", db.Bucket)
+			return fmt.Errorf("%w: %s", ErrBucketMissing, db.Bucket)
+		}
+		return fn(b)
+	}))
+}
+
+// update - read write transaction on bucket of db
+func (db *BoltDB) update(ctx context.Context, fn func(b *bolt.Bucket) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return boltError(db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Bucket))
+		if b == nil {
+			return fmt.Errorf("%w: %s", ErrBucketMissing, db.Bucket)
+		}
+		return fn(b)
+	}))
+}
+
+// GetContext - get value from key
+func (db *BoltDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := db.view(ctx, func(b *bolt.Bucket) error {
+		v := db.get(b, []byte(key), time.Now())
+		if v == nil {
+			return ErrNotFound
 		}
+		data = append([]byte(nil), v...)
+		return nil
+	})
+	return data, err
+}
+
+// SetContext - set key value
+func (db *BoltDB) SetContext(ctx context.Context, key string, value []byte) error {
+	return db.update(ctx, func(b *bolt.Bucket) error {
+		return db.put(b, []byte(key), value, time.Time{})
+	})
+}
+
+// DeleteContext - delete key
+func (db *BoltDB) DeleteContext(ctx context.Context, key string) error {
+	return db.update(ctx, func(b *bolt.Bucket) error {
+		if db.get(b, []byte(key), time.Now()) == nil {
+			return ErrNotFound
+		}
+		return db.del(b, []byte(key))
+	})
+}
+
+// ExistsContext - if key existed
+func (db *BoltDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := db.view(ctx, func(b *bolt.Bucket) error {
+		ok = db.get(b, []byte(key), time.Now()) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// ScanContext - call handler on every kv in bucket order
+func (db *BoltDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	return db.view(ctx, func(b *bolt.Bucket) error {
+		now := time.Now()
 		c := b.Cursor()
-		index := uint(0)
-		for k, v := c.First(); k != nil && index < (page+1)*db.Count; k, v = c.Next() {
-			if index >= page*db.Count {
-				if i := handler(k, v); i.Result {
-					data = append(data, i.Data)
-					index++
-				}
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !db.alive(b, k, now) {
+				continue
+			}
+			if err := handler(k, v); err != nil {
+				return err
 			}
 		}
 		return nil
 	})
-	if err == nil {
-		kv.Data = data
-		return kv
+}
+
+// boltTxn - KVTxn on bucket in a bolt transaction
+type boltTxn struct {
+	db *BoltDB
+	b  *bolt.Bucket
+}
+
+func (tx *boltTxn) Get(key string) ([]byte, error) {
+	v := tx.db.get(tx.b, []byte(key), time.Now())
+	if v == nil {
+		return nil, ErrNotFound
 	}
-	return &KVResult{
-		Info:   err.Error(),
-		Result: false,
+	return append([]byte(nil), v...), nil
+}
+
+func (tx *boltTxn) Exists(key string) (bool, error) {
+	return tx.db.get(tx.b, []byte(key), time.Now()) != nil, nil
+}
+
+func (tx *boltTxn) Set(key string, value []byte) error {
+	if !tx.b.Writable() {
+		return ErrReadOnly
 	}
+	return boltError(tx.db.put(tx.b, []byte(key), value, time.Time{}))
 }
 
-// SetData - set data in object json format
-func (db *BoltDB) SetData(key string, data interface{}) *KVResult {
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
-	value, err := json.Marshal(data)
+func (tx *boltTxn) Delete(key string) error {
+	if !tx.b.Writable() {
+		return ErrReadOnly
+	}
+	return boltError(tx.db.del(tx.b, []byte(key)))
+}
+
+// Update - run fn in one bolt read write transaction
+func (db *BoltDB) Update(fn func(tx KVTxn) error) error {
+	return db.update(context.Background(), func(b *bolt.Bucket) error {
+		return fn(&boltTxn{db, b})
+	})
+}
+
+// View - run fn in one bolt read only transaction
+func (db *BoltDB) View(fn func(tx KVTxn) error) error {
+	return db.view(context.Background(), func(b *bolt.Bucket) error {
+		return fn(&boltTxn{db, b})
+	})
+}
+
+// expireBucket - bucket of key deadlines, key -> unix nano
+func (db *BoltDB) expireBucket() []byte {
+	return []byte("__expire__" + db.Bucket)
+}
+
+// expireIndex - bucket of keys ordered by deadline, unix nano + key -> nil
+func (db *BoltDB) expireIndex() []byte {
+	return []byte("__expire_index__" + db.Bucket)
+}
+
+// expireIndexKey - key in expire index
+func expireIndexKey(deadline []byte, key []byte) []byte {
+	return append(append([]byte(nil), deadline...), key...)
+}
+
+// deadline - deadline of key in bucket b
+func (db *BoltDB) deadline(b *bolt.Bucket, key []byte) (time.Time, bool) {
+	eb := b.Tx().Bucket(db.expireBucket())
+	if eb == nil {
+		return time.Time{}, false
+	}
+	v := eb.Get(key)
+	if v == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true
+}
+
+// alive - if key hasn't expired at now
+func (db *BoltDB) alive(b *bolt.Bucket, key []byte, now time.Time) bool {
+	deadline, ok := db.deadline(b, key)
+	return !ok || !expired(deadline, now)
+}
+
+// get - value of key alive at now, nil if it's missing or expired
+func (db *BoltDB) get(b *bolt.Bucket, key []byte, now time.Time) []byte {
+	v := b.Get(key)
+	if v == nil || !db.alive(b, key, now) {
+		return nil
+	}
+	return v
+}
+
+// put - set key value, deadline zero for persistent key
+// watchers are told when the transaction is committed
+func (db *BoltDB) put(b *bolt.Bucket, key, value []byte, deadline time.Time) error {
+	if db.watchers.active() {
+		old := db.get(b, key, time.Now())
+		ev := putEvent(string(key), append([]byte(nil), old...), append([]byte(nil), value...))
+		b.Tx().OnCommit(func() {
+			db.watchers.publish(ev)
+		})
+	}
+	if err := b.Put(key, value); err != nil {
+		return err
+	}
+	if err := db.clearDeadline(b, key); err != nil {
+		return err
+	}
+	if deadline.IsZero() {
+		return nil
+	}
+	tx := b.Tx()
+	eb, err := tx.CreateBucketIfNotExists(db.expireBucket())
+	if err != nil {
+		return err
+	}
+	ib, err := tx.CreateBucketIfNotExists(db.expireIndex())
+	if err != nil {
+		return err
+	}
+	d := make([]byte, 8)
+	binary.BigEndian.PutUint64(d, uint64(deadline.UnixNano()))
+	if err := eb.Put(key, d); err != nil {
+		return err
+	}
+	return ib.Put(expireIndexKey(d, key), nil)
+}
+
+// del - delete key and its deadline
+// watchers are told when the transaction is committed
+func (db *BoltDB) del(b *bolt.Bucket, key []byte) error {
+	if old := b.Get(key); old != nil && db.watchers.active() {
+		ev := deleteEvent(string(key), append([]byte(nil), old...))
+		b.Tx().OnCommit(func() {
+			db.watchers.publish(ev)
+		})
+	}
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	return db.clearDeadline(b, key)
+}
+
+// clearDeadline - make key persistent
+func (db *BoltDB) clearDeadline(b *bolt.Bucket, key []byte) error {
+	tx := b.Tx()
+	eb := tx.Bucket(db.expireBucket())
+	if eb == nil {
+		return nil
+	}
+	d := eb.Get(key)
+	if d == nil {
+		return nil
+	}
+	if ib := tx.Bucket(db.expireIndex()); ib != nil {
+		if err := ib.Delete(expireIndexKey(d, key)); err != nil {
+			return err
+		}
+	}
+	return eb.Delete(key)
+}
+
+// expiredKeys - keys expired at now in deadline order
+func (db *BoltDB) expiredKeys(b *bolt.Bucket, now time.Time) [][]byte {
+	ib := b.Tx().Bucket(db.expireIndex())
+	if ib == nil {
+		return nil
+	}
+	var keys [][]byte
+	c := ib.Cursor()
+	for k, _ := c.First(); k != nil && len(k) >= 8; k, _ = c.Next() {
+		if int64(binary.BigEndian.Uint64(k[:8])) > now.UnixNano() {
+			break
+		}
+		keys = append(keys, append([]byte(nil), k[8:]...))
+	}
+	return keys
+}
+
+// expiredCount - number of expired keys not swept yet
+func (db *BoltDB) expiredCount(b *bolt.Bucket, now time.Time) int {
+	return len(db.expiredKeys(b, now))
+}
+
+// expiring - number of keys with ttl
+func (db *BoltDB) expiring() int {
+	n := 0
+	db.DB.View(func(tx *bolt.Tx) error {
+		if eb := tx.Bucket(db.expireBucket()); eb != nil {
+			n = eb.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+// startSweeper - run sweeper if it isn't running
+func (db *BoltDB) startSweeper() {
+	db.sweepMu.Lock()
+	defer db.sweepMu.Unlock()
+	if db.done == nil {
+		db.done = make(chan struct{})
+		go db.sweeper(db.done)
+	}
+}
+
+// sweeper - remove expired keys periodically
+// it stops when db is closed or no key with ttl is left
+func (db *BoltDB) sweeper(done chan struct{}) {
+	ticker := time.NewTicker(ExpireSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := db.sweep(); err == ErrClosed {
+				return
+			}
+			db.sweepMu.Lock()
+			if db.done == done && db.expiring() == 0 {
+				db.done = nil
+				db.sweepMu.Unlock()
+				return
+			}
+			db.sweepMu.Unlock()
+		}
+	}
+}
+
+// sweep - remove expired keys
+// expired keys are looked up in a read only transaction, so nothing is
+// written when there are none
+func (db *BoltDB) sweep() error {
+	found := false
+	err := db.view(context.Background(), func(b *bolt.Bucket) error {
+		found = len(db.expiredKeys(b, time.Now())) > 0
+		return nil
+	})
+	if err != nil || !found {
+		return err
+	}
+	return db.update(context.Background(), func(b *bolt.Bucket) error {
+		for _, k := range db.expiredKeys(b, time.Now()) {
+			if err := db.del(b, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithTTL - set key value that expires after ttl
+func (db *BoltDB) SetWithTTL(kv *KVData, ttl time.Duration) *KVResult {
+	if ttl <= 0 {
+		return wrongTTL()
+	}
+	err := db.update(context.Background(), func(b *bolt.Bucket) error {
+		return db.put(b, []byte(kv.Key), kv.Value, time.Now().Add(ttl))
+	})
 	if err != nil {
 		return &KVResult{
 			Result: false,
 			Info:   err.Error(),
 		}
 	}
-	return db.Set(&KVData{key, value})
+	db.startSweeper()
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+		Info:   "",
+	}
+}
+
+// TTL - remaining time to live of key
+func (db *BoltDB) TTL(key string) (time.Duration, error) {
+	ttl := NoExpiration
+	err := db.view(context.Background(), func(b *bolt.Bucket) error {
+		now := time.Now()
+		if db.get(b, []byte(key), now) == nil {
+			return ErrNotFound
+		}
+		if deadline, ok := db.deadline(b, []byte(key)); ok {
+			ttl = deadline.Sub(now)
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// MGet - get values of keys in one transaction
+func (db *BoltDB) MGet(keys []string) []*KVResult {
+	res := make([]*KVResult, len(keys))
+	err := db.view(context.Background(), func(b *bolt.Bucket) error {
+		now := time.Now()
+		for i, key := range keys {
+			v := db.get(b, []byte(key), now)
+			if v == nil {
+				res[i] = notExisted()
+				continue
+			}
+			res[i] = &KVResult{
+				Data:   append([]byte(nil), v...),
+				Result: true,
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return batchFailed(len(keys), err)
+	}
+	return res
+}
+
+// MSet - set kvs in one transaction, so they are synced once
+func (db *BoltDB) MSet(kvs []KVData) []*KVResult {
+	err := db.update(context.Background(), func(b *bolt.Bucket) error {
+		for i := range kvs {
+			if err := db.put(b, []byte(kvs[i].Key), kvs[i].Value, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return batchFailed(len(kvs), err)
+	}
+	res := make([]*KVResult, len(kvs))
+	for i := range kvs {
+		res[i] = &KVResult{
+			Data:   &kvs[i],
+			Result: true,
+		}
+	}
+	return res
+}
+
+// MDelete - delete keys in one transaction
+func (db *BoltDB) MDelete(keys []string) []*KVResult {
+	res := make([]*KVResult, len(keys))
+	err := db.update(context.Background(), func(b *bolt.Bucket) error {
+		now := time.Now()
+		for i, key := range keys {
+			v := db.get(b, []byte(key), now)
+			if v == nil {
+				res[i] = notExisted()
+				continue
+			}
+			res[i] = &KVResult{
+				Data:   append([]byte(nil), v...),
+				Result: true,
+			}
+			if err := db.del(b, []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return batchFailed(len(keys), err)
+	}
+	return res
+}
+
+// Watch - events of keys starting with prefix, until ctx is done
+// events are sent after their transaction is committed
+func (db *BoltDB) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	return db.watchers.watch(ctx, prefix)
 }
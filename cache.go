@@ -0,0 +1,303 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// CacheMode - how writes update the cache
+type CacheMode int
+
+const (
+	// WriteThrough - values set are cached
+	WriteThrough CacheMode = iota
+	// WriteAround - values set are dropped from cache, and read from db
+	// on the next get
+	WriteAround
+)
+
+// CacheOptions - settings of a CachedDB
+type CacheOptions struct {
+	// Size - most keys cached, least recently used are evicted first,
+	// 1024 by default
+	Size int
+	// TTL - how long values are cached, forever by default
+	// values expiring in db are still cached until TTL
+	TTL time.Duration
+	// NegativeTTL - how long missing keys are cached, 0 to not cache them
+	NegativeTTL time.Duration
+	Mode        CacheMode
+}
+
+// CacheStats - counters of a CachedDB
+type CacheStats struct {
+	Hits uint64
+	// NegativeHits - hits of keys cached as missing
+	NegativeHits uint64
+	Misses       uint64
+	// Loads - reads of db, concurrent misses of a key share one
+	Loads     uint64
+	Evictions uint64
+	Len       int
+}
+
+// CachedDB - database with a bounded in-memory cache of db in front
+// reads of Get, GetData and Exists go through the cache, other methods
+// go to db; writes of other clients of db aren't seen until invalidated
+type CachedDB struct {
+	KVWrapper
+	opts CacheOptions
+
+	lock    sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// loads and writes in flight by key
+	loads  map[string]*cacheLoad
+	writes map[string]*cacheWrite
+	stats  CacheStats
+}
+
+// cacheEntry - cached value of key, value nil when key is missing
+type cacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// cacheLoad - read of db shared by concurrent misses of a key
+type cacheLoad struct {
+	wg    sync.WaitGroup
+	value []byte
+	err   error
+}
+
+// cacheWrite - writes of a key in flight, when they overlap the order
+// they are applied by db is unknown, and the key isn't cached
+type cacheWrite struct {
+	n       int
+	overlap bool
+}
+
+// NewCachedDB - put a cache in front of db
+func NewCachedDB(db KVMethods, opts CacheOptions) *CachedDB {
+	if opts.Size <= 0 {
+		opts.Size = 1024
+	}
+	return &CachedDB{
+		KVWrapper: KVWrapper{db},
+		opts:      opts,
+		lru:       list.New(),
+		entries:   make(map[string]*list.Element),
+		loads:     make(map[string]*cacheLoad),
+		writes:    make(map[string]*cacheWrite),
+	}
+}
+
+// Cache - middleware putting a cache in front of databases
+func Cache(opts CacheOptions) Middleware {
+	return func(db KVMethods) KVMethods {
+		return NewCachedDB(db, opts)
+	}
+}
+
+// CacheStats - counters of cache
+func (c *CachedDB) CacheStats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s := c.stats
+	s.Len = c.lru.Len()
+	return s
+}
+
+// Invalidate - drop key from cache
+func (c *CachedDB) Invalidate(key string) {
+	c.lock.Lock()
+	c.remove(key)
+	c.lock.Unlock()
+}
+
+// Purge - drop every key from cache
+func (c *CachedDB) Purge() {
+	c.lock.Lock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.loads = make(map[string]*cacheLoad)
+	c.lock.Unlock()
+}
+
+// remove - drop key and its load in flight, caller holds lock
+// the load is still finished for those waiting on it, but not cached
+func (c *CachedDB) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+	delete(c.loads, key)
+}
+
+// put - cache value of key, nil for a missing key, caller holds lock
+func (c *CachedDB) put(key string, value []byte) {
+	ttl := c.opts.TTL
+	if value == nil {
+		ttl = c.opts.NegativeTTL
+		if ttl <= 0 {
+			c.remove(key)
+			return
+		}
+	}
+	entry := &cacheEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.opts.Size {
+		old := c.lru.Back()
+		c.lru.Remove(old)
+		delete(c.entries, old.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// get - value of key, from cache or loaded from db
+func (c *CachedDB) get(key string) ([]byte, error) {
+	c.lock.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(e)
+			if entry.value == nil {
+				c.stats.NegativeHits++
+				c.lock.Unlock()
+				return nil, ErrNotFound
+			}
+			c.stats.Hits++
+			c.lock.Unlock()
+			return entry.value, nil
+		}
+		c.remove(key)
+	}
+	c.stats.Misses++
+	if l, ok := c.loads[key]; ok {
+		c.lock.Unlock()
+		l.wg.Wait()
+		return l.value, l.err
+	}
+	l := &cacheLoad{}
+	l.wg.Add(1)
+	c.loads[key] = l
+	c.stats.Loads++
+	c.lock.Unlock()
+
+	l.value, l.err = KVStoreOf(c.DB).GetContext(context.Background(), key)
+	if l.err == nil && l.value == nil {
+		l.value = []byte{}
+	}
+	c.lock.Lock()
+	// not invalidated while loading
+	if c.loads[key] == l {
+		delete(c.loads, key)
+		if l.err == nil {
+			c.put(key, l.value)
+		} else if l.err == ErrNotFound {
+			c.put(key, nil)
+		}
+	}
+	c.lock.Unlock()
+	l.wg.Done()
+	return l.value, l.err
+}
+
+// Exists - if key existed
+func (c *CachedDB) Exists(key string) bool {
+	_, err := c.get(key)
+	return err == nil
+}
+
+// Get - get value of key
+func (c *CachedDB) Get(key string) *KVResult {
+	v, err := c.get(key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   append([]byte(nil), v...),
+		Result: true,
+	}
+}
+
+// GetData - decode value of key into out
+func (c *CachedDB) GetData(key string, out interface{}) *KVResult {
+	v, err := c.get(key)
+	if err == nil {
+		err = DecodeValue(v, out)
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   out,
+		Result: true,
+	}
+}
+
+// write - run write of key to db, then cache value when cache is set
+// and the write succeeded, else drop key, value nil for a missing key
+func (c *CachedDB) write(key string, value []byte, cache bool, write func() *KVResult) *KVResult {
+	c.lock.Lock()
+	w, ok := c.writes[key]
+	if !ok {
+		w = &cacheWrite{}
+		c.writes[key] = w
+	}
+	w.n++
+	w.overlap = w.n > 1 || w.overlap
+	c.lock.Unlock()
+
+	kvr := write()
+	c.lock.Lock()
+	c.remove(key)
+	if kvr.Result && cache && !w.overlap {
+		c.put(key, value)
+	}
+	if w.n--; w.n == 0 {
+		delete(c.writes, key)
+	}
+	c.lock.Unlock()
+	return kvr
+}
+
+// Set - set key value in db, and in cache by mode
+func (c *CachedDB) Set(kv *KVData) *KVResult {
+	value := append([]byte{}, kv.Value...)
+	return c.write(kv.Key, value, c.opts.Mode == WriteThrough, func() *KVResult {
+		return c.DB.Set(kv)
+	})
+}
+
+// SetData - set data encoded by codec of db
+func (c *CachedDB) SetData(key string, data interface{}) *KVResult {
+	return setData(c, c.Codec(), key, data)
+}
+
+// Delete - delete key from db and cache
+func (c *CachedDB) Delete(key string) *KVResult {
+	return c.write(key, nil, true, func() *KVResult {
+		return c.DB.Delete(key)
+	})
+}
+
+// Close - close db and drop cache
+func (c *CachedDB) Close() error {
+	c.Purge()
+	return c.DB.Close()
+}
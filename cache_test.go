@@ -0,0 +1,100 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowGets - middleware counting slow Get calls
+type slowGets struct {
+	KVWrapper
+	lock  sync.Mutex
+	calls int
+}
+
+func (s *slowGets) Get(key string) *KVResult {
+	s.lock.Lock()
+	s.calls++
+	s.lock.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	return s.DB.Get(key)
+}
+
+func (s *slowGets) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls
+}
+
+func TestCachedDB(t *testing.T) {
+	inner, err := NewKVDataBase("mem://cache/cachetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := &slowGets{KVWrapper: KVWrapper{inner}}
+	db := NewCachedDB(slow, CacheOptions{Size: 2, NegativeTTL: time.Minute})
+	inner.Set(&KVData{"key1", []byte("value1")})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if kvr := db.Get("key1"); !kvr.Result {
+				t.Error(kvr.Info)
+			}
+		}()
+	}
+	wg.Wait()
+	if slow.count() != 1 {
+		t.Fatalf("%d loads of concurrent misses", slow.count())
+	}
+	checkValue(t, db, "key1", "value1")
+
+	// negative caching
+	if db.Exists("missing") || db.Exists("missing") {
+		t.Fatal("missing key existed")
+	}
+	inner.Set(&KVData{"missing", []byte("behind cache")})
+	if db.Exists("missing") {
+		t.Fatal("negative entry not cached")
+	}
+	db.Invalidate("missing")
+	if !db.Exists("missing") {
+		t.Fatal("invalidated entry cached")
+	}
+
+	// write through and delete
+	db.Set(&KVData{"key2", []byte("value2")})
+	calls := slow.count()
+	checkValue(t, db, "key2", "value2")
+	if db.Delete("key2"); db.Exists("key2") || slow.count() != calls {
+		t.Fatal("write not cached")
+	}
+
+	s := db.CacheStats()
+	if s.Hits == 0 || s.NegativeHits != 3 || s.Loads != 3 || s.Len != 2 || s.Evictions == 0 {
+		t.Fatalf("stats: %+v", s)
+	}
+}
+
+func TestCachedDB_WriteAround(t *testing.T) {
+	inner, err := NewKVDataBase("mem://cache/around")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := Chain(inner, Cache(CacheOptions{Mode: WriteAround})).(*CachedDB)
+	db.Set(&KVData{"key", []byte("old")})
+	checkValue(t, db, "key", "old")
+	db.Set(&KVData{"key", []byte("new")})
+	if s := db.CacheStats(); s.Len != 0 {
+		t.Fatalf("set cached: %+v", s)
+	}
+	checkValue(t, db, "key", "new")
+	var out string
+	db.SetData("data", "document")
+	if kvr := db.GetData("data", &out); !kvr.Result || out != "document" {
+		t.Fatalf("data: %+v %q", kvr, out)
+	}
+}
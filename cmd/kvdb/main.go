@@ -0,0 +1,647 @@
+// kvdb - inspect and change databases of any registered uri
+//
+//	kvdb [-v raw|hex|json] [-json] <command> [flags] <uri> [args]
+//
+// commands:
+//
+//	get <uri> <key>                 print value of key
+//	set [-hex] [-ttl d] <uri> <key> <value|->
+//	                                set value of key, - reads stdin
+//	del <uri> <key>...              delete keys
+//	exists <uri> <key>              exit status 1 when key is missing
+//	ls [-page n] [-count n] [-prefix p] [-values] <uri>
+//	                                list keys of a page or with prefix
+//	count <uri>                     number of keys
+//	dump [-o file] <uri>            write all kvs to file or stdout in
+//	                                dump format
+//	load [-i file] <uri>            restore kvs written by dump
+//	copy [-prefix p] [-skip] [-batch n] [-resume cursor] <src> <dst>
+//	                                copy kvs between databases
+//	rebalance <shard uri> [removed uri]...
+//	                                move keys of a shard:// uri to their
+//	                                shards, and every key of removed ones
+//	serve [-addr host:port] [-readonly] [-metrics] <uri>...
+//	                                serve databases over http, see
+//	                                db.Handler
+//	resp [-addr host:port] [-password p] [-default hashkey] <uri>
+//	                                serve a database over the redis
+//	                                protocol, see db.RESPServer
+//
+// -v chooses how values are shown, -json prints one json object per
+// line for scripts
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	db "github.com/vinely/kvdb"
+)
+
+// errMissing - exit status 1 without message, for exists
+var errMissing = errors.New("missing")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// cli - state of one invocation
+type cli struct {
+	in      io.Reader
+	out     io.Writer
+	valueAs string
+	json    bool
+}
+
+// command - subcommand, args are after the command name
+type command func(c *cli, args []string) error
+
+var commands = map[string]command{
+	"get":       (*cli).get,
+	"set":       (*cli).set,
+	"del":       (*cli).del,
+	"exists":    (*cli).exists,
+	"ls":        (*cli).ls,
+	"count":     (*cli).count,
+	"dump":      (*cli).dump,
+	"load":      (*cli).load,
+	"copy":      (*cli).copy,
+	"rebalance": (*cli).rebalance,
+	"serve":     (*cli).serve,
+	"resp":      (*cli).resp,
+}
+
+// run - run command line args, return exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("kvdb", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	c := &cli{in: stdin, out: stdout}
+	fs.StringVar(&c.valueAs, "v", "raw", "show values as raw, hex or json")
+	fs.BoolVar(&c.json, "json", false, "machine readable output, one json object per line")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: kvdb [-v raw|hex|json] [-json] <command> [flags] <uri> [args]")
+		fmt.Fprintln(stderr, "commands: get set del exists ls count dump load copy rebalance serve resp")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	switch c.valueAs {
+	case "raw", "hex", "json":
+	default:
+		fmt.Fprintln(stderr, "kvdb: wrong -v parameter", c.valueAs)
+		return 2
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintln(stderr, "kvdb: unknown command", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+	err := cmd(c, fs.Args()[1:])
+	switch {
+	case err == nil:
+		return 0
+	case err == errMissing:
+		return 1
+	case err == flag.ErrHelp:
+		return 2
+	}
+	fmt.Fprintln(stderr, "kvdb:", err)
+	return 1
+}
+
+// flags - flag set of a subcommand
+func flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parse - parse flags of subcommand, check number of positional args
+func parse(fs *flag.FlagSet, args []string, min, max int, usage string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%v\nusage: kvdb %s %s", err, fs.Name(), usage)
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return fmt.Errorf("usage: kvdb %s %s", fs.Name(), usage)
+	}
+	return nil
+}
+
+// open - open database of uri, release it with the returned func
+func open(uri string) (db.KVMethods, func(), error) {
+	d, err := db.OpenOrGet(uri)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, func() { db.CloseKVDataBase(d.Name()) }, nil
+}
+
+// value - value shown as chosen by -v
+func (c *cli) value(v []byte) (string, error) {
+	switch c.valueAs {
+	case "hex":
+		return hex.EncodeToString(v), nil
+	case "json":
+		var data interface{}
+		if err := db.DecodeValue(v, &data); err != nil {
+			return "", err
+		}
+		b, err := json.MarshalIndent(data, "", "  ")
+		return string(b), err
+	}
+	return string(v), nil
+}
+
+// record - value as a field of machine readable output
+func (c *cli) record(v []byte) (interface{}, error) {
+	switch c.valueAs {
+	case "raw":
+		// []byte is base64 in json
+		return v, nil
+	case "json":
+		var data interface{}
+		err := db.DecodeValue(v, &data)
+		return data, err
+	}
+	return hex.EncodeToString(v), nil
+}
+
+// emit - print one machine readable object
+func (c *cli) emit(obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "%s\n", b)
+	return err
+}
+
+func (c *cli) get(args []string) error {
+	fs := flags("get")
+	if err := parse(fs, args, 2, 2, "<uri> <key>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	key := fs.Arg(1)
+	v, err := db.KVStoreOf(d).GetContext(context.Background(), key)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		r, err := c.record(v)
+		if err != nil {
+			return err
+		}
+		return c.emit(map[string]interface{}{"key": key, "value": r})
+	}
+	s, err := c.value(v)
+	if err != nil {
+		return err
+	}
+	if c.valueAs == "raw" {
+		_, err = io.WriteString(c.out, s)
+		return err
+	}
+	_, err = fmt.Fprintln(c.out, s)
+	return err
+}
+
+func (c *cli) set(args []string) error {
+	fs := flags("set")
+	isHex := fs.Bool("hex", false, "value is hex encoded")
+	ttl := fs.Duration("ttl", 0, "expire key after ttl")
+	if err := parse(fs, args, 3, 3, "[-hex] [-ttl d] <uri> <key> <value|->"); err != nil {
+		return err
+	}
+	var v []byte
+	if fs.Arg(2) == "-" {
+		b, err := io.ReadAll(c.in)
+		if err != nil {
+			return err
+		}
+		v = b
+	} else {
+		v = []byte(fs.Arg(2))
+	}
+	if *isHex {
+		b, err := hex.DecodeString(strings.TrimSpace(string(v)))
+		if err != nil {
+			return err
+		}
+		v = b
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	kv := &db.KVData{Key: fs.Arg(1), Value: v}
+	var kvr *db.KVResult
+	if *ttl > 0 {
+		e, ok := d.(db.KVExpire)
+		if !ok {
+			return errors.New(d.Name() + " doesn't support ttl")
+		}
+		kvr = e.SetWithTTL(kv, *ttl)
+	} else {
+		kvr = d.Set(kv)
+	}
+	if !kvr.Result {
+		return errors.New(kvr.Info)
+	}
+	if c.json {
+		return c.emit(map[string]interface{}{"key": kv.Key, "set": true})
+	}
+	return nil
+}
+
+func (c *cli) del(args []string) error {
+	fs := flags("del")
+	if err := parse(fs, args, 2, -1, "<uri> <key>..."); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	s := db.KVStoreOf(d)
+	for _, key := range fs.Args()[1:] {
+		err := s.DeleteContext(context.Background(), key)
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+		if c.json {
+			if err := c.emit(map[string]interface{}{"key": key, "deleted": err == nil}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cli) exists(args []string) error {
+	fs := flags("exists")
+	if err := parse(fs, args, 2, 2, "<uri> <key>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	ok, err := db.KVStoreOf(d).ExistsContext(context.Background(), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if c.json {
+		err = c.emit(map[string]interface{}{"key": fs.Arg(1), "exists": ok})
+	} else {
+		_, err = fmt.Fprintln(c.out, ok)
+	}
+	if err == nil && !ok {
+		return errMissing
+	}
+	return err
+}
+
+func (c *cli) ls(args []string) error {
+	fs := flags("ls")
+	page := fs.Uint("page", 0, "page number")
+	count := fs.Uint("count", 0, "keys of a page, 0 for the database count")
+	prefix := fs.String("prefix", "", "list keys with prefix instead of a page")
+	values := fs.Bool("values", false, "show values too")
+	if err := parse(fs, args, 1, 1, "[-page n] [-count n] [-prefix p] [-values] <uri>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	s, ok := d.(db.KVScanner)
+	if !ok {
+		return errors.New(d.Name() + " can't scan")
+	}
+	var items []db.KVData
+	if *prefix != "" {
+		items, err = s.ScanPrefix(*prefix)
+	} else {
+		items, err = pageItems(d, s, *page, *count)
+	}
+	if err != nil {
+		return err
+	}
+	for _, kv := range items {
+		if err := c.item(kv, *values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pageItems - kvs on page, count 0 uses the page size of the database
+func pageItems(d db.KVMethods, s db.KVScanner, page, count uint) ([]db.KVData, error) {
+	if count == 0 {
+		keys := d.ListKeys(page)
+		items := make([]db.KVData, 0, len(keys))
+		for _, k := range keys {
+			v, err := db.KVStoreOf(d).GetContext(context.Background(), k)
+			if err != nil {
+				continue
+			}
+			items = append(items, db.KVData{Key: k, Value: v})
+		}
+		return items, nil
+	}
+	cursor := ""
+	skip := page * count
+	for {
+		items, next, err := s.Scan(cursor, int(count))
+		if err != nil {
+			return nil, err
+		}
+		if uint(len(items)) > skip {
+			items = items[skip:]
+			if uint(len(items)) > count {
+				items = items[:count]
+			}
+			return items, nil
+		}
+		skip -= uint(len(items))
+		if next == "" {
+			return nil, nil
+		}
+		cursor = next
+	}
+}
+
+// item - print key and maybe value of a listed kv
+func (c *cli) item(kv db.KVData, values bool) error {
+	if c.json {
+		obj := map[string]interface{}{"key": kv.Key}
+		if values {
+			r, err := c.record(kv.Value)
+			if err != nil {
+				return err
+			}
+			obj["value"] = r
+		}
+		return c.emit(obj)
+	}
+	if !values {
+		_, err := fmt.Fprintln(c.out, kv.Key)
+		return err
+	}
+	s, err := c.value(kv.Value)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "%s\t%s\n", kv.Key, s)
+	return err
+}
+
+func (c *cli) count(args []string) error {
+	fs := flags("count")
+	if err := parse(fs, args, 1, 1, "<uri>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	n := d.KeyCount()
+	if c.json {
+		return c.emit(map[string]interface{}{"count": n})
+	}
+	_, err = fmt.Fprintln(c.out, n)
+	return err
+}
+
+func (c *cli) dump(args []string) error {
+	fs := flags("dump")
+	file := fs.String("o", "", "write to file instead of stdout")
+	if err := parse(fs, args, 1, 1, "[-o file] <uri>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	w := c.out
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return db.Dump(context.Background(), d, w)
+}
+
+func (c *cli) load(args []string) error {
+	fs := flags("load")
+	file := fs.String("i", "", "read from file instead of stdin")
+	if err := parse(fs, args, 1, 1, "[-i file] <uri>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	r := c.in
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := db.Restore(context.Background(), r, d); err != nil {
+		return err
+	}
+	if c.json {
+		return c.emit(map[string]interface{}{"loaded": true})
+	}
+	return nil
+}
+
+func (c *cli) copy(args []string) error {
+	fs := flags("copy")
+	opts := &db.CopyOptions{}
+	fs.StringVar(&opts.Prefix, "prefix", "", "only copy keys with prefix")
+	skip := fs.Bool("skip", false, "keep existing keys of destination")
+	fs.IntVar(&opts.BatchSize, "batch", 0, "kvs copied at once")
+	fs.StringVar(&opts.Resume, "resume", "", "cursor printed by an interrupted copy")
+	if err := parse(fs, args, 2, 2, "[-prefix p] [-skip] [-batch n] [-resume cursor] <src> <dst>"); err != nil {
+		return err
+	}
+	if *skip {
+		opts.Policy = db.CopySkipExisting
+	}
+	src, srcDone, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer srcDone()
+	dst, dstDone, err := open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer dstDone()
+	start := time.Now()
+	p, err := db.Copy(context.Background(), src, dst, opts)
+	if err != nil {
+		if p.Cursor != "" {
+			return fmt.Errorf("%v\nresume with -resume %s", err, p.Cursor)
+		}
+		return err
+	}
+	if c.json {
+		return c.emit(map[string]interface{}{"copied": p.Copied, "skipped": p.Skipped})
+	}
+	_, err = fmt.Fprintf(c.out, "copied %d, skipped %d in %v\n", p.Copied, p.Skipped, time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+func (c *cli) rebalance(args []string) error {
+	fs := flags("rebalance")
+	if err := parse(fs, args, 1, -1, "<shard uri> [removed uri]..."); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	s, ok := d.(*db.ShardedDB)
+	if !ok {
+		return errors.New(d.Name() + " isn't sharded")
+	}
+	start := time.Now()
+	ctx := context.Background()
+	moved, err := s.Rebalance(ctx)
+	for _, uri := range fs.Args()[1:] {
+		if err != nil {
+			break
+		}
+		var old db.KVMethods
+		var oldDone func()
+		if old, oldDone, err = open(uri); err != nil {
+			break
+		}
+		var n int
+		n, err = s.Drain(ctx, old)
+		moved += n
+		oldDone()
+	}
+	if err != nil {
+		return fmt.Errorf("%v\nmoved %d, run it again to go on", err, moved)
+	}
+	if c.json {
+		return c.emit(map[string]interface{}{"moved": moved})
+	}
+	_, err = fmt.Fprintf(c.out, "moved %d in %v\n", moved, time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+func (c *cli) serve(args []string) error {
+	fs := flags("serve")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	readOnly := fs.Bool("readonly", false, "refuse writes")
+	metrics := fs.Bool("metrics", false, "record metrics of databases, served on /metrics")
+	if err := parse(fs, args, 1, -1, "[-addr host:port] [-readonly] [-metrics] <uri>..."); err != nil {
+		return err
+	}
+	for _, uri := range fs.Args() {
+		d, done, err := open(uri)
+		if err != nil {
+			return err
+		}
+		defer done()
+		if *metrics {
+			db.Chain(d, db.Instrument)
+		}
+	}
+	h := db.NewHandler()
+	h.ReadOnly = *readOnly
+	mux := http.NewServeMux()
+	mux.Handle("/db", h)
+	mux.Handle("/db/", h)
+	if *metrics {
+		mux.Handle("/metrics", db.MetricsHandler())
+	}
+	srv := &http.Server{Addr: *addr, Handler: mux}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	fmt.Fprintf(c.out, "serving %d databases on %s\n", fs.NArg(), *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (c *cli) resp(args []string) error {
+	fs := flags("resp")
+	addr := fs.String("addr", "localhost:6379", "address to listen on")
+	password := fs.String("password", "", "password clients AUTH with")
+	def := fs.String("default", "default", "hash key of GET, SET, DEL and SCAN")
+	if err := parse(fs, args, 1, 1, "[-addr host:port] [-password p] [-default hashkey] <uri>"); err != nil {
+		return err
+	}
+	d, done, err := open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer done()
+	srv := db.NewRESPServer(d)
+	srv.Password = *password
+	srv.Default = *def
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	go func() {
+		<-stop
+		srv.Close()
+	}()
+	fmt.Fprintf(c.out, "serving %s over redis protocol on %s\n", d.Name(), l.Addr())
+	return srv.Serve(l)
+}
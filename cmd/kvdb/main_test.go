@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// kvdb - run command line, return stdout and exit status
+func kvdb(t *testing.T, stdin string, args ...string) (string, int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &out, &errOut)
+	if status > 1 || (status == 1 && errOut.Len() > 0) {
+		t.Logf("kvdb %v: %s", args, errOut.String())
+	}
+	return out.String(), status
+}
+
+func TestCLI(t *testing.T) {
+	uri := "bolt://cli.db/cli?path=" + t.TempDir()
+	if _, st := kvdb(t, "", "set", uri, "user:1", `{"name":"bob"}`); st != 0 {
+		t.Fatal("set", st)
+	}
+	if _, st := kvdb(t, "value2", "set", uri, "user:2", "-"); st != 0 {
+		t.Fatal("set from stdin", st)
+	}
+	if _, st := kvdb(t, "", "set", "-hex", uri, "bin", "00ff"); st != 0 {
+		t.Fatal("set hex", st)
+	}
+	if out, _ := kvdb(t, "", "get", uri, "user:2"); out != "value2" {
+		t.Fatalf("get raw: %q", out)
+	}
+	if out, _ := kvdb(t, "", "-v", "hex", "get", uri, "bin"); out != "00ff\n" {
+		t.Fatalf("get hex: %q", out)
+	}
+	if out, _ := kvdb(t, "", "-v", "json", "get", uri, "user:1"); out != "{\n  \"name\": \"bob\"\n}\n" {
+		t.Fatalf("get json: %q", out)
+	}
+	if out, _ := kvdb(t, "", "-json", "-v", "hex", "get", uri, "bin"); out != `{"key":"bin","value":"00ff"}`+"\n" {
+		t.Fatalf("get machine: %q", out)
+	}
+	if _, st := kvdb(t, "", "get", uri, "nokey"); st != 1 {
+		t.Fatal("get missing key", st)
+	}
+	if _, st := kvdb(t, "", "exists", uri, "nokey"); st != 1 {
+		t.Fatal("exists missing key", st)
+	}
+	if out, _ := kvdb(t, "", "ls", "-prefix", "user:", uri); out != "user:1\nuser:2\n" {
+		t.Fatalf("ls prefix: %q", out)
+	}
+	if out, _ := kvdb(t, "", "ls", "-count", "2", "-page", "1", uri); out != "user:2\n" {
+		t.Fatalf("ls page: %q", out)
+	}
+	if out, _ := kvdb(t, "", "-json", "count", uri); out != `{"count":3}`+"\n" {
+		t.Fatalf("count: %q", out)
+	}
+
+	dump, st := kvdb(t, "", "dump", uri)
+	if st != 0 {
+		t.Fatal("dump", st)
+	}
+	copyURI := "bolt://cli.db/loaded?path=" + t.TempDir()
+	if _, st := kvdb(t, dump, "load", copyURI); st != 0 {
+		t.Fatal("load", st)
+	}
+	if out, _ := kvdb(t, "", "-v", "hex", "get", copyURI, "bin"); out != "00ff\n" {
+		t.Fatalf("loaded value: %q", out)
+	}
+
+	dst := "bolt://copy.db/copied?path=" + t.TempDir()
+	if out, st := kvdb(t, "", "-json", "copy", "-prefix", "user:", uri, dst); st != 0 || out != `{"copied":2,"skipped":0}`+"\n" {
+		t.Fatalf("copy: %q %d", out, st)
+	}
+	if _, st := kvdb(t, "", "del", uri, "user:1", "user:2"); st != 0 {
+		t.Fatal("del", st)
+	}
+	if out, _ := kvdb(t, "", "count", uri); out != "1\n" {
+		t.Fatalf("count after del: %q", out)
+	}
+	if _, st := kvdb(t, "", "nope"); st != 2 {
+		t.Fatal("unknown command", st)
+	}
+}
+
+func TestCLI_Rebalance(t *testing.T) {
+	old := "mem://clishard/old"
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5"} {
+		if _, st := kvdb(t, "", "set", old, key, "v"); st != 0 {
+			t.Fatal("set", st)
+		}
+	}
+	shard := "shard://cli?db=" + url.QueryEscape("mem://clishard/a") + "&db=" + url.QueryEscape("mem://clishard/b")
+	if out, st := kvdb(t, "", "-json", "rebalance", shard, old); st != 0 || out != `{"moved":5}`+"\n" {
+		t.Fatalf("rebalance: %q %d", out, st)
+	}
+	if out, _ := kvdb(t, "", "count", shard); out != "5\n" {
+		t.Fatalf("count: %q", out)
+	}
+	if out, _ := kvdb(t, "", "count", old); out != "0\n" {
+		t.Fatalf("count of removed: %q", out)
+	}
+	if _, st := kvdb(t, "", "rebalance", old); st != 1 {
+		t.Fatal("rebalance of a db not sharded", st)
+	}
+}
+
+func TestCLI_ServeMetrics(t *testing.T) {
+	uri := "mem://cliserve/served"
+	if _, st := kvdb(t, "", "set", uri, "user:1", "v"); st != 0 {
+		t.Fatal("set", st)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	done := make(chan int, 1)
+	go func() {
+		_, st := kvdb(t, "", "serve", "-metrics", "-addr", addr, uri)
+		done <- st
+	}()
+	base := "http://" + addr
+	var resp *http.Response
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if resp, err = http.Get(base + "/db/Memdb_served/keys?prefix=user:"); err == nil {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal(err)
+		}
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// scans of the instrumented db are still served
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"Key":"user:1"`) {
+		t.Fatalf("list: %d %s", resp.StatusCode, body)
+	}
+	req, _ := http.NewRequest(http.MethodPut, base+"/db/Memdb_served/keys/user:2", strings.NewReader("v"))
+	req.Header.Set("If-None-Match", "*")
+	if resp, err = http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("conditional put: %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if resp, err = http.Get(base + "/db/Memdb_served/keys/user:2"); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp, err = http.Get(base + "/metrics"); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `kvdb_operations_total{scheme="mem",db="Memdb_served",op="Get"} 1`) {
+		t.Fatalf("metrics:\n%s", body)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case st := <-done:
+		if st != 0 {
+			t.Fatal("serve", st)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("serve not stopped")
+	}
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ErrUnknownCodec - codec name wasn't registered
+var ErrUnknownCodec = errors.New("kvdb: unknown codec")
+
+// DefaultCodec - codec of databases opened without codec parameter
+const DefaultCodec = "json"
+
+// Codec - encoding of data values used by SetData and GetData
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// codecs - registered codecs by name, guarded by codecLock
+	codecs    = make(map[string]Codec)
+	codecLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(gobCodec{})
+	RegisterCodec(msgpackCodec{})
+	RegisterCodec(protoMethodsCodec{})
+}
+
+// RegisterCodec - register codec by its name, replacing one of the same name
+// so ?codec=<name> can select it
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[c.Name()] = c
+}
+
+// GetCodec - registered codec of name
+func GetCodec(name string) (Codec, error) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+// codecParam - codec of uri parameter, "" for DefaultCodec
+func codecParam(name string) (Codec, error) {
+	if name == "" {
+		name = DefaultCodec
+	}
+	c, err := GetCodec(name)
+	if err != nil {
+		return nil, errors.New("wrong codec parameter")
+	}
+	return c, nil
+}
+
+// codecMagic - first byte of a value tagged with its codec
+// never starts a json document, so plain json values are told apart
+const codecMagic = 0xC1
+
+// EncodeValue - value of data encoded by c and tagged with the codec name
+// json values are left untagged, so they stay readable as plain json
+func EncodeValue(c Codec, data interface{}) ([]byte, error) {
+	payload, err := c.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if c.Name() == DefaultCodec {
+		return payload, nil
+	}
+	name := c.Name()
+	if len(name) > 255 {
+		return nil, errors.New("codec name too long")
+	}
+	value := make([]byte, 0, 2+len(name)+len(payload))
+	value = append(value, codecMagic, byte(len(name)))
+	value = append(value, name...)
+	return append(value, payload...), nil
+}
+
+// ValueCodec - name of the codec value was encoded with and its payload
+// untagged values are json
+func ValueCodec(value []byte) (name string, payload []byte) {
+	if len(value) < 2 || value[0] != codecMagic || len(value) < 2+int(value[1]) {
+		return DefaultCodec, value
+	}
+	n := int(value[1])
+	return string(value[2 : 2+n]), value[2+n:]
+}
+
+// DecodeValue - decode value into out by the codec it was encoded with
+func DecodeValue(value []byte, out interface{}) error {
+	name, payload := ValueCodec(value)
+	c, err := GetCodec(name)
+	if err != nil {
+		return err
+	}
+	return c.Unmarshal(payload, out)
+}
+
+// setData - SetData of backends encoding with c
+func setData(db KVMethods, c Codec, key string, data interface{}) *KVResult {
+	value, err := EncodeValue(c, data)
+	if err != nil {
+		return &KVResult{
+			Result: false,
+			Info:   err.Error(),
+		}
+	}
+	return db.Set(&KVData{key, value})
+}
+
+// getData - GetData of backends
+func getData(db KVMethods, key string, out interface{}) *KVResult {
+	value, err := KVStoreOf(db).GetContext(context.Background(), key)
+	if err == nil {
+		err = DecodeValue(value, out)
+	}
+	if err != nil {
+		return &KVResult{
+			Result: false,
+			Info:   err.Error(),
+		}
+	}
+	return &KVResult{
+		Data:   out,
+		Result: true,
+	}
+}
+
+// jsonCodec - json compatible with encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Unmarshal(data, v)
+}
+
+// gobCodec - encoding/gob, every value carries its own type description
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// protoMarshaler - protobuf message with a generated Marshal method
+type protoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// protoUnmarshaler - protobuf message with a generated Unmarshal method
+type protoUnmarshaler interface {
+	Unmarshal(data []byte) error
+}
+
+// protoMethodsCodec - codec named "protobuf" of messages with generated
+// Marshal and Unmarshal methods, like those of gogo/protobuf, any other
+// value fails
+// it has no protobuf runtime of its own, register another codec named
+// "protobuf" for messages of google.golang.org/protobuf
+type protoMethodsCodec struct{}
+
+func (protoMethodsCodec) Name() string {
+	return "protobuf"
+}
+
+func (protoMethodsCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(protoMarshaler)
+	if !ok {
+		return nil, errors.New("protobuf codec needs a message with Marshal method")
+	}
+	return m.Marshal()
+}
+
+func (protoMethodsCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(protoUnmarshaler)
+	if !ok {
+		return errors.New("protobuf codec needs a message with Unmarshal method")
+	}
+	return m.Unmarshal(data)
+}
+
+// msgpackCodec - MessagePack, structs are maps of field names
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpackMarshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpackUnmarshal(data, v)
+}
@@ -0,0 +1,215 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type codecUser struct {
+	Name    string
+	Age     int
+	Tags    []string
+	Score   float64
+	Avatar  []byte
+	Created time.Time
+	Skip    string `msgpack:"-"`
+	Nick    string `msgpack:"nick,omitempty"`
+}
+
+func testUser() codecUser {
+	return codecUser{
+		Name:    "user1",
+		Age:     -42,
+		Tags:    []string{"a", "b"},
+		Score:   3.5,
+		Avatar:  []byte{0, 1, 2},
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		c, err := GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := EncodeValue(c, testUser())
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if n, _ := ValueCodec(value); n != name {
+			t.Fatalf("%s value tagged %s", name, n)
+		}
+		var u codecUser
+		if err := DecodeValue(value, &u); err != nil {
+			t.Fatal(name, err)
+		}
+		if !reflect.DeepEqual(u, testUser()) {
+			t.Fatalf("%s: %+v", name, u)
+		}
+	}
+}
+
+func TestCodec_Msgpack(t *testing.T) {
+	var v interface{}
+	in := map[string]interface{}{"n": int64(-1), "s": "x", "l": []interface{}{true, nil}}
+	b, err := msgpackMarshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msgpackUnmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, in) {
+		t.Fatalf("%#v", v)
+	}
+	var small int8
+	b, _ = msgpackMarshal(1000)
+	if err := msgpackUnmarshal(b, &small); err == nil {
+		t.Fatal("overflow not detected")
+	}
+	if err := msgpackUnmarshal(b[:1], &v); err == nil {
+		t.Fatal("short data not detected")
+	}
+	// arrays of one array nested a million times
+	deep := bytes.Repeat([]byte{0x91}, 1<<20)
+	if err := msgpackUnmarshal(append(deep, 0xc0), &v); err == nil {
+		t.Fatal("deep nesting not detected")
+	}
+	if err := msgpackUnmarshal(append(bytes.Repeat([]byte{0x91}, msgpackMaxDepth), 0xc0), &v); err != nil {
+		t.Fatal(err)
+	}
+	// bin keys are strings, array and map keys fail
+	c, _ := GetCodec("msgpack")
+	if err := c.Unmarshal([]byte{0x81, 0xc4, 0x01, 'a', 0xc0}, &v); err != nil || !reflect.DeepEqual(v, map[interface{}]interface{}{"a": nil}) {
+		t.Fatalf("bin key: %#v %v", v, err)
+	}
+	for _, in := range [][]byte{{0x81, 0x90, 0xc0}, {0x81, 0x80, 0xc0}} {
+		if err := c.Unmarshal(in, &v); err == nil {
+			t.Fatalf("%x decoded", in)
+		}
+		var m map[interface{}]int
+		if err := c.Unmarshal(in, &m); err == nil {
+			t.Fatalf("%x decoded into map", in)
+		}
+	}
+}
+
+func FuzzMsgpack(f *testing.F) {
+	for _, v := range []interface{}{testUser(), map[string]interface{}{"l": []interface{}{1, "x", nil}}, 1.5, []byte{1}} {
+		b, err := msgpackMarshal(v)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte{0x81, 0xc4, 0x01, 'a', 0xc0})
+	f.Add([]byte{0x81, 0x90, 0xc0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// untrusted values fail, never panic
+		var v interface{}
+		msgpackUnmarshal(data, &v)
+		var u codecUser
+		msgpackUnmarshal(data, &u)
+		var m map[interface{}]interface{}
+		msgpackUnmarshal(data, &m)
+	})
+}
+
+// protoMsg - message with methods like generated protobuf code
+type protoMsg struct {
+	data string
+}
+
+func (m *protoMsg) Marshal() ([]byte, error) {
+	return []byte(m.data), nil
+}
+
+func (m *protoMsg) Unmarshal(b []byte) error {
+	m.data = string(b)
+	return nil
+}
+
+func TestCodec_Protobuf(t *testing.T) {
+	c, err := GetCodec("protobuf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := EncodeValue(c, &protoMsg{"msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m protoMsg
+	if err := DecodeValue(value, &m); err != nil || m.data != "msg" {
+		t.Fatalf("%q %v", m.data, err)
+	}
+	// only messages with generated methods
+	if _, err := EncodeValue(c, testUser()); err == nil {
+		t.Fatal("struct without Marshal encoded")
+	}
+}
+
+func TestCodec_Param(t *testing.T) {
+	if _, err := NewKVDataBase("mem://codec/wrong?codec=nope"); err == nil {
+		t.Fatal("unknown codec accepted")
+	}
+	db, err := NewKVDataBase("mem://codec/msgpack?codec=msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Codec().Name() != "msgpack" {
+		t.Fatal(db.Codec().Name())
+	}
+}
+
+func testGetSetData(t *testing.T, db KVMethods) {
+	if kvr := db.SetData("user", testUser()); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	var u codecUser
+	if kvr := db.GetData("user", &u); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	if !reflect.DeepEqual(u, testUser()) {
+		t.Fatalf("%+v", u)
+	}
+	// plain json written before codecs is still read
+	db.Set(&KVData{"plain", []byte(`{"Name":"old"}`)})
+	if kvr := db.GetData("plain", &u); !kvr.Result || u.Name != "old" {
+		t.Fatalf("plain json: %v %+v", kvr.Info, u)
+	}
+	if kvr := db.GetData("nokey", &u); kvr.Result {
+		t.Fatal("get data of missing key")
+	}
+}
+
+func TestGetSetData_Mem(t *testing.T) {
+	db, err := NewKVDataBase("mem://codec/gob?codec=gob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGetSetData(t, db)
+}
+
+func TestGetSetData_Bolt(t *testing.T) {
+	db, err := NewKVDataBase("bolt://codec.db/codec?codec=msgpack&path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testGetSetData(t, db)
+	// a value is read by the codec it was written with
+	json, err := NewKVDataBase("mem://codec/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := KVStoreOf(db).GetContext(context.Background(), "user")
+	json.Set(&KVData{"user", value})
+	var u codecUser
+	if kvr := json.GetData("user", &u); !kvr.Result || u.Name != "user1" {
+		t.Fatalf("msgpack value in json db: %v %+v", kvr.Info, u)
+	}
+}
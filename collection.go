@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Collection - typed values of T stored in a database
+// values are encoded by the database codec and decoded by the codec
+// they were written with, so no caller has to type-assert KVResult.Data
+type Collection[T any] struct {
+	db    KVMethods
+	store KVStore
+	key   func(v T) string
+}
+
+// NewCollection - collection of T in db
+// key gets the key of a value for Save, when it's nil the key is the
+// field of T tagged `kvdb:"key"`
+// example:
+//
+//	type User struct {
+//	    ID   string `kvdb:"key"`
+//	    Name string
+//	}
+//	users, err := NewCollection[User](db, nil)
+func NewCollection[T any](db KVMethods, key func(v T) string) (*Collection[T], error) {
+	if key == nil {
+		var err error
+		key, err = tagKey[T]()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Collection[T]{
+		db:    db,
+		store: KVStoreOf(db),
+		key:   key,
+	}, nil
+}
+
+// tagKey - key function of the field of T tagged `kvdb:"key"`
+// T is a struct or a pointer to struct
+func tagKey[T any]() (func(v T) string, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("collection of " + t.String() + " needs a key function")
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("kvdb") != "key" {
+			continue
+		}
+		index := i
+		return func(v T) string {
+			rv := reflect.ValueOf(&v).Elem()
+			if ptr {
+				if rv.IsNil() {
+					return ""
+				}
+				rv = rv.Elem()
+			}
+			return fmt.Sprint(rv.Field(index).Interface())
+		}, nil
+	}
+	return nil, errors.New(t.String() + ` has no field tagged kvdb:"key"`)
+}
+
+// DB - database of collection
+func (c *Collection[T]) DB() KVMethods {
+	return c.db
+}
+
+// Key - key of v
+func (c *Collection[T]) Key(v T) string {
+	return c.key(v)
+}
+
+// Get - value of key, ErrNotFound if key doesn't exist
+func (c *Collection[T]) Get(key string) (T, error) {
+	var v T
+	value, err := c.store.GetContext(context.Background(), key)
+	if err != nil {
+		return v, err
+	}
+	err = DecodeValue(value, &v)
+	return v, err
+}
+
+// Put - set value of key
+func (c *Collection[T]) Put(key string, v T) error {
+	value, err := EncodeValue(c.db.Codec(), v)
+	if err != nil {
+		return err
+	}
+	return c.store.SetContext(context.Background(), key, value)
+}
+
+// Save - set v under its own key
+func (c *Collection[T]) Save(v T) error {
+	key := c.key(v)
+	if key == "" {
+		return errors.New("empty key")
+	}
+	return c.Put(key, v)
+}
+
+// Delete - delete key, ErrNotFound if key doesn't exist
+func (c *Collection[T]) Delete(key string) error {
+	return c.store.DeleteContext(context.Background(), key)
+}
+
+// All - every value of collection
+// in key order when the database is a KVScanner
+func (c *Collection[T]) All() ([]T, error) {
+	var list []T
+	var err error
+	add := func(k, value []byte) error {
+		var v T
+		if err := DecodeValue(value, &v); err != nil {
+			return fmt.Errorf("%s: %v", k, err)
+		}
+		list = append(list, v)
+		return nil
+	}
+	if s, ok := c.db.(KVScanner); ok {
+		serr := scanPages(s, scanBatch, func(kv *KVData) bool {
+			err = add([]byte(kv.Key), kv.Value)
+			return err == nil
+		})
+		if err == nil {
+			err = serr
+		}
+	} else {
+		err = c.store.ScanContext(context.Background(), add)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Scan - at most limit values after cursor, like KVScanner.Scan
+// keys[i] is the key of values[i]
+func (c *Collection[T]) Scan(cursor string, limit int) (keys []string, values []T, nextCursor string, err error) {
+	s, ok := c.db.(KVScanner)
+	if !ok {
+		return nil, nil, "", errors.New(c.db.Name() + " can't scan")
+	}
+	items, next, err := s.Scan(cursor, limit)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	keys = make([]string, len(items))
+	values = make([]T, len(items))
+	for i := range items {
+		keys[i] = items[i].Key
+		if err := DecodeValue(items[i].Value, &values[i]); err != nil {
+			return nil, nil, "", fmt.Errorf("%s: %v", items[i].Key, err)
+		}
+	}
+	return keys, values, next, nil
+}
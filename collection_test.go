@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+type collUser struct {
+	ID   string `kvdb:"key"`
+	Name string
+	Age  int
+}
+
+func testCollection(t *testing.T, db KVMethods) {
+	users, err := NewCollection[collUser](db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []collUser{{"u2", "bob", 30}, {"u1", "alice", 20}, {"u3", "carol", 40}} {
+		if err := users.Save(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := users.Get("u1")
+	if err != nil || u.Name != "alice" || u.Age != 20 {
+		t.Fatalf("get u1: %+v %v", u, err)
+	}
+	if _, err := users.Get("nokey"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get nokey: %v", err)
+	}
+	all, err := users.All()
+	if err != nil || len(all) != 3 || all[0].ID != "u1" || all[2].ID != "u3" {
+		t.Fatalf("all: %+v %v", all, err)
+	}
+	keys, values, next, err := users.Scan("", 2)
+	if err != nil || len(keys) != 2 || keys[1] != values[1].ID || next == "" {
+		t.Fatalf("scan: %v %+v %q %v", keys, values, next, err)
+	}
+	if err := users.Delete("u2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete("u2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete twice: %v", err)
+	}
+	// values which are not users are reported, not panicked on
+	db.Set(&KVData{"bad", []byte("not json")})
+	if _, err := users.Get("bad"); err == nil {
+		t.Fatal("decoded bad value")
+	}
+}
+
+func TestCollection_Mem(t *testing.T) {
+	db, err := NewKVDataBase("mem://collection/users?codec=msgpack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCollection(t, db)
+}
+
+func TestCollection_Bolt(t *testing.T) {
+	db, err := NewKVDataBase("bolt://collection.db/users?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testCollection(t, db)
+}
+
+func TestCollection_Key(t *testing.T) {
+	db, err := NewKVDataBase("mem://collection/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCollection[string](db, nil); err == nil {
+		t.Fatal("collection without key function")
+	}
+	names, err := NewCollection[string](db, func(v string) string { return "name:" + v })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := names.Save("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := names.Get("name:bob"); err != nil || v != "bob" {
+		t.Fatalf("%q %v", v, err)
+	}
+	ptrs, err := NewCollection[*collUser](db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ptrs.Save(&collUser{ID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := ptrs.Get("p1"); err != nil || u.ID != "p1" {
+		t.Fatalf("%+v %v", u, err)
+	}
+}
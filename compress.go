@@ -0,0 +1,194 @@
+package db
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrDecompress - stored value can't be decompressed
+var ErrDecompress = errors.New("kvdb: can't decompress")
+
+// compressMagic - first bytes of a compressed value, followed by one
+// byte of the algorithm
+// 0xC4 followed by 'k' is never valid UTF-8, so text values written
+// before compression are never mistaken for compressed ones
+const compressMagic = "\xC4kvz"
+
+// algorithms after compressMagic, values not worth compressing are
+// stored as they are, or after compressRaw if they start with the magic
+const (
+	compressRaw   = 'r'
+	compressGzip  = 'g'
+	compressFlate = 'f'
+	compressZlib  = 'z'
+)
+
+// compressHeaders - header of algorithm
+var compressHeaders = map[string]byte{
+	"gzip":  compressGzip,
+	"flate": compressFlate,
+	"zlib":  compressZlib,
+}
+
+// CompressOptions - settings of a CompressedDB
+type CompressOptions struct {
+	// Algorithm - gzip, flate or zlib, gzip by default
+	Algorithm string
+	// Level - compression level of compress/flate, 0 for the default
+	Level int
+	// MinSize - values shorter than MinSize are stored uncompressed
+	MinSize int
+}
+
+// CompressedDB - database compressing values of db
+// compressed values have a header telling how they're compressed, so
+// values written before or by other settings are still read
+// binary values written before compression starting with the magic
+// bytes of the header are misread, migrate them before wrapping db
+type CompressedDB struct {
+	*transformDB
+}
+
+// NewCompressedDB - wrap db to compress its values
+func NewCompressedDB(db KVMethods, opts CompressOptions) (*CompressedDB, error) {
+	c, err := newCompressor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressedDB{newTransformDB(db, c)}, nil
+}
+
+// compressWriter - writer of a compression algorithm
+type compressWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// compressor - transformer of CompressedDB
+type compressor struct {
+	header  byte
+	minSize int
+	// writers of header reused between values
+	writers sync.Pool
+}
+
+func newCompressor(opts CompressOptions) (*compressor, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = "gzip"
+	}
+	header, ok := compressHeaders[opts.Algorithm]
+	if !ok {
+		return nil, errors.New("unknown compression " + opts.Algorithm)
+	}
+	level := opts.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	// check level once, writers of the pool can't fail
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+	c := &compressor{header: header, minSize: opts.MinSize}
+	c.writers.New = func() interface{} {
+		var w compressWriter
+		switch header {
+		case compressGzip:
+			w, _ = gzip.NewWriterLevel(nil, level)
+		case compressFlate:
+			w, _ = flate.NewWriter(nil, level)
+		default:
+			w, _ = zlib.NewWriterLevel(nil, level)
+		}
+		return w
+	}
+	return c, nil
+}
+
+func (c *compressor) storedKey(key string) string {
+	return key
+}
+
+func (c *compressor) storedKeys(key string) []string {
+	return []string{key}
+}
+
+func (c *compressor) key(stored string) (string, error) {
+	return stored, nil
+}
+
+func (c *compressor) ordered() bool {
+	return true
+}
+
+// compressHeader - magic and algorithm
+func compressHeader(alg byte) []byte {
+	return append([]byte(compressMagic), alg)
+}
+
+// raw - value stored uncompressed
+func raw(value []byte) []byte {
+	if !bytes.HasPrefix(value, []byte(compressMagic)) {
+		return value
+	}
+	return append(compressHeader(compressRaw), value...)
+}
+
+func (c *compressor) seal(key string, value []byte) ([]byte, error) {
+	if len(value) < c.minSize {
+		return raw(value), nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(value)/2 + 16)
+	buf.Write(compressHeader(c.header))
+	w := c.writers.Get().(compressWriter)
+	defer c.writers.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	// not worth it
+	if buf.Len() >= len(value) {
+		return raw(value), nil
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *compressor) open(key string, stored []byte) ([]byte, error) {
+	n := len(compressMagic) + 1
+	if len(stored) < n || !bytes.HasPrefix(stored, []byte(compressMagic)) {
+		// not worth compressing, or written before compression
+		return stored, nil
+	}
+	var r io.ReadCloser
+	var err error
+	body := bytes.NewReader(stored[n:])
+	switch stored[n-1] {
+	case compressRaw:
+		return stored[n:], nil
+	case compressGzip:
+		r, err = gzip.NewReader(body)
+	case compressFlate:
+		r = flate.NewReader(body)
+	case compressZlib:
+		r, err = zlib.NewReader(body)
+	default:
+		return nil, ErrDecompress
+	}
+	if err != nil {
+		return nil, ErrDecompress
+	}
+	defer r.Close()
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return nil, ErrDecompress
+	}
+	return value, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// document - repetitive json like documents stored by SetData
+func document(i int) []byte {
+	var b strings.Builder
+	b.WriteString(`{"items":[`)
+	for j := 0; j < 40; j++ {
+		if j > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `{"id":%d,"name":"item %d","tags":["red","green","blue"],"active":true}`, i*100+j, j)
+	}
+	b.WriteString(`]}`)
+	return []byte(b.String())
+}
+
+func TestCompressedDB(t *testing.T) {
+	inner, err := NewKVDataBase("bolt://compress.db/comptest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	ctx := context.Background()
+	// written before compression
+	inner.Set(&KVData{"old", []byte("plain")})
+	legacy := [][]byte{[]byte("Ćma"), []byte("ŀ"), {0xC4}, {0xC5, 'x'}, {0xC6, 0}, {0xC7, 0xC4}}
+	for i, v := range legacy {
+		inner.Set(&KVData{fmt.Sprint("legacy", i), v})
+	}
+	magic := []byte(compressMagic + "fvalue")
+
+	doc := document(1)
+	for _, alg := range []string{"gzip", "flate", "zlib"} {
+		db, err := NewCompressedDB(inner, CompressOptions{Algorithm: alg, MinSize: 64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Set(&KVData{alg, doc})
+		db.Set(&KVData{"small", []byte("tiny")})
+		db.Set(&KVData{"header", magic})
+		stored, _ := KVStoreOf(inner).GetContext(ctx, alg)
+		if !bytes.HasPrefix(stored, compressHeader(compressHeaders[alg])) || len(stored) >= len(doc)/4 {
+			t.Fatalf("%s: stored %d bytes of %d", alg, len(stored), len(doc))
+		}
+		checkValue(t, db, "small", "tiny")
+		checkValue(t, db, "header", string(magic))
+		checkValue(t, db, "old", "plain")
+		for i, v := range legacy {
+			checkValue(t, db, fmt.Sprint("legacy", i), string(v))
+		}
+	}
+
+	// values of every algorithm are read by any settings
+	db, _ := NewCompressedDB(inner, CompressOptions{})
+	for _, alg := range []string{"gzip", "flate", "zlib"} {
+		if v, err := db.GetContext(ctx, alg); err != nil || !bytes.Equal(v, doc) {
+			t.Fatalf("%s: %v", alg, err)
+		}
+	}
+	kvs, err := db.ScanPrefix("g")
+	if err != nil || len(kvs) != 1 || !bytes.Equal(kvs[0].Value, doc) {
+		t.Fatalf("scan: %v", err)
+	}
+	// damaged values fail instead of being returned as stored
+	stored, _ := KVStoreOf(inner).GetContext(ctx, "zlib")
+	KVStoreOf(inner).SetContext(ctx, "damaged", stored[:len(stored)/2])
+	if _, err := db.GetContext(ctx, "damaged"); err != ErrDecompress {
+		t.Fatalf("damaged value: %v", err)
+	}
+	if _, err := NewCompressedDB(inner, CompressOptions{Algorithm: "lz4"}); err == nil {
+		t.Fatal("unknown algorithm accepted")
+	}
+	if _, err := NewCompressedDB(inner, CompressOptions{Level: 42}); err == nil {
+		t.Fatal("wrong level accepted")
+	}
+}
+
+func benchmarkCompression(b *testing.B, opts *CompressOptions) {
+	inner, err := NewKVDataBase("mem://compress/" + b.Name())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	var db KVMethods = inner
+	if opts != nil {
+		if db, err = NewCompressedDB(inner, *opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+	docs := make([][]byte, 100)
+	size := 0
+	for i := range docs {
+		docs[i] = document(i)
+		size += len(docs[i])
+	}
+	store := KVStoreOf(db)
+	ctx := context.Background()
+	for i := range docs {
+		store.SetContext(ctx, fmt.Sprint(i), docs[i])
+	}
+	b.Run("Set", func(b *testing.B) {
+		b.SetBytes(int64(size / len(docs)))
+		for i := 0; i < b.N; i++ {
+			store.SetContext(ctx, fmt.Sprint(i%len(docs)), docs[i%len(docs)])
+		}
+		stored := 0
+		KVStoreOf(inner).ScanContext(ctx, func(k, v []byte) error {
+			stored += len(v)
+			return nil
+		})
+		b.ReportMetric(float64(stored)/float64(size), "stored/raw")
+	})
+	b.Run("Get", func(b *testing.B) {
+		b.SetBytes(int64(size / len(docs)))
+		for i := 0; i < b.N; i++ {
+			if _, err := store.GetContext(ctx, fmt.Sprint(i%len(docs))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompression_None(b *testing.B) {
+	benchmarkCompression(b, nil)
+}
+
+func BenchmarkCompression_Gzip(b *testing.B) {
+	benchmarkCompression(b, &CompressOptions{Algorithm: "gzip"})
+}
+
+func BenchmarkCompression_GzipSpeed(b *testing.B) {
+	benchmarkCompression(b, &CompressOptions{Algorithm: "gzip", Level: 1})
+}
+
+func BenchmarkCompression_Flate(b *testing.B) {
+	benchmarkCompression(b, &CompressOptions{Algorithm: "flate"})
+}
+
+func BenchmarkCompression_Zlib(b *testing.B) {
+	benchmarkCompression(b, &CompressOptions{Algorithm: "zlib"})
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+// CopyPolicy - what Copy does with keys already in the destination
+type CopyPolicy int
+
+const (
+	// CopyOverwrite - replace values of existing keys
+	CopyOverwrite CopyPolicy = iota
+	// CopySkipExisting - keep values of existing keys
+	CopySkipExisting
+)
+
+// CopyOptions - options of Copy, zero value copies everything
+type CopyOptions struct {
+	// Prefix - only copy keys starting with prefix, only they are read
+	Prefix string
+	// Rewrite - destination key of a source key, "" skips the key
+	Rewrite func(key string) string
+	Policy  CopyPolicy
+	// BatchSize - kvs read and written at once, 0 for default
+	BatchSize int
+	// Progress - called after every batch written
+	Progress func(p CopyProgress)
+	// Resume - Cursor of the last progress of an interrupted copy
+	// copy goes on with the batch after it
+	Resume string
+}
+
+// CopyProgress - progress of Copy
+type CopyProgress struct {
+	Copied  int
+	Skipped int
+	// LastKey - last source key of the batches done
+	LastKey string
+	// Cursor - pass as CopyOptions.Resume to go on after the batches done
+	// "" when the copy finished
+	Cursor string
+}
+
+// Copy - copy kvs of src to dst
+// kvs are read by pages of src, or of its range of Prefix in key order,
+// written by MSet when dst is a KVBatch, and ctx is checked between
+// batches
+// the returned progress tells where to resume when err isn't nil
+func Copy(ctx context.Context, src, dst KVMethods, opts *CopyOptions) (CopyProgress, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	p := CopyProgress{Cursor: opts.Resume}
+	s, ok := src.(KVScanner)
+	if !ok {
+		return p, errors.New(src.Name() + " can't scan")
+	}
+	batch := opts.BatchSize
+	if batch <= 0 {
+		batch = scanBatch
+	}
+	scan := s.Scan
+	if opts.Prefix != "" {
+		scan = func(cursor string, limit int) ([]KVData, string, error) {
+			return rangePage(s, opts.Prefix, prefixEnd(opts.Prefix), cursor, limit)
+		}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return p, err
+		}
+		items, next, err := scan(p.Cursor, batch)
+		if err != nil {
+			return p, err
+		}
+		kvs := make([]KVData, 0, len(items))
+		for _, kv := range items {
+			key := kv.Key
+			if opts.Rewrite != nil {
+				key = opts.Rewrite(key)
+			}
+			if key == "" {
+				p.Skipped++
+				continue
+			}
+			kvs = append(kvs, KVData{key, kv.Value})
+		}
+		if opts.Policy == CopySkipExisting {
+			n := len(kvs)
+			if kvs, err = missingKVs(ctx, dst, kvs); err != nil {
+				return p, err
+			}
+			p.Skipped += n - len(kvs)
+		}
+		if err := writeKVs(dst, kvs); err != nil {
+			return p, err
+		}
+		p.Copied += len(kvs)
+		if len(items) > 0 {
+			p.LastKey = items[len(items)-1].Key
+		}
+		p.Cursor = next
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+		if next == "" {
+			return p, nil
+		}
+	}
+}
+
+// missingKVs - kvs whose keys are not in db
+func missingKVs(ctx context.Context, db KVMethods, kvs []KVData) ([]KVData, error) {
+	missing := kvs[:0]
+	s := KVStoreOf(db)
+	for _, kv := range kvs {
+		ok, err := s.ExistsContext(ctx, kv.Key)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if !ok {
+			missing = append(missing, kv)
+		}
+	}
+	return missing, nil
+}
+
+// writeKVs - set kvs in db, in one batch when db is a KVBatch
+func writeKVs(db KVMethods, kvs []KVData) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	var res []*KVResult
+	if b, ok := db.(KVBatch); ok {
+		res = b.MSet(kvs)
+	} else {
+		res = make([]*KVResult, len(kvs))
+		for i := range kvs {
+			res[i] = db.Set(&kvs[i])
+		}
+	}
+	for _, r := range res {
+		if !r.Result {
+			return errors.New(r.Info)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCopy_BoltToMem(t *testing.T) {
+	src, err := NewKVDataBase("bolt://copy.db/copysrc?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(src.Name())
+	for i := 0; i < 25; i++ {
+		src.Set(&KVData{fmt.Sprintf("user:%02d", i), []byte(fmt.Sprint(i))})
+	}
+	src.Set(&KVData{"other", []byte("x")})
+	dst, err := NewKVDataBase("mem://copy/copydst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.Set(&KVData{"u:00", []byte("kept")})
+
+	batches := 0
+	p, err := Copy(context.Background(), src, dst, &CopyOptions{
+		Prefix: "user:",
+		Rewrite: func(key string) string {
+			if key == "user:13" {
+				return ""
+			}
+			return "u:" + strings.TrimPrefix(key, "user:")
+		},
+		Policy:    CopySkipExisting,
+		BatchSize: 10,
+		Progress:  func(CopyProgress) { batches++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Copied != 23 || p.Skipped != 2 || p.Cursor != "" || batches != 3 {
+		t.Fatalf("progress: %+v batches %d", p, batches)
+	}
+	if v, _ := KVStoreOf(dst).GetContext(context.Background(), "u:00"); string(v) != "kept" {
+		t.Fatalf("existing key overwritten: %q", v)
+	}
+	if dst.Exists("u:13") || dst.Exists("other") || !dst.Exists("u:24") {
+		t.Fatal("wrong keys copied")
+	}
+}
+
+func TestCopy_Resume(t *testing.T) {
+	src, err := NewKVDataBase("mem://copy/resumesrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		src.Set(&KVData{fmt.Sprintf("key%02d", i), []byte("v")})
+	}
+	dst, err := NewKVDataBase("mem://copy/resumedst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := Copy(ctx, src, dst, &CopyOptions{
+		BatchSize: 10,
+		Progress: func(p CopyProgress) {
+			if p.Copied == 20 {
+				cancel()
+			}
+		},
+	})
+	if !errors.Is(err, context.Canceled) || p.Copied != 20 || p.LastKey != "key19" {
+		t.Fatalf("interrupted: %+v %v", p, err)
+	}
+	p, err = Copy(context.Background(), src, dst, &CopyOptions{Resume: p.Cursor})
+	if err != nil || p.Copied != 10 {
+		t.Fatalf("resumed: %+v %v", p, err)
+	}
+	if dst.KeyCount() != 30 {
+		t.Fatalf("copied %d keys", dst.KeyCount())
+	}
+}
+
+func TestCopy_Prefix(t *testing.T) {
+	db, err := NewKVDataBase("mem://copy/prefixsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		db.Set(&KVData{fmt.Sprintf("a%03d", i), []byte("v")})
+	}
+	for i := 0; i < 5; i++ {
+		db.Set(&KVData{fmt.Sprintf("p%d", i), []byte("v")})
+	}
+	// only the kvs of the prefix are read, without a full scan
+	src := &prefixOnly{MemBucket: db.(*MemBucket)}
+	dst, err := NewKVDataBase("mem://copy/prefixdst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := &CopyOptions{
+		Prefix:    "p",
+		BatchSize: 2,
+		Progress: func(p CopyProgress) {
+			if p.Copied == 2 {
+				cancel()
+			}
+		},
+	}
+	p, err := Copy(ctx, src, dst, opts)
+	if !errors.Is(err, context.Canceled) || p.LastKey != "p1" {
+		t.Fatalf("interrupted: %+v %v", p, err)
+	}
+	opts.Resume, opts.Progress = p.Cursor, nil
+	if p, err = Copy(context.Background(), src, dst, opts); err != nil || p.Copied != 3 {
+		t.Fatalf("resumed: %+v %v", p, err)
+	}
+	if dst.KeyCount() != 5 || src.read > 8 {
+		t.Fatalf("copied %d keys reading %d", dst.KeyCount(), src.read)
+	}
+}
+
+func TestCopy_SkipExistingError(t *testing.T) {
+	src, err := NewKVDataBase("mem://copy/skipsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set(&KVData{"key", []byte("v")})
+	// existence of keys can't be told
+	h := NewHandler()
+	db, dst := remoteDB(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		h.ServeHTTP(w, req)
+	}))
+	if _, err := Copy(context.Background(), src, dst, &CopyOptions{Policy: CopySkipExisting}); err == nil {
+		t.Fatal("copied without telling existing keys")
+	}
+	if db.Exists("key") {
+		t.Fatal("key copied")
+	}
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// dump format, JSON Lines:
+//
+//	{"format":"kvdb-dump","version":1,"scheme":"bolt","bucket":"service","codec":"json","created":"..."}
+//	{"k":"<base64 key>","v":"<base64 value>","crc":<crc32 of key and value>}
+//	...
+//	{"end":true,"count":<records>,"sha256":"<hex sha256 of all lines before>"}
+//
+// a dump without its end line is truncated
+
+// DumpFormat - format name in dump header
+const DumpFormat = "kvdb-dump"
+
+// DumpVersion - version of dumps written by Dump
+const DumpVersion = 1
+
+var (
+	// ErrDumpCorrupt - dump doesn't match its checksums
+	ErrDumpCorrupt = errors.New("kvdb: corrupted dump")
+	// ErrDumpTruncated - dump ended before its end line
+	ErrDumpTruncated = errors.New("kvdb: truncated dump")
+)
+
+// DumpHeader - first line of a dump
+type DumpHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Scheme  string    `json:"scheme"`
+	Bucket  string    `json:"bucket"`
+	Codec   string    `json:"codec"`
+	Created time.Time `json:"created"`
+}
+
+// dumpLine - record or end line of a dump
+type dumpLine struct {
+	Key    []byte `json:"k,omitempty"`
+	Value  []byte `json:"v"`
+	CRC    uint32 `json:"crc"`
+	End    bool   `json:"end,omitempty"`
+	Count  int    `json:"count,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// dumpEnd - end line of count records with sum of lines before
+type dumpEnd struct {
+	End    bool   `json:"end"`
+	Count  int    `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// recordCRC - checksum of a record
+func recordCRC(key, value []byte) uint32 {
+	c := crc32.NewIEEE()
+	c.Write(key)
+	c.Write(value)
+	return c.Sum32()
+}
+
+// bucketName - bucket or hashkey of db
+func bucketName(db KVMethods) string {
+	switch d := db.(type) {
+	case *BoltDB:
+		return d.Bucket
+	case *RedisDB:
+		return d.HashKey
+	case *MemBucket:
+		return d.Label
+	}
+	return db.Name()
+}
+
+// Dump - write every kv of db to w in dump format
+func Dump(ctx context.Context, db KVMethods, w io.Writer) error {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	bw := bufio.NewWriter(w)
+	sum := sha256.New()
+	out := io.MultiWriter(bw, sum)
+	line := func(v interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(b, '\n'))
+		return err
+	}
+	header := &DumpHeader{
+		Format:  DumpFormat,
+		Version: DumpVersion,
+		Scheme:  db.DBType().Scheme,
+		Bucket:  bucketName(db),
+		Codec:   db.Codec().Name(),
+		Created: time.Now().UTC(),
+	}
+	if err := line(header); err != nil {
+		return err
+	}
+	count := 0
+	err := KVStoreOf(db).ScanContext(ctx, func(k, v []byte) error {
+		count++
+		return line(&dumpLine{Key: k, Value: v, CRC: recordCRC(k, v)})
+	})
+	if err != nil {
+		return err
+	}
+	end := &dumpEnd{
+		End:    true,
+		Count:  count,
+		SHA256: hex.EncodeToString(sum.Sum(nil)),
+	}
+	b, err := json.Marshal(end)
+	if err != nil {
+		return err
+	}
+	if _, err := bw.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// dumpReader - reader of dump lines keeping sum of lines read
+type dumpReader struct {
+	r   *bufio.Reader
+	sum hash.Hash
+}
+
+// next - next line, sum is updated by the caller once it knows the
+// line isn't the end line
+func (d *dumpReader) next() ([]byte, error) {
+	b, err := d.r.ReadBytes('\n')
+	if err == io.EOF {
+		// no more lines, or the last one was cut
+		return nil, ErrDumpTruncated
+	}
+	return b, err
+}
+
+// ReadDumpHeader - header of dump read from r
+func ReadDumpHeader(r io.Reader) (*DumpHeader, error) {
+	d := &dumpReader{r: bufio.NewReader(r), sum: sha256.New()}
+	return d.header()
+}
+
+func (d *dumpReader) header() (*DumpHeader, error) {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	b, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	d.sum.Write(b)
+	h := &DumpHeader{}
+	if err := json.Unmarshal(b, h); err != nil || h.Format != DumpFormat {
+		return nil, errors.New("not a kvdb dump")
+	}
+	if h.Version != DumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", h.Version)
+	}
+	return h, nil
+}
+
+// Restore - set every kv of dump read from r in db
+// kvs are set while reading, so on any error db keeps the kvs read
+// before it, a corrupted or truncated part is reported by
+// ErrDumpCorrupt or ErrDumpTruncated
+func Restore(ctx context.Context, r io.Reader, db KVMethods) error {
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	d := &dumpReader{r: bufio.NewReader(r), sum: sha256.New()}
+	if _, err := d.header(); err != nil {
+		return err
+	}
+	batch := make([]KVData, 0, scanBatch)
+	// fail - write kvs read so far and return err
+	fail := func(err error) error {
+		writeKVs(db, batch)
+		return err
+	}
+	count := 0
+	for {
+		b, err := d.next()
+		if err != nil {
+			return fail(err)
+		}
+		var l dumpLine
+		if err := json.Unmarshal(b, &l); err != nil {
+			return fail(ErrDumpCorrupt)
+		}
+		if l.End {
+			if l.Count != count || l.SHA256 != hex.EncodeToString(d.sum.Sum(nil)) {
+				return fail(ErrDumpCorrupt)
+			}
+			return writeKVs(db, batch)
+		}
+		d.sum.Write(b)
+		if recordCRC(l.Key, l.Value) != l.CRC {
+			return fail(ErrDumpCorrupt)
+		}
+		if l.Value == nil {
+			l.Value = []byte{}
+		}
+		batch = append(batch, KVData{string(l.Key), l.Value})
+		count++
+		if len(batch) == cap(batch) {
+			if err := ctx.Err(); err != nil {
+				return fail(err)
+			}
+			if err := writeKVs(db, batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+}
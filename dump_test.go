@@ -0,0 +1,100 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func dumpKVs() []KVData {
+	return []KVData{
+		{"plain", []byte("value")},
+		{"binary", []byte{0, 0xff, '\n', 0x80}},
+		{"empty", []byte{}},
+		{"utf8 中", []byte("{\"a\":1}")},
+	}
+}
+
+func TestDump_RoundTrip(t *testing.T) {
+	src, err := NewKVDataBase("mem://dump/dumpsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range dumpKVs() {
+		src.Set(&KVData{kv.Key, kv.Value})
+	}
+	var buf bytes.Buffer
+	if err := Dump(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadDumpHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil || h.Scheme != "mem" || h.Bucket != "dumpsrc" || h.Codec != "json" {
+		t.Fatalf("header: %+v %v", h, err)
+	}
+	dst, err := NewKVDataBase("bolt://dump.db/dumpdst?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(dst.Name())
+	if err := Restore(context.Background(), bytes.NewReader(buf.Bytes()), dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range dumpKVs() {
+		v, err := KVStoreOf(dst).GetContext(context.Background(), kv.Key)
+		if err != nil || !bytes.Equal(v, kv.Value) {
+			t.Fatalf("%q: %q %v", kv.Key, v, err)
+		}
+	}
+	if dst.KeyCount() != len(dumpKVs()) {
+		t.Fatalf("restored %d keys", dst.KeyCount())
+	}
+}
+
+func TestDump_Damaged(t *testing.T) {
+	src, err := NewKVDataBase("mem://dump/damagedsrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range dumpKVs() {
+		src.Set(&KVData{kv.Key, kv.Value})
+	}
+	var buf bytes.Buffer
+	if err := Dump(context.Background(), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	dump := buf.String()
+	lines := strings.SplitAfter(dump, "\n")
+
+	truncated := strings.Join(lines[:len(lines)-2], "")
+	corrupted := strings.Replace(dump, `"v":"dmFsdWU="`, `"v":"dmFsdWF="`, 1)
+	dropped := lines[0] + strings.Join(lines[2:], "")
+	// records before the corrupted one, after the header
+	beforeCorrupted := strings.Count(dump[:strings.Index(dump, `"v":"dmFsdWU="`)], "\n") - 1
+	// kvs before the damage are restored
+	for name, c := range map[string]struct {
+		dump string
+		err  error
+		kept int
+	}{
+		"truncated": {truncated, ErrDumpTruncated, 4},
+		"cut line":  {dump[:len(dump)-3], ErrDumpTruncated, 4},
+		"corrupted": {corrupted, ErrDumpCorrupt, beforeCorrupted},
+		"dropped":   {dropped, ErrDumpCorrupt, 3},
+	} {
+		dst, err := NewKVDataBase("mem://dump/damaged" + strings.Replace(name, " ", "", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Restore(context.Background(), strings.NewReader(c.dump), dst); !errors.Is(err, c.err) {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if dst.KeyCount() != c.kept {
+			t.Fatalf("%s: kept %d keys", name, dst.KeyCount())
+		}
+	}
+	if err := Restore(context.Background(), strings.NewReader("{}\n"), src); err == nil {
+		t.Fatal("restored a non dump")
+	}
+}
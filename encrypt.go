@@ -0,0 +1,331 @@
+package db
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrDecrypt - value or key can't be decrypted by any key
+var ErrDecrypt = errors.New("kvdb: can't decrypt")
+
+// encryptedMagic - first byte of an encrypted value
+// [magic][key id uint32][nonce][AES-GCM ciphertext with key as AAD]
+const encryptedMagic = 0xE1
+
+// EncryptOptions - keys and settings of an EncryptedDB
+type EncryptOptions struct {
+	// Keys - AES keys of 16, 24 or 32 bytes by key id
+	// keep old keys while values encrypted by them are left
+	Keys map[uint32][]byte
+	// KeyID - id of the key encrypting new values
+	KeyID uint32
+	// EncryptKeys - encrypt keys too, deterministically so lookups still
+	// work, prefix and range scans then read every key
+	EncryptKeys bool
+	// ReadPlaintext - read values and keys that aren't encrypted as they
+	// are, for buckets written before encryption until Rekey is done
+	// a value starting with the magic byte 0xE1 is taken as encrypted,
+	// and fails with ErrDecrypt if it doesn't open
+	ReadPlaintext bool
+}
+
+// EncryptedDB - database encrypting values of db at rest
+// values are sealed by AES-GCM with the key as associated data, so a
+// value can't be moved to another key, and carry the id of their key
+type EncryptedDB struct {
+	*transformDB
+	enc *encryptor
+}
+
+// NewEncryptedDB - wrap db to encrypt its values
+func NewEncryptedDB(db KVMethods, opts EncryptOptions) (*EncryptedDB, error) {
+	enc, err := newEncryptor(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedDB{
+		transformDB: newTransformDB(db, enc),
+		enc:         enc,
+	}, nil
+}
+
+// encryptor - transformer of EncryptedDB
+type encryptor struct {
+	opts EncryptOptions
+	// key ids, current first
+	ids []uint32
+	// value ciphers by key id
+	values map[uint32]cipher.AEAD
+	// key ciphers and nonce keys by key id
+	keys   map[uint32]cipher.AEAD
+	nonces map[uint32][]byte
+}
+
+// derive - subkey of key for purpose
+func derive(key []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// newGCM - AES-GCM of key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newEncryptor(opts EncryptOptions) (*encryptor, error) {
+	if _, ok := opts.Keys[opts.KeyID]; !ok {
+		return nil, errors.New("no key of KeyID")
+	}
+	e := &encryptor{
+		opts:   opts,
+		values: make(map[uint32]cipher.AEAD),
+		keys:   make(map[uint32]cipher.AEAD),
+		nonces: make(map[uint32][]byte),
+	}
+	for id, key := range opts.Keys {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		e.values[id] = aead
+		// keys are encrypted by a subkey of the same size
+		kaead, err := newGCM(derive(key, "kvdb key encryption")[:len(key)])
+		if err != nil {
+			return nil, err
+		}
+		e.keys[id] = kaead
+		e.nonces[id] = derive(key, "kvdb key nonce")
+		if id != opts.KeyID {
+			e.ids = append(e.ids, id)
+		}
+	}
+	sort.Slice(e.ids, func(i, j int) bool { return e.ids[i] > e.ids[j] })
+	e.ids = append([]uint32{opts.KeyID}, e.ids...)
+	return e, nil
+}
+
+// sealKey - key encrypted by key of id
+// the nonce is a MAC of key, so the same key is always sealed the same
+func (e *encryptor) sealKey(id uint32, key string) string {
+	m := hmac.New(sha256.New, e.nonces[id])
+	m.Write([]byte(key))
+	aead := e.keys[id]
+	nonce := m.Sum(nil)[:aead.NonceSize()]
+	b := make([]byte, 4, 4+len(nonce)+len(key)+aead.Overhead())
+	binary.BigEndian.PutUint32(b, id)
+	b = append(b, nonce...)
+	b = aead.Seal(b, nonce, []byte(key), nil)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (e *encryptor) storedKey(key string) string {
+	if !e.opts.EncryptKeys {
+		return key
+	}
+	return e.sealKey(e.opts.KeyID, key)
+}
+
+func (e *encryptor) storedKeys(key string) []string {
+	if !e.opts.EncryptKeys {
+		return []string{key}
+	}
+	keys := make([]string, 0, len(e.ids)+1)
+	for _, id := range e.ids {
+		keys = append(keys, e.sealKey(id, key))
+	}
+	if e.opts.ReadPlaintext {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (e *encryptor) key(stored string) (string, error) {
+	if !e.opts.EncryptKeys {
+		return stored, nil
+	}
+	plain := func() (string, error) {
+		if e.opts.ReadPlaintext {
+			return stored, nil
+		}
+		return "", ErrDecrypt
+	}
+	b, err := base64.RawURLEncoding.DecodeString(stored)
+	if err != nil || len(b) < 4 {
+		return plain()
+	}
+	aead, ok := e.keys[binary.BigEndian.Uint32(b)]
+	if !ok || len(b) < 4+aead.NonceSize() {
+		return plain()
+	}
+	n := 4 + aead.NonceSize()
+	key, err := aead.Open(nil, b[4:n], b[n:], nil)
+	if err != nil {
+		return plain()
+	}
+	return string(key), nil
+}
+
+func (e *encryptor) ordered() bool {
+	return !e.opts.EncryptKeys
+}
+
+func (e *encryptor) seal(key string, value []byte) ([]byte, error) {
+	aead := e.values[e.opts.KeyID]
+	b := make([]byte, 5+aead.NonceSize(), 5+aead.NonceSize()+len(value)+aead.Overhead())
+	b[0] = encryptedMagic
+	binary.BigEndian.PutUint32(b[1:], e.opts.KeyID)
+	nonce := b[5:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(b, nonce, value, []byte(key)), nil
+}
+
+// keyID - id of key sealing stored value, ok is false for plaintext
+func keyID(stored []byte) (uint32, bool) {
+	if len(stored) < 5 || stored[0] != encryptedMagic {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(stored[1:]), true
+}
+
+// open - value of stored, only values without the magic byte are read
+// as plaintext, a tampered value or one of an unknown key fails
+func (e *encryptor) open(key string, stored []byte) ([]byte, error) {
+	id, ok := keyID(stored)
+	if !ok {
+		if e.opts.ReadPlaintext && (len(stored) == 0 || stored[0] != encryptedMagic) {
+			return stored, nil
+		}
+		return nil, ErrDecrypt
+	}
+	aead, ok := e.values[id]
+	if !ok || len(stored) < 5+aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	n := 5 + aead.NonceSize()
+	value, err := aead.Open(nil, stored[5:n], stored[n:], []byte(key))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return value, nil
+}
+
+// current - if stored kv is encrypted by the current key
+func (e *encryptor) current(key, storedKey string, stored []byte) bool {
+	id, ok := keyID(stored)
+	return ok && id == e.opts.KeyID && storedKey == e.storedKey(key)
+}
+
+// Rekey - encrypt every kv not encrypted by the current key again, in
+// place, return number of kvs rewritten
+// run it after changing KeyID, old keys can be dropped when it's done
+// kvs of a page are rewritten in one transaction when db supports it
+func (e *EncryptedDB) Rekey(ctx context.Context) (int, error) {
+	s, ok := e.db.(KVScanner)
+	if !ok {
+		return 0, e.unsupported("scan")
+	}
+	n := 0
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		items, next, err := s.Scan(cursor, scanBatch)
+		if err != nil {
+			return n, err
+		}
+		var stale []string
+		for _, kv := range items {
+			key, err := e.enc.key(kv.Key)
+			if err != nil {
+				return n, err
+			}
+			if !e.enc.current(key, kv.Key, kv.Value) {
+				stale = append(stale, kv.Key)
+			}
+		}
+		if len(stale) > 0 {
+			done, err := e.rekey(ctx, stale)
+			n += done
+			if err != nil {
+				return n, err
+			}
+		}
+		if next == "" {
+			return n, nil
+		}
+		cursor = next
+	}
+}
+
+// rekey - encrypt kvs of stored keys again by the current key
+func (e *EncryptedDB) rekey(ctx context.Context, stored []string) (int, error) {
+	n := 0
+	rewrite := func(get func(string) ([]byte, error), set func(string, []byte) error, del func(string) error) error {
+		for _, sk := range stored {
+			v, err := get(sk)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			key, err := e.enc.key(sk)
+			if err != nil {
+				return err
+			}
+			if e.enc.current(key, sk, v) {
+				continue
+			}
+			value, err := e.enc.open(key, v)
+			if err != nil {
+				return err
+			}
+			sealed, err := e.enc.seal(key, value)
+			if err != nil {
+				return err
+			}
+			if err := set(e.enc.storedKey(key), sealed); err != nil {
+				return err
+			}
+			if sk != e.enc.storedKey(key) {
+				if err := del(sk); err != nil {
+					return err
+				}
+			}
+			n++
+		}
+		return nil
+	}
+	if tr, ok := e.db.(KVTransaction); ok {
+		err := tr.Update(func(tx KVTxn) error {
+			n = 0
+			return rewrite(tx.Get, tx.Set, tx.Delete)
+		})
+		return n, err
+	}
+	err := rewrite(func(k string) ([]byte, error) {
+		return e.store.GetContext(ctx, k)
+	}, func(k string, v []byte) error {
+		return e.store.SetContext(ctx, k, v)
+	}, func(k string) error {
+		return e.store.DeleteContext(ctx, k)
+	})
+	return n, err
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+var (
+	testKey1 = bytes.Repeat([]byte{1}, 32)
+	testKey2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func testEncryptedDB(t *testing.T, inner KVMethods, opts EncryptOptions) *EncryptedDB {
+	t.Helper()
+	db, err := NewEncryptedDB(inner, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func testEncryption(t *testing.T, inner KVMethods) {
+	db := testEncryptedDB(t, inner, EncryptOptions{Keys: map[uint32][]byte{1: testKey1}, KeyID: 1})
+	ctx := context.Background()
+	db.Set(&KVData{"user:1", []byte("alice")})
+	db.Set(&KVData{"user:2", []byte("bob")})
+	checkValue(t, db, "user:1", "alice")
+
+	raw, err := KVStoreOf(inner).GetContext(ctx, "user:1")
+	if err != nil || bytes.Contains(raw, []byte("alice")) {
+		t.Fatalf("stored value: %q %v", raw, err)
+	}
+	kvs, err := db.ScanPrefix("user:")
+	if err != nil || len(kvs) != 2 || string(kvs[1].Value) != "bob" {
+		t.Fatalf("scan prefix: %v %v", kvs, err)
+	}
+
+	// a value moved to another key doesn't open
+	KVStoreOf(inner).SetContext(ctx, "user:2", raw)
+	if _, err := db.GetContext(ctx, "user:2"); err != ErrDecrypt {
+		t.Fatalf("swapped value: %v", err)
+	}
+	if kvr := db.Get("user:2"); kvr.Result {
+		t.Fatal("swapped value read")
+	}
+}
+
+func TestEncryptedDB_Mem(t *testing.T) {
+	inner, err := NewKVDataBase("mem://encrypt/enctest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testEncryption(t, inner)
+}
+
+func TestEncryptedDB_Bolt(t *testing.T) {
+	inner, err := NewKVDataBase("bolt://encrypt.db/enctest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	testEncryption(t, inner)
+}
+
+func TestEncryptedDB_Rekey(t *testing.T) {
+	inner, err := NewKVDataBase("mem://encrypt/rekey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner.Set(&KVData{"plain", []byte("old")})
+	old := testEncryptedDB(t, inner, EncryptOptions{Keys: map[uint32][]byte{1: testKey1}, KeyID: 1})
+	for i := 0; i < 150; i++ {
+		old.Set(&KVData{fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))})
+	}
+
+	db := testEncryptedDB(t, inner, EncryptOptions{
+		Keys:          map[uint32][]byte{1: testKey1, 2: testKey2},
+		KeyID:         2,
+		ReadPlaintext: true,
+	})
+	checkValue(t, db, "key007", "7")
+	checkValue(t, db, "plain", "old")
+	db.Set(&KVData{"key000", []byte("new")})
+	n, err := db.Rekey(context.Background())
+	if err != nil || n != 150 {
+		t.Fatalf("rekeyed %d: %v", n, err)
+	}
+
+	// old key can be dropped now
+	db = testEncryptedDB(t, inner, EncryptOptions{Keys: map[uint32][]byte{2: testKey2}, KeyID: 2})
+	checkValue(t, db, "key000", "new")
+	checkValue(t, db, "key149", "149")
+	checkValue(t, db, "plain", "old")
+	if n, err := db.Rekey(context.Background()); err != nil || n != 0 {
+		t.Fatalf("second rekey %d: %v", n, err)
+	}
+	if _, err := old.GetContext(context.Background(), "key001"); err != ErrDecrypt {
+		t.Fatalf("read by dropped key: %v", err)
+	}
+}
+
+func TestEncryptedDB_Tampered(t *testing.T) {
+	inner, err := NewKVDataBase("mem://encrypt/tampered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	old := testEncryptedDB(t, inner, EncryptOptions{Keys: map[uint32][]byte{1: testKey1}, KeyID: 1})
+	old.Set(&KVData{"tampered", []byte("value")})
+	old.Set(&KVData{"dropped", []byte("value")})
+	raw, _ := KVStoreOf(inner).GetContext(ctx, "tampered")
+	raw[len(raw)-1] ^= 1
+	KVStoreOf(inner).SetContext(ctx, "tampered", raw)
+
+	// values with the magic byte are never read as plaintext
+	db := testEncryptedDB(t, inner, EncryptOptions{
+		Keys:          map[uint32][]byte{1: testKey1},
+		KeyID:         1,
+		ReadPlaintext: true,
+	})
+	if _, err := db.GetContext(ctx, "tampered"); err != ErrDecrypt {
+		t.Fatalf("tampered value: %v", err)
+	}
+	db = testEncryptedDB(t, inner, EncryptOptions{
+		Keys:          map[uint32][]byte{2: testKey2},
+		KeyID:         2,
+		ReadPlaintext: true,
+	})
+	if _, err := db.GetContext(ctx, "dropped"); err != ErrDecrypt {
+		t.Fatalf("value of dropped key: %v", err)
+	}
+	if _, err := db.Rekey(ctx); err != ErrDecrypt {
+		t.Fatalf("rekey: %v", err)
+	}
+	if v, _ := KVStoreOf(inner).GetContext(ctx, "tampered"); !bytes.Equal(v, raw) {
+		t.Fatal("tampered value rewritten")
+	}
+}
+
+func TestEncryptedDB_EncryptKeys(t *testing.T) {
+	inner, err := NewKVDataBase("bolt://encrypt.db/keys?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	opts := EncryptOptions{Keys: map[uint32][]byte{1: testKey1}, KeyID: 1, EncryptKeys: true}
+	db := testEncryptedDB(t, inner, opts)
+	db.Set(&KVData{"user:1", []byte("alice")})
+	db.Set(&KVData{"user:2", []byte("bob")})
+	db.Set(&KVData{"other", []byte("x")})
+	if inner.Exists("user:1") || inner.KeyCount() != 3 {
+		t.Fatal("key stored in plaintext")
+	}
+	checkValue(t, db, "user:1", "alice")
+	kvs, err := db.ScanPrefix("user:")
+	if err != nil || len(kvs) != 2 || kvs[0].Key != "user:1" || kvs[1].Key != "user:2" {
+		t.Fatalf("scan prefix: %v %v", kvs, err)
+	}
+
+	// keys sealed by the old key are still found, and moved by Set
+	opts.Keys = map[uint32][]byte{1: testKey1, 2: testKey2}
+	opts.KeyID = 2
+	db = testEncryptedDB(t, inner, opts)
+	checkValue(t, db, "user:2", "bob")
+	db.Set(&KVData{"user:2", []byte("carol")})
+	if inner.KeyCount() != 3 {
+		t.Fatalf("%d stored keys", inner.KeyCount())
+	}
+	if n, err := db.Rekey(context.Background()); err != nil || n != 2 {
+		t.Fatalf("rekeyed %d: %v", n, err)
+	}
+	opts.Keys = map[uint32][]byte{2: testKey2}
+	db = testEncryptedDB(t, inner, opts)
+	checkValue(t, db, "user:1", "alice")
+	checkValue(t, db, "user:2", "carol")
+	if kvr := db.Delete("other"); !kvr.Result || inner.KeyCount() != 2 {
+		t.Fatalf("delete: %+v", kvr)
+	}
+}
+
+func TestNewEncryptedDB_Keys(t *testing.T) {
+	inner, _ := NewKVDataBase("mem://encrypt/opts")
+	if _, err := NewEncryptedDB(inner, EncryptOptions{Keys: map[uint32][]byte{1: testKey1}, KeyID: 2}); err == nil {
+		t.Fatal("missing current key accepted")
+	}
+	if _, err := NewEncryptedDB(inner, EncryptOptions{Keys: map[uint32][]byte{1: []byte("short")}, KeyID: 1}); err == nil {
+		t.Fatal("short key accepted")
+	}
+}
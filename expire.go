@@ -0,0 +1,36 @@
+package db
+
+import "time"
+
+// NoExpiration - TTL of a key that never expires
+const NoExpiration time.Duration = -1
+
+var (
+	// ExpireSweepInterval - period of background sweepers removing
+	// expired keys from mem and bolt buckets
+	ExpireSweepInterval = time.Second
+)
+
+// KVExpire - interface for keys with time to live
+// expired keys are invisible to Get, Exists, ListKeys and KeyCount
+// even before they are removed, Set without ttl makes a key persistent
+type KVExpire interface {
+	// SetWithTTL - set key value that expires after ttl
+	SetWithTTL(kv *KVData, ttl time.Duration) *KVResult
+	// TTL - remaining time to live of key
+	// NoExpiration for persistent keys, ErrNotFound for missing ones
+	TTL(key string) (time.Duration, error)
+}
+
+// expired - if deadline has passed at now
+func expired(deadline, now time.Time) bool {
+	return !now.Before(deadline)
+}
+
+// wrongTTL - result of a ttl out of range
+func wrongTTL() *KVResult {
+	return &KVResult{
+		Result: false,
+		Info:   "wrong ttl parameter",
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKVExpire(t *testing.T, db KVMethods) {
+	e, ok := db.(KVExpire)
+	if !ok {
+		t.Fatalf("%s doesn't support ttl", db.Name())
+	}
+	db.Set(&KVData{"persist", []byte("value")})
+	if kvr := e.SetWithTTL(&KVData{"session", []byte("token")}, 50*time.Millisecond); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	if ttl, err := e.TTL("session"); err != nil || ttl <= 0 {
+		t.Fatalf("ttl of session: %v %v", ttl, err)
+	}
+	if ttl, err := e.TTL("persist"); err != nil || ttl != NoExpiration {
+		t.Fatalf("ttl of persist: %v %v", ttl, err)
+	}
+	if !db.Exists("session") || db.KeyCount() != 2 {
+		t.Fatalf("session missing before expiry, %d keys", db.KeyCount())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if db.Exists("session") || db.Get("session").Result {
+		t.Fatal("expired key visible")
+	}
+	if n := db.KeyCount(); n != 1 {
+		t.Fatalf("%d keys after expiry", n)
+	}
+	if keys := db.ListKeys(0); len(keys) != 1 || keys[0] != "persist" {
+		t.Fatalf("keys after expiry: %v", keys)
+	}
+	if _, err := e.TTL("session"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ttl of expired key: %v", err)
+	}
+	e.SetWithTTL(&KVData{"persist", []byte("value")}, time.Millisecond)
+	db.Set(&KVData{"persist", []byte("value")})
+	time.Sleep(10 * time.Millisecond)
+	if !db.Exists("persist") {
+		t.Fatal("set didn't clear ttl")
+	}
+}
+
+func TestKVExpire_Mem(t *testing.T) {
+	db, err := OpenOrGet("mem://expire/expiretest?count=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVExpire(t, db)
+}
+
+func TestKVExpire_Bolt(t *testing.T) {
+	db, err := OpenOrGet("bolt://expire.db/expiretest?count=10&path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVExpire(t, db)
+}
+
+func TestMemDB_Sweep(t *testing.T) {
+	db, err := OpenOrGet("mem://expire/sweeptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	bucket := db.(*MemBucket)
+	bucket.SetWithTTL(&KVData{"key", []byte("value")}, time.Millisecond)
+	bucket.SetWithTTL(&KVData{"other", []byte("value")}, time.Hour)
+	time.Sleep(10 * time.Millisecond)
+	bucket.sweep()
+	s := bucket.shard("key")
+	s.RLock()
+	_, ok := s.data["key"]
+	s.RUnlock()
+	if ok {
+		t.Fatal("expired key not swept")
+	}
+	bucket.Delete("other")
+	if n := bucket.expiring(); n != 0 {
+		t.Fatalf("%d keys not swept", n)
+	}
+}
+
+func TestBoltDB_Sweep(t *testing.T) {
+	db, err := OpenOrGet("bolt://expire.db/sweeptest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	b := db.(*BoltDB)
+	b.SetWithTTL(&KVData{"key", []byte("value")}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if err := b.sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if n := b.expiring(); n != 0 {
+		t.Fatalf("%d keys not swept", n)
+	}
+	// nothing written without expired keys
+	writes := b.DB.Stats().TxStats.Write
+	if err := b.sweep(); err != nil || b.DB.Stats().TxStats.Write != writes {
+		t.Fatalf("sweep of nothing: %v", err)
+	}
+	// the sweeper stops with no key with ttl left
+	b.SetWithTTL(&KVData{"key", []byte("value")}, time.Millisecond)
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		b.sweepMu.Lock()
+		stopped := b.done == nil
+		b.sweepMu.Unlock()
+		if stopped {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("sweeper kept running")
+		}
+	}
+	if db.Exists("key") {
+		t.Fatal("expired key kept")
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/vinely/kvdb
 
+go 1.18
+
 require (
 	github.com/Workiva/go-datastructures v1.0.50
 	github.com/boltdb/bolt v1.3.1
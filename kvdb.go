@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"net/url"
+	"sync"
 )
 
 // KVData - KV data for record
@@ -53,11 +54,17 @@ type KVBase interface {
 	Set(kv *KVData) *KVResult
 	Delete(key string) *KVResult
 	KeyCount() int
+	Close() error
 }
 
 // KVUtil - extended interface in use
 type KVUtil interface {
+	// SetData - set data encoded by the database codec
 	SetData(key string, data interface{}) *KVResult
+	// GetData - decode value of key into out by the codec it was set with
+	GetData(key string, out interface{}) *KVResult
+	// Codec - codec used by SetData
+	Codec() Codec
 }
 
 // KVList - interface of list operations
@@ -74,6 +81,10 @@ type KVDBType struct {
 	Scheme      string
 	DataBases   map[string]KVMethods
 	Constructor KVDBConstructor
+	// reference count of databases opened by OpenOrGet
+	refs map[string]int
+	// uri to database name opened by OpenOrGet
+	uris map[string]string
 }
 
 // KVDBConstructor - kv database constructor delegate function
@@ -81,9 +92,24 @@ type KVDBConstructor func(uri string) (KVMethods, error)
 
 var (
 	// KVDBs - constructor functions for databases
-	KVDBs = make(map[string]KVDBType)
+	// guarded by kvdbLock together with DataBases of every type,
+	// use the functions below instead of changing it
+	KVDBs    = make(map[string]KVDBType)
+	kvdbLock sync.RWMutex
+	// uris being constructed by OpenOrGet, so one uri is never
+	// constructed twice, guarded by kvdbLock
+	opening = make(map[string]chan struct{})
 )
 
+// kvdbType - get database type from scheme, caller holds kvdbLock
+func kvdbType(scheme string) (KVDBType, error) {
+	t, ok := KVDBs[scheme]
+	if !ok {
+		return t, errors.New("This type of database [" + scheme + "] didn't exist")
+	}
+	return t, nil
+}
+
 // NewKVDataBase - construct a new database
 // uri describe the database location
 //    "redis://localhost:6379/service?count=50&dbno=1"
@@ -93,21 +119,137 @@ func NewKVDataBase(uri string) (KVMethods, error) {
 	if err != nil {
 		return nil, err
 	}
-	db := KVDBs[res.Scheme]
+	kvdbLock.RLock()
+	db, err := kvdbType(res.Scheme)
+	kvdbLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 	kvdb, err := db.Constructor(uri)
 	if err != nil {
 		return nil, err
 	}
+	kvdbLock.Lock()
 	_, ok := db.DataBases[kvdb.Name()]
+	if !ok {
+		db.DataBases[kvdb.Name()] = kvdb
+	}
+	kvdbLock.Unlock()
 	if ok {
+		kvdb.Close()
 		return nil, errors.New(kvdb.Name() + " already existed")
 	}
-	db.DataBases[kvdb.Name()] = kvdb
 	return kvdb, nil
 }
 
+// OpenOrGet - get database opened by the same uri or construct a new one
+// every call should be paired with a CloseKVDataBase of the database name,
+// the database is closed when the last reference is released
+func OpenOrGet(uri string) (KVMethods, error) {
+	res, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	kvdbLock.Lock()
+	t, err := kvdbType(res.Scheme)
+	if err != nil {
+		kvdbLock.Unlock()
+		return nil, err
+	}
+	// wait for another OpenOrGet of uri, databases built of others
+	// like mirror and shard open them while uri is being constructed
+	for {
+		ch, ok := opening[uri]
+		if !ok {
+			break
+		}
+		kvdbLock.Unlock()
+		<-ch
+		kvdbLock.Lock()
+	}
+	if name, ok := t.uris[uri]; ok {
+		if kvdb, ok := t.DataBases[name]; ok {
+			t.refs[name]++
+			kvdbLock.Unlock()
+			return kvdb, nil
+		}
+		delete(t.uris, uri)
+	}
+	ch := make(chan struct{})
+	opening[uri] = ch
+	kvdbLock.Unlock()
+	kvdb, err := NewKVDataBase(uri)
+	kvdbLock.Lock()
+	delete(opening, uri)
+	close(ch)
+	if err == nil {
+		t.uris[uri] = kvdb.Name()
+		t.refs[kvdb.Name()] = 1
+	}
+	kvdbLock.Unlock()
+	return kvdb, err
+}
+
+// CloseKVDataBase - release database by name
+// databases opened by OpenOrGet are closed and unregistered when the
+// last reference is released, others are closed at once
+func CloseKVDataBase(name string) error {
+	kvdbLock.Lock()
+	for _, t := range KVDBs {
+		kvdb, ok := t.DataBases[name]
+		if !ok {
+			continue
+		}
+		if t.refs[name] > 1 {
+			t.refs[name]--
+			kvdbLock.Unlock()
+			return nil
+		}
+		t.unregister(name)
+		kvdbLock.Unlock()
+		return kvdb.Close()
+	}
+	kvdbLock.Unlock()
+	return errors.New(name + " didn't exist")
+}
+
+// CloseAll - close and unregister all databases
+// the first error is returned, but all databases are tried
+func CloseAll() error {
+	var dbs []KVMethods
+	kvdbLock.Lock()
+	for _, t := range KVDBs {
+		for name, kvdb := range t.DataBases {
+			t.unregister(name)
+			dbs = append(dbs, kvdb)
+		}
+	}
+	kvdbLock.Unlock()
+	var err error
+	for _, kvdb := range dbs {
+		if e := kvdb.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// unregister - remove database and its references from type
+// caller holds kvdbLock
+func (kvdt *KVDBType) unregister(name string) {
+	delete(kvdt.DataBases, name)
+	delete(kvdt.refs, name)
+	for uri, n := range kvdt.uris {
+		if n == name {
+			delete(kvdt.uris, uri)
+		}
+	}
+}
+
 // NewKVDatabaseType - database register to maps
 func NewKVDatabaseType(scheme string, con KVDBConstructor) error {
+	kvdbLock.Lock()
+	defer kvdbLock.Unlock()
 	_, ok := KVDBs[scheme]
 	if ok {
 		return errors.New("already existed")
@@ -116,6 +258,8 @@ func NewKVDatabaseType(scheme string, con KVDBConstructor) error {
 		Scheme:      scheme,
 		DataBases:   make(map[string]KVMethods),
 		Constructor: con,
+		refs:        make(map[string]int),
+		uris:        make(map[string]string),
 	}
 	KVDBs[k.Scheme] = *k
 	return nil
@@ -123,6 +267,8 @@ func NewKVDatabaseType(scheme string, con KVDBConstructor) error {
 
 // GetKVDatabaseType  - get database type from scheme
 func GetKVDatabaseType(scheme string) *KVDBType {
+	kvdbLock.RLock()
+	defer kvdbLock.RUnlock()
 	t, ok := KVDBs[scheme]
 	if ok {
 		return &t
@@ -130,7 +276,21 @@ func GetKVDatabaseType(scheme string) *KVDBType {
 	return nil
 }
 
+// GetKVDataBase - get registered database by name, nil when missing
+func GetKVDataBase(name string) KVMethods {
+	kvdbLock.RLock()
+	defer kvdbLock.RUnlock()
+	for _, t := range KVDBs {
+		if kvdb, ok := t.DataBases[name]; ok {
+			return kvdb
+		}
+	}
+	return nil
+}
+
 // Count - return count of databases in this type
 func (kvdt *KVDBType) Count() int {
+	kvdbLock.RLock()
+	defer kvdbLock.RUnlock()
 	return len(kvdt.DataBases)
 }
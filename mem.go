@@ -1,12 +1,18 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"hash/fnv"
 	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Workiva/go-datastructures/common"
+	"github.com/Workiva/go-datastructures/slice/skip"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -14,15 +20,89 @@ var (
 	// MemDBDefaultPassword - default no password
 	MemDBDefaultPassword = ""
 	// MemDBList - map of memdbs
+	// guarded by memDBLock, use NewMemDB instead of changing it
 	MemDBList = make(map[string]*MemDB)
+	memDBLock sync.Mutex
 )
 
+// memShardCount - number of locked shards in a bucket
+const memShardCount = 32
+
+// memShard - part of bucket data guarded by its own lock
+type memShard struct {
+	sync.RWMutex
+	data map[string]interface{}
+	// deadlines of keys set with ttl
+	expires map[string]time.Time
+}
+
+// get - data of key alive at now, caller holds lock
+func (s *memShard) get(key string, now time.Time) (interface{}, bool) {
+	data, ok := s.data[key]
+	if ok && s.expired(key, now) {
+		return nil, false
+	}
+	return data, ok
+}
+
+// expired - if key has expired at now, caller holds lock
+func (s *memShard) expired(key string, now time.Time) bool {
+	deadline, ok := s.expires[key]
+	return ok && expired(deadline, now)
+}
+
+// put - set data of key, deadline zero for persistent key
+// return true for a new key, caller holds write lock
+func (s *memShard) put(key string, data interface{}, deadline time.Time) bool {
+	_, ok := s.data[key]
+	s.data[key] = data
+	if deadline.IsZero() {
+		delete(s.expires, key)
+	} else {
+		s.expires[key] = deadline
+	}
+	return !ok
+}
+
+// del - delete key, return true if it was there
+// caller holds write lock
+func (s *memShard) del(key string) bool {
+	_, ok := s.data[key]
+	delete(s.data, key)
+	delete(s.expires, key)
+	return ok
+}
+
+// memKey - key in bucket index
+type memKey string
+
+// Compare - order of keys in index
+func (k memKey) Compare(c common.Comparator) int {
+	return strings.Compare(string(k), string(c.(memKey)))
+}
+
 // MemBucket - mem bucket
+// safe for concurrent use, keys are spread over shards so that
+// writers of different keys rarely wait for each other
 type MemBucket struct {
 	Label   string
 	Buckets map[string]*MemBucket
-	Data    map[string]interface{}
 	DB      *MemDB
+	shards  [memShardCount]*memShard
+	// guard sweeping, set while a sweeper goroutine runs
+	sweepMu  sync.Mutex
+	sweeping bool
+	// sorted keys of all shards, locked after shard locks
+	indexMu sync.RWMutex
+	index   *skip.SkipList
+	// watchers of changes
+	watchers watchHub
+	// codec of SetData
+	codec Codec
+	// ops of an open batch, logged in one frame
+	// guarded by holding all shard locks
+	batching bool
+	batch    []memOp
 }
 
 // MemDB - using Memory as a key-value database
@@ -33,6 +113,28 @@ type MemDB struct {
 	Password string
 	Buckets  map[string]*MemBucket
 	Count    uint
+	// guard Buckets
+	mu sync.Mutex
+	// snapshot and log, nil when db isn't persisted
+	persist *memPersist
+	// buckets opened and not closed yet, guarded by memDBLock
+	opened int
+}
+
+// newMemBucket - new empty bucket in db
+func newMemBucket(db *MemDB, label string) *MemBucket {
+	bucket := &MemBucket{
+		DB:    db,
+		Label: label,
+		index: skip.New(uint32(0)),
+	}
+	for i := range bucket.shards {
+		bucket.shards[i] = &memShard{
+			data:    make(map[string]interface{}),
+			expires: make(map[string]time.Time),
+		}
+	}
+	return bucket
 }
 
 func init() {
@@ -40,8 +142,12 @@ func init() {
 }
 
 // NewMemDB - new redis db using uri format description
-// format : mem://<name>/<hashkey>?[count=]&[password=]
-// example mem://temp/serv?count=20&password=123
+// format : mem://<name>/<hashkey>?[count=]&[password=]&[codec=]&[persist=]&[fsync=]
+// example mem://temp/serv?count=20&password=123&codec=json
+// codec is kept from the first open of a bucket
+// persist is a directory keeping data of db across restarts,
+// fsync is always, everysec (default) or no
+// example mem://app/serv?persist=/var/lib/app/mem&fsync=everysec
 func NewMemDB(uri string) (KVMethods, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -54,7 +160,13 @@ func NewMemDB(uri string) (KVMethods, error) {
 	}
 	para := u.Query()
 	password := para.Get("password")
+	codec, err := codecParam(para.Get("codec"))
+	if err != nil {
+		return nil, err
+	}
 
+	memDBLock.Lock()
+	defer memDBLock.Unlock()
 	db, ok := MemDBList[u.Host]
 	var bucket *MemBucket
 	if ok {
@@ -62,27 +174,16 @@ func NewMemDB(uri string) (KVMethods, error) {
 			return nil, errors.New("Password not match")
 		}
 		label := filepath.Base(u.Path)
-		bucket, ok = db.Buckets[label]
-		if !ok {
-			bucket = &MemBucket{
-				DB:    db,
-				Label: filepath.Base(u.Path),
-				Data:  make(map[string]interface{}),
-			}
-		}
-		db.Buckets[bucket.Label] = bucket
+		db.mu.Lock()
+		bucket = db.bucket(label, codec)
+		db.mu.Unlock()
+		db.opened++
 	} else {
-		db := &MemDB{
+		db = &MemDB{
 			Type:    t,
 			Label:   u.Host,
 			Buckets: make(map[string]*MemBucket),
 		}
-		bucket = &MemBucket{
-			DB:    db,
-			Label: filepath.Base(u.Path),
-			Data:  make(map[string]interface{}),
-		}
-		db.Buckets[bucket.Label] = bucket
 		if para.Get("count") != "" {
 			i, _ := strconv.Atoi(para.Get("count"))
 			if i <= 0 {
@@ -93,29 +194,324 @@ func NewMemDB(uri string) (KVMethods, error) {
 		if para.Get("password") != "" {
 			db.Password = para.Get("password")
 		}
+		if dir := para.Get("persist"); dir != "" {
+			fsync, err := memFsyncParam(para.Get("fsync"))
+			if err != nil {
+				return nil, err
+			}
+			db.persist, err = openMemPersist(db, dir, fsync)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bucket = db.bucket(filepath.Base(u.Path), codec)
+		db.opened = 1
+		MemDBList[db.Label] = db
 	}
 	return bucket, nil
 }
 
+// bucket - get or create bucket of label, caller holds mu
+// buckets loaded from persisted data get codec on first open
+func (db *MemDB) bucket(label string, codec Codec) *MemBucket {
+	bucket, ok := db.Buckets[label]
+	if !ok {
+		bucket = newMemBucket(db, label)
+		db.Buckets[label] = bucket
+	}
+	if bucket.codec == nil {
+		bucket.codec = codec
+	}
+	return bucket
+}
+
+// Close - release db and drop it from MemDBList
+// a persisted db is synced and loaded again by the next open of its
+// name, data of other dbs is gone
+func (db *MemDB) Close() error {
+	memDBLock.Lock()
+	defer memDBLock.Unlock()
+	return db.close()
+}
+
+// close - Close, caller holds memDBLock
+// the log is closed under the lock, so a reopen loads all its writes
+func (db *MemDB) close() error {
+	if MemDBList[db.Label] == db {
+		delete(MemDBList, db.Label)
+	}
+	if db.persist == nil {
+		return nil
+	}
+	return db.persist.close()
+}
+
+// Compact - write snapshot of a persisted db and drop its logs now,
+// instead of waiting for the log to grow
+func (db *MemDB) Compact() error {
+	if db.persist == nil {
+		return errors.New(db.Label + " isn't persisted")
+	}
+	return db.persist.compact(db)
+}
+
 // Name - tag  different databases
 func (db *MemBucket) Name() string {
 	return "Memdb_" + db.Label
 }
 
+// Close - release the bucket
+// data of a db that isn't persisted stays in MemDBList and is found
+// again when the bucket is reopened, a persisted db is closed with its
+// last open bucket and loaded again by the next open
+func (db *MemBucket) Close() error {
+	memDBLock.Lock()
+	defer memDBLock.Unlock()
+	if db.DB.opened > 0 {
+		db.DB.opened--
+	}
+	if db.DB.opened > 0 || db.DB.persist == nil {
+		return nil
+	}
+	return db.DB.close()
+}
+
 // DBType - DataBase Type
 func (db *MemBucket) DBType() *KVDBType {
 	return db.DB.Type
 }
 
+// shard - shard holding key
+func (db *MemBucket) shard(key string) *memShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return db.shards[h.Sum32()%memShardCount]
+}
+
+// load - get data of key
+func (db *MemBucket) load(key string) (interface{}, bool) {
+	s := db.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	return s.get(key, time.Now())
+}
+
+// store - set data of key, deadline zero for persistent key
+func (db *MemBucket) store(key string, data interface{}, deadline time.Time) {
+	s := db.shard(key)
+	s.Lock()
+	db.put(s, key, data, deadline)
+	s.Unlock()
+	if !deadline.IsZero() {
+		db.startSweeper()
+	}
+}
+
+// remove - delete key, return data deleted
+func (db *MemBucket) remove(key string) (interface{}, bool) {
+	s := db.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.get(key, time.Now())
+	db.del(s, key)
+	return data, ok
+}
+
+// put - set data of key in its locked shard s, add new key to index
+// and tell watchers
+func (db *MemBucket) put(s *memShard, key string, data interface{}, deadline time.Time) {
+	var old []byte
+	watched := db.watchers.active()
+	if watched {
+		if v, ok := s.get(key, time.Now()); ok {
+			old, _ = memBytes(v)
+		}
+	}
+	if s.put(key, data, deadline) {
+		db.indexMu.Lock()
+		db.index.Insert(memKey(key))
+		db.indexMu.Unlock()
+	}
+	if db.DB.persist != nil {
+		op := memOp{bucket: db.Label, key: key}
+		op.value, _ = memBytes(data)
+		if !deadline.IsZero() {
+			op.deadline = deadline.UnixNano()
+		}
+		db.logOp(op)
+	}
+	if watched {
+		v, _ := memBytes(data)
+		db.watchers.publish(putEvent(key, old, v))
+	}
+}
+
+// del - delete key in its locked shard s and from index
+// and tell watchers
+func (db *MemBucket) del(s *memShard, key string) {
+	v, ok := s.data[key]
+	if !s.del(key) {
+		return
+	}
+	db.indexMu.Lock()
+	db.index.Delete(memKey(key))
+	db.indexMu.Unlock()
+	if db.DB.persist != nil {
+		db.logOp(memOp{del: true, bucket: db.Label, key: key})
+	}
+	if ok && db.watchers.active() {
+		old, _ := memBytes(v)
+		db.watchers.publish(deleteEvent(key, old))
+	}
+}
+
+// logOp - log op of a persisted db, caller holds shard lock of key
+// failures are kept by the log and reported by persisted
+func (db *MemBucket) logOp(op memOp) {
+	if db.batching {
+		db.batch = append(db.batch, op)
+		return
+	}
+	db.DB.persist.write([]memOp{op})
+}
+
+// persisted - error of logging writes of a persisted db
+func (db *MemBucket) persisted() error {
+	if db.DB.persist == nil {
+		return nil
+	}
+	return db.DB.persist.failed()
+}
+
+// beginBatch - log following writes in one frame
+// caller holds all shard locks for writing
+func (db *MemBucket) beginBatch() {
+	if db.DB.persist != nil {
+		db.batching = true
+	}
+}
+
+// endBatch - log writes of batch, before all shards are unlocked
+func (db *MemBucket) endBatch() error {
+	if !db.batching {
+		return nil
+	}
+	db.batching = false
+	ops := db.batch
+	db.batch = nil
+	if len(ops) == 0 {
+		return nil
+	}
+	return db.DB.persist.write(ops)
+}
+
+// Watch - events of keys starting with prefix, until ctx is done
+func (db *MemBucket) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	return db.watchers.watch(ctx, prefix)
+}
+
+// indexAfter - at most n keys of index in order
+// keys after key if ok, or from the first key
+func (db *MemBucket) indexAfter(key string, ok bool, n int) []string {
+	db.indexMu.RLock()
+	defer db.indexMu.RUnlock()
+	var keys []string
+	iter := db.index.Iter(memKey(key))
+	for len(keys) < n && iter.Next() {
+		k := string(iter.Value().(memKey))
+		if ok && k == key {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startSweeper - run sweeper if it isn't running
+func (db *MemBucket) startSweeper() {
+	db.sweepMu.Lock()
+	defer db.sweepMu.Unlock()
+	if !db.sweeping {
+		db.sweeping = true
+		go db.sweeper()
+	}
+}
+
+// sweeper - remove expired keys periodically
+// it stops when no key with ttl is left
+func (db *MemBucket) sweeper() {
+	ticker := time.NewTicker(ExpireSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		db.sweep()
+		db.sweepMu.Lock()
+		if db.expiring() == 0 {
+			db.sweeping = false
+			db.sweepMu.Unlock()
+			return
+		}
+		db.sweepMu.Unlock()
+	}
+}
+
+// sweep - remove expired keys
+func (db *MemBucket) sweep() {
+	now := time.Now()
+	for _, s := range db.shards {
+		s.Lock()
+		for k, deadline := range s.expires {
+			if expired(deadline, now) {
+				db.del(s, k)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// expiring - number of keys with ttl
+func (db *MemBucket) expiring() int {
+	n := 0
+	for _, s := range db.shards {
+		s.RLock()
+		n += len(s.expires)
+		s.RUnlock()
+	}
+	return n
+}
+
+// each - call fn on every kv until it returned false
+// fn is called on a copy of each shard, so it may change the bucket
+func (db *MemBucket) each(fn func(k string, data interface{}) bool) {
+	now := time.Now()
+	for _, s := range db.shards {
+		s.RLock()
+		keys := make([]string, 0, len(s.data))
+		datas := make([]interface{}, 0, len(s.data))
+		for k, data := range s.data {
+			if s.expired(k, now) {
+				continue
+			}
+			keys = append(keys, k)
+			datas = append(datas, data)
+		}
+		s.RUnlock()
+		for i := range keys {
+			if !fn(keys[i], datas[i]) {
+				return
+			}
+		}
+	}
+}
+
 // Exists - if key existed
 func (db *MemBucket) Exists(key string) bool {
-	_, ok := db.Data[key]
+	_, ok := db.load(key)
 	return ok
 }
 
 // Get - get value from key
 func (db *MemBucket) Get(key string) *KVResult {
-	data, ok := db.Data[key]
+	data, ok := db.load(key)
 	if !ok {
 		return &KVResult{
 			Result: false,
@@ -131,7 +527,13 @@ func (db *MemBucket) Get(key string) *KVResult {
 
 // Set - set key value
 func (db *MemBucket) Set(kv *KVData) *KVResult {
-	db.Data[kv.Key] = kv.Value
+	db.store(kv.Key, kv.Value, time.Time{})
+	if err := db.persisted(); err != nil {
+		return &KVResult{
+			Result: false,
+			Info:   err.Error(),
+		}
+	}
 	return &KVResult{
 		Data:   kv,
 		Result: true,
@@ -141,27 +543,24 @@ func (db *MemBucket) Set(kv *KVData) *KVResult {
 
 // Del - del a key
 func (db *MemBucket) Del(key string) error {
-	delete(db.Data, key)
-	return nil
+	db.remove(key)
+	return db.persisted()
 }
 
 // Delete - delete key
 func (db *MemBucket) Delete(key string) *KVResult {
-	var (
-		err error
-		ok  bool
-	)
+	var ok bool
 	kvr := &KVResult{}
-	kvr.Data, ok = db.Data[key]
+	kvr.Data, ok = db.remove(key)
 	if !ok {
 		kvr.Info = "data didn't existed"
 		kvr.Result = false
 		return kvr
 	}
-	err = db.Del(key)
-	if err != nil {
+	if err := db.persisted(); err != nil {
 		kvr.Info = err.Error()
 		kvr.Result = false
+		return kvr
 	}
 	kvr.Result = true
 	return kvr
@@ -170,90 +569,409 @@ func (db *MemBucket) Delete(key string) *KVResult {
 // KeyCount - Key Number
 // count of keys
 func (db *MemBucket) KeyCount() int {
-	return len(db.Data)
+	n := 0
+	now := time.Now()
+	for _, s := range db.shards {
+		s.RLock()
+		n += len(s.data)
+		for _, deadline := range s.expires {
+			if expired(deadline, now) {
+				n--
+			}
+		}
+		s.RUnlock()
+	}
+	return n
+}
+
+// SetWithTTL - set key value that expires after ttl
+func (db *MemBucket) SetWithTTL(kv *KVData, ttl time.Duration) *KVResult {
+	if ttl <= 0 {
+		return wrongTTL()
+	}
+	db.store(kv.Key, kv.Value, time.Now().Add(ttl))
+	if err := db.persisted(); err != nil {
+		return &KVResult{
+			Result: false,
+			Info:   err.Error(),
+		}
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+		Info:   "",
+	}
+}
+
+// TTL - remaining time to live of key
+func (db *MemBucket) TTL(key string) (time.Duration, error) {
+	s := db.shard(key)
+	s.RLock()
+	defer s.RUnlock()
+	now := time.Now()
+	if _, ok := s.get(key, now); !ok {
+		return 0, ErrNotFound
+	}
+	deadline, ok := s.expires[key]
+	if !ok {
+		return NoExpiration, nil
+	}
+	return deadline.Sub(now), nil
 }
 
 // FindOne - find first matched content that hander returned
 func (db *MemBucket) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
-	for k, data := range db.Data {
-		var json = jsoniter.ConfigCompatibleWithStandardLibrary
-		v, err := json.Marshal(data)
+	kv := &KVResult{
+		Result: false,
+		Info:   "didn't found kvs",
+	}
+	db.each(func(k string, data interface{}) bool {
+		v, err := memBytes(data)
 		if err != nil {
-			return &KVResult{
+			kv = &KVResult{
 				Result: false,
 				Info:   err.Error(),
 			}
+			return false
 		}
 		if i := handler([]byte(k), v); i.Result {
-			return i
+			kv = i
+			return false
+		}
+		return true
+	})
+	return kv
+}
+
+// Scan - list at most limit kvs after cursor in key order
+func (db *MemBucket) Scan(cursor string, limit int) ([]KVData, string, error) {
+	if limit <= 0 {
+		return nil, "", wrongLimit()
+	}
+	key, ok, err := cursorKey(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]KVData, 0, limit)
+	for len(items) < limit {
+		keys := db.indexAfter(key, ok, limit-len(items))
+		if len(keys) == 0 {
+			return items, "", nil
+		}
+		for _, k := range keys {
+			key, ok = k, true
+			data, found := db.load(k)
+			if !found {
+				continue
+			}
+			v, err := memBytes(data)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, KVData{k, v})
 		}
 	}
-	return &KVResult{
-		Result: false,
-		Info:   "didn't found kvs",
+	if len(db.indexAfter(key, ok, 1)) == 0 {
+		return items, "", nil
 	}
+	return items, keyCursor(key), nil
 }
 
-// ListKeys - list keys
-// page - the number of page
-func (db *MemBucket) ListKeys(page uint) []string {
-	var list []string
-	index := uint(0)
-	for k := range db.Data {
-		if index >= page*db.DB.Count {
-			list = append(list, k)
+// ScanPrefix - all kvs whose key starts with prefix in key order
+func (db *MemBucket) ScanPrefix(prefix string) ([]KVData, error) {
+	return db.scanFrom(prefix, 0, func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// ScanRange - all kvs with start <= key < end in key order
+func (db *MemBucket) ScanRange(start, end string) ([]KVData, error) {
+	return db.scanFrom(start, 0, func(k string) bool {
+		return inRange(k, end)
+	})
+}
+
+// ScanRangeLimit - at most limit kvs with start <= key < end in key order
+func (db *MemBucket) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	return db.scanFrom(start, limit, func(k string) bool {
+		return inRange(k, end)
+	})
+}
+
+// scanFrom - kvs from key start in key order while in returned true,
+// at most limit of them, every one for limit 0
+func (db *MemBucket) scanFrom(start string, limit int, in func(k string) bool) ([]KVData, error) {
+	items := make([]KVData, 0)
+	key, ok := start, false
+	for {
+		keys := db.indexAfter(key, ok, scanBatch)
+		if len(keys) == 0 {
+			return items, nil
 		}
-		index++
-		if index >= (page+1)*db.DB.Count {
-			return list
+		for _, k := range keys {
+			if !in(k) || (limit > 0 && len(items) == limit) {
+				return items, nil
+			}
+			key, ok = k, true
+			data, found := db.load(k)
+			if !found {
+				continue
+			}
+			v, err := memBytes(data)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, KVData{k, v})
 		}
 	}
-	return list
+}
+
+// ListKeys - list keys
+// page - the number of page
+func (db *MemBucket) ListKeys(page uint) []string {
+	return scanListKeys(db, page, db.DB.Count)
 }
 
 // List - list content that hander returned
 // page - page number
 func (db *MemBucket) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
-	data := make([]interface{}, 0)
-	kv := &KVResult{
-		Info:   "",
-		Result: true,
+	return scanList(db, page, db.DB.Count, handler)
+}
+
+// SetData - set data encoded by codec of db
+func (db *MemBucket) SetData(key string, data interface{}) *KVResult {
+	return setData(db, db.codec, key, data)
+}
+
+// GetData - decode value of key into out
+func (db *MemBucket) GetData(key string, out interface{}) *KVResult {
+	return getData(db, key, out)
+}
+
+// Codec - codec used by SetData
+func (db *MemBucket) Codec() Codec {
+	return db.codec
+}
+
+// memBytes - value bytes of data stored in bucket
+func memBytes(data interface{}) ([]byte, error) {
+	if v, ok := data.([]byte); ok {
+		return v, nil
 	}
-	index := uint(0)
+	var json = jsoniter.ConfigCompatibleWithStandardLibrary
+	return json.Marshal(data)
+}
 
-	for k, vdata := range db.Data {
-		if index >= page*db.DB.Count {
-			var json = jsoniter.ConfigCompatibleWithStandardLibrary
-			v, err := json.Marshal(vdata)
-			if err != nil {
-				return &KVResult{
-					Result: false,
-					Info:   err.Error(),
-				}
-			}
-			if i := handler([]byte(k), v); i.Result {
-				data = append(data, i.Data)
-				index++
+// GetContext - get value from key
+func (db *MemBucket) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, ok := db.load(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return memBytes(data)
+}
+
+// SetContext - set key value
+func (db *MemBucket) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.store(key, value, time.Time{})
+	return db.persisted()
+}
+
+// DeleteContext - delete key
+func (db *MemBucket) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, ok := db.remove(key); !ok {
+		return ErrNotFound
+	}
+	return db.persisted()
+}
+
+// ExistsContext - if key existed
+func (db *MemBucket) ExistsContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	_, ok := db.load(key)
+	return ok, nil
+}
+
+// ScanContext - call handler on every kv
+func (db *MemBucket) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	var err error
+	db.each(func(k string, data interface{}) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var v []byte
+		if v, err = memBytes(data); err != nil {
+			return false
+		}
+		err = handler([]byte(k), v)
+		return err == nil
+	})
+	return err
+}
+
+// memTxn - KVTxn on a locked bucket, writes are buffered until commit
+type memTxn struct {
+	db       *MemBucket
+	readOnly bool
+	batch    txBatch
+}
+
+func (t *memTxn) Get(key string) ([]byte, error) {
+	if v, found, ok := t.batch.get(key); ok {
+		if !found {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+	data, ok := t.db.shard(key).get(key, time.Now())
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return memBytes(data)
+}
+
+func (t *memTxn) Exists(key string) (bool, error) {
+	if _, found, ok := t.batch.get(key); ok {
+		return found, nil
+	}
+	_, ok := t.db.shard(key).get(key, time.Now())
+	return ok, nil
+}
+
+func (t *memTxn) Set(key string, value []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.batch[key] = txWrite{value: value}
+	return nil
+}
+
+func (t *memTxn) Delete(key string) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.batch[key] = txWrite{deleted: true}
+	return nil
+}
+
+// Update - run fn with all shards locked, apply its writes on success
+// fn must not call other methods of the bucket
+func (db *MemBucket) Update(fn func(tx KVTxn) error) error {
+	defer db.lockAll(true)()
+	t := &memTxn{db: db, batch: make(txBatch)}
+	if err := fn(t); err != nil {
+		return err
+	}
+	db.beginBatch()
+	for k, w := range t.batch {
+		if w.deleted {
+			db.del(db.shard(k), k)
+		} else {
+			db.put(db.shard(k), k, w.value, time.Time{})
+		}
+	}
+	return db.endBatch()
+}
+
+// View - run fn with all shards read locked
+// fn must not call writing methods of the bucket
+func (db *MemBucket) View(fn func(tx KVTxn) error) error {
+	defer db.lockAll(false)()
+	return fn(&memTxn{db: db, readOnly: true, batch: make(txBatch)})
+}
+
+// lockAll - lock every shard for writing or reading
+func (db *MemBucket) lockAll(write bool) func() {
+	for _, s := range db.shards {
+		if write {
+			s.Lock()
+		} else {
+			s.RLock()
+		}
+	}
+	return func() {
+		for _, s := range db.shards {
+			if write {
+				s.Unlock()
+			} else {
+				s.RUnlock()
 			}
 		}
-		if index >= (page+1)*db.DB.Count {
-			kv.Data = data
-			return kv
+	}
+}
+
+// MGet - get values of keys under one lock
+func (db *MemBucket) MGet(keys []string) []*KVResult {
+	defer db.lockAll(false)()
+	now := time.Now()
+	res := make([]*KVResult, len(keys))
+	for i, key := range keys {
+		data, ok := db.shard(key).get(key, now)
+		if !ok {
+			res[i] = notExisted()
+			continue
+		}
+		res[i] = &KVResult{
+			Data:   data,
+			Result: true,
 		}
 	}
-	kv.Data = data
-	return kv
+	return res
 }
 
-// SetData - set data in object json format
-func (db *MemBucket) SetData(key string, data interface{}) *KVResult {
-	var json = jsoniter.ConfigCompatibleWithStandardLibrary
-	value, err := json.Marshal(data)
-	if err != nil {
-		return &KVResult{
-			Result: false,
-			Info:   err.Error(),
+// MSet - set kvs under one lock
+func (db *MemBucket) MSet(kvs []KVData) []*KVResult {
+	defer db.lockAll(true)()
+	db.beginBatch()
+	res := make([]*KVResult, len(kvs))
+	for i := range kvs {
+		db.put(db.shard(kvs[i].Key), kvs[i].Key, kvs[i].Value, time.Time{})
+		res[i] = &KVResult{
+			Data:   &kvs[i],
+			Result: true,
 		}
 	}
-	return db.Set(&KVData{key, value})
+	if err := db.endBatch(); err != nil {
+		return batchFailed(len(kvs), err)
+	}
+	return res
+}
+
+// MDelete - delete keys under one lock
+func (db *MemBucket) MDelete(keys []string) []*KVResult {
+	defer db.lockAll(true)()
+	db.beginBatch()
+	now := time.Now()
+	res := make([]*KVResult, len(keys))
+	for i, key := range keys {
+		s := db.shard(key)
+		data, ok := s.get(key, now)
+		db.del(s, key)
+		if !ok {
+			res[i] = notExisted()
+			continue
+		}
+		res[i] = &KVResult{
+			Data:   data,
+			Result: true,
+		}
+	}
+	if err := db.endBatch(); err != nil {
+		return batchFailed(len(keys), err)
+	}
+	return res
 }
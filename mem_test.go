@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -12,7 +13,7 @@ var (
 )
 
 func initMemDB() error {
-	db, err := NewKVDataBase("mem://abs/serv?count=20")
+	db, err := OpenOrGet("mem://abs/serv?count=20")
 	if err != nil {
 		return err
 	}
@@ -115,3 +116,57 @@ func TestMemDB_List(t *testing.T) {
 	}
 	fmt.Println(str)
 }
+
+func TestMemDB_Concurrent(t *testing.T) {
+	db, err := OpenOrGet("mem://race/race?count=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := db.(*MemBucket)
+	handler := func(k, v []byte) *KVResult {
+		return &KVResult{Data: v, Result: true}
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := "key" + strconv.Itoa((g*200+i)%100)
+				bucket.Set(&KVData{key, []byte(key)})
+				if kvr := bucket.Get(key); kvr.Result && string(kvr.Data.([]byte)) != key {
+					t.Errorf("get %s: %s", key, kvr.Data)
+				}
+				bucket.Exists(key)
+				bucket.List(uint(i%3), handler)
+				bucket.ListKeys(uint(i % 3))
+				bucket.KeyCount()
+				bucket.Delete(key)
+			}
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				uri := "mem://race" + strconv.Itoa(g) + "/bucket" + strconv.Itoa(i%5)
+				db, err := OpenOrGet(uri)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				db.Set(&KVData{"key", []byte("value")})
+				GetKVDatabaseType("mem").Count()
+				if err := CloseKVDataBase(db.Name()); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := bucket.KeyCount(); n != 0 {
+		t.Fatalf("%d keys left", n)
+	}
+}
@@ -0,0 +1,589 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mem persistence
+// a persisted MemDB keeps <dir>/<name>.snap, a snapshot of all buckets,
+// and <dir>/<name>.<gen>.log, append-only logs of writes after it
+// every write is logged in a frame, [length][crc32][ops], before the
+// write is acknowledged, a frame cut by a crash is dropped on replay
+// the snapshot records the first log generation not in it, so a crash
+// during compaction replays the logs of the older snapshot
+
+// MemLogCompactSize - log size starting a background compaction
+// the log is compacted only when it's larger than the snapshot as well
+var MemLogCompactSize int64 = 16 << 20
+
+// memFsyncInterval - interval of fsync=everysec and compaction checks
+const memFsyncInterval = time.Second
+
+// memSnapMagic - first bytes of a snapshot
+const memSnapMagic = "KVDBSNP1"
+
+// memSnapFrame - ops in one frame of a snapshot
+const memSnapFrame = 1000
+
+// memFsync - when logged writes are synced to disk
+type memFsync int
+
+const (
+	// fsync every write before it's acknowledged
+	memFsyncAlways memFsync = iota
+	// fsync every second, a crash of the machine loses at most one
+	// second, a crash of the process loses nothing
+	memFsyncEverySec
+	// leave syncing to the system
+	memFsyncNo
+)
+
+// memFsyncParam - fsync policy of uri parameter
+func memFsyncParam(s string) (memFsync, error) {
+	switch s {
+	case "always":
+		return memFsyncAlways, nil
+	case "", "everysec":
+		return memFsyncEverySec, nil
+	case "no":
+		return memFsyncNo, nil
+	}
+	return 0, errors.New("wrong fsync parameter")
+}
+
+// memOp - logged write
+type memOp struct {
+	del    bool
+	bucket string
+	key    string
+	value  []byte
+	// unix nano, 0 for persistent key
+	deadline int64
+}
+
+// memPersist - snapshot and log of a MemDB
+type memPersist struct {
+	dir   string
+	name  string
+	fsync memFsync
+	// guard the fields below
+	mu       sync.Mutex
+	file     *os.File
+	gen      uint64
+	size     int64
+	snapSize int64
+	dirty    bool
+	// sticky error, after a failed write or close the log can't be
+	// appended
+	err error
+	// serialize compactions
+	compactMu sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// appendOps - encode ops after b
+func appendOps(b []byte, ops []memOp) []byte {
+	b = appendUvarint(b, uint64(len(ops)))
+	for _, op := range ops {
+		if op.del {
+			b = append(b, 2)
+		} else {
+			b = append(b, 1)
+		}
+		b = appendUvarint(b, uint64(len(op.bucket)))
+		b = append(b, op.bucket...)
+		b = appendUvarint(b, uint64(len(op.key)))
+		b = append(b, op.key...)
+		if op.del {
+			continue
+		}
+		b = appendVarint(b, op.deadline)
+		b = appendUvarint(b, uint64(len(op.value)))
+		b = append(b, op.value...)
+	}
+	return b
+}
+
+// appendUvarint - b with uvarint of x appended
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// appendVarint - b with varint of x appended
+func appendVarint(b []byte, x int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], x)]...)
+}
+
+// frame - ops in a frame
+func frame(ops []memOp) []byte {
+	b := appendOps(make([]byte, 8), ops)
+	binary.BigEndian.PutUint32(b[0:], uint32(len(b)-8))
+	binary.BigEndian.PutUint32(b[4:], crc32.ChecksumIEEE(b[8:]))
+	return b
+}
+
+// errTornFrame - frame cut or damaged
+var errTornFrame = errors.New("torn frame")
+
+// readFrame - ops of next frame and its size, io.EOF at the end of r
+func readFrame(r io.Reader) ([]memOp, int64, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errTornFrame
+	}
+	b := make([]byte, binary.BigEndian.Uint32(h[0:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, 0, errTornFrame
+	}
+	if crc32.ChecksumIEEE(b) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, 0, errTornFrame
+	}
+	ops, err := decodeOps(b)
+	return ops, int64(len(h) + len(b)), err
+}
+
+// decodeOps - ops encoded by appendOps
+func decodeOps(b []byte) ([]memOp, error) {
+	n, l := binary.Uvarint(b)
+	if l <= 0 {
+		return nil, errTornFrame
+	}
+	b = b[l:]
+	str := func() (string, bool) {
+		n, l := binary.Uvarint(b)
+		if l <= 0 || uint64(len(b)-l) < n {
+			return "", false
+		}
+		s := string(b[l : l+int(n)])
+		b = b[l+int(n):]
+		return s, true
+	}
+	ops := make([]memOp, 0, n)
+	for i := uint64(0); i < n; i++ {
+		if len(b) == 0 {
+			return nil, errTornFrame
+		}
+		op := memOp{del: b[0] == 2}
+		b = b[1:]
+		var ok1, ok2 bool
+		op.bucket, ok1 = str()
+		op.key, ok2 = str()
+		if !ok1 || !ok2 {
+			return nil, errTornFrame
+		}
+		if !op.del {
+			d, l := binary.Varint(b)
+			if l <= 0 {
+				return nil, errTornFrame
+			}
+			op.deadline = d
+			b = b[l:]
+			v, ok := str()
+			if !ok {
+				return nil, errTornFrame
+			}
+			op.value = []byte(v)
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// snapPath - path of snapshot
+func (p *memPersist) snapPath() string {
+	return filepath.Join(p.dir, p.name+".snap")
+}
+
+// logPath - path of log of generation gen
+func (p *memPersist) logPath(gen uint64) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%s.%020d.log", p.name, gen))
+}
+
+// logGens - generations of log files in order
+func (p *memPersist) logGens() ([]uint64, error) {
+	paths, err := filepath.Glob(filepath.Join(p.dir, p.name+".*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var gens []uint64
+	for _, path := range paths {
+		s := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), p.name+"."), ".log")
+		gen, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			continue
+		}
+		gens = append(gens, gen)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	return gens, nil
+}
+
+// openMemPersist - load snapshot and logs of db from dir into db
+// and open log for writes
+func openMemPersist(db *MemDB, dir string, fsync memFsync) (*memPersist, error) {
+	p := &memPersist{
+		dir:   dir,
+		name:  db.Label,
+		fsync: fsync,
+		done:  make(chan struct{}),
+	}
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return nil, fmt.Errorf("could create dir, %v", err)
+	}
+	gen, err := p.loadSnapshot(db)
+	if err != nil {
+		return nil, err
+	}
+	gens, err := p.logGens()
+	if err != nil {
+		return nil, err
+	}
+	for i, g := range gens {
+		if g < gen {
+			// compacted into snapshot before a crash
+			os.Remove(p.logPath(g))
+			continue
+		}
+		if err := p.replay(db, g, i == len(gens)-1); err != nil {
+			return nil, err
+		}
+		gen = g
+	}
+	p.gen = gen
+	p.file, err = os.OpenFile(p.logPath(gen), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := p.file.Stat()
+	if err != nil {
+		p.file.Close()
+		return nil, err
+	}
+	p.size = fi.Size()
+	for _, bucket := range db.Buckets {
+		if bucket.expiring() > 0 {
+			bucket.startSweeper()
+		}
+	}
+	p.wg.Add(1)
+	go p.background(db)
+	return p, nil
+}
+
+// loadSnapshot - apply snapshot to db, return first log generation after it
+func (p *memPersist) loadSnapshot(db *MemDB) (uint64, error) {
+	f, err := os.Open(p.snapPath())
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	h := make([]byte, len(memSnapMagic)+8)
+	if _, err := io.ReadFull(r, h); err != nil || string(h[:len(memSnapMagic)]) != memSnapMagic {
+		return 0, errors.New("corrupted snapshot " + p.snapPath())
+	}
+	for {
+		ops, _, err := readFrame(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, errors.New("corrupted snapshot " + p.snapPath())
+		}
+		applyOps(db, ops)
+	}
+	if fi, err := f.Stat(); err == nil {
+		p.snapSize = fi.Size()
+	}
+	return binary.BigEndian.Uint64(h[len(memSnapMagic):]), nil
+}
+
+// replay - apply log of generation gen to db
+// a torn frame at the end of the last log is a write cut by a crash,
+// it was never acknowledged and is cut off
+func (p *memPersist) replay(db *MemDB, gen uint64, last bool) error {
+	f, err := os.OpenFile(p.logPath(gen), os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		ops, n, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !last {
+				return errors.New("corrupted log " + p.logPath(gen))
+			}
+			return f.Truncate(good)
+		}
+		applyOps(db, ops)
+		good += n
+	}
+}
+
+// applyOps - apply loaded ops to buckets of db, without logging them
+func applyOps(db *MemDB, ops []memOp) {
+	now := time.Now()
+	for _, op := range ops {
+		bucket, ok := db.Buckets[op.bucket]
+		if !ok {
+			// codec is set by the first open
+			bucket = newMemBucket(db, op.bucket)
+			db.Buckets[op.bucket] = bucket
+		}
+		s := bucket.shard(op.key)
+		s.Lock()
+		if op.del {
+			bucket.del(s, op.key)
+		} else {
+			var deadline time.Time
+			if op.deadline != 0 {
+				deadline = time.Unix(0, op.deadline)
+			}
+			if deadline.IsZero() || !expired(deadline, now) {
+				bucket.put(s, op.key, op.value, deadline)
+			} else {
+				bucket.del(s, op.key)
+			}
+		}
+		s.Unlock()
+	}
+}
+
+// write - append ops to log in one frame
+func (p *memPersist) write(ops []memOp) error {
+	b := frame(ops)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if _, err := p.file.Write(b); err != nil {
+		p.err = err
+		return err
+	}
+	p.size += int64(len(b))
+	if p.fsync == memFsyncAlways {
+		if err := p.file.Sync(); err != nil {
+			p.err = err
+			return err
+		}
+	} else {
+		p.dirty = true
+	}
+	return nil
+}
+
+// failed - sticky error of log
+func (p *memPersist) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// background - fsync every second and compact grown logs
+func (p *memPersist) background(db *MemDB) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(memFsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		if p.fsync == memFsyncEverySec && p.dirty && p.file != nil && p.err == nil {
+			if err := p.file.Sync(); err != nil {
+				p.err = err
+			}
+			p.dirty = false
+		}
+		grown := p.size > MemLogCompactSize && p.size > p.snapSize
+		p.mu.Unlock()
+		if grown {
+			p.compact(db)
+		}
+	}
+}
+
+// compact - write snapshot of db and drop logs in it
+// writers wait only while the buckets are copied and the log rotated
+func (p *memPersist) compact(db *MemDB) error {
+	p.compactMu.Lock()
+	defer p.compactMu.Unlock()
+
+	db.mu.Lock()
+	buckets := make([]*MemBucket, 0, len(db.Buckets))
+	for _, b := range db.Buckets {
+		buckets = append(buckets, b)
+	}
+	db.mu.Unlock()
+	var unlocks []func()
+	for _, b := range buckets {
+		unlocks = append(unlocks, b.lockAll(false))
+	}
+	var ops []memOp
+	for _, b := range buckets {
+		for _, s := range b.shards {
+			for k, data := range s.data {
+				v, err := memBytes(data)
+				if err != nil {
+					continue
+				}
+				op := memOp{bucket: b.Label, key: k, value: v}
+				if d, ok := s.expires[k]; ok {
+					op.deadline = d.UnixNano()
+				}
+				ops = append(ops, op)
+			}
+		}
+	}
+	gen, err := p.rotate()
+	for _, unlock := range unlocks {
+		unlock()
+	}
+	if err != nil {
+		return err
+	}
+
+	size, err := p.writeSnapshot(gen, ops)
+	if err != nil {
+		return err
+	}
+	gens, err := p.logGens()
+	if err != nil {
+		return err
+	}
+	for _, g := range gens {
+		if g < gen {
+			os.Remove(p.logPath(g))
+		}
+	}
+	p.mu.Lock()
+	p.snapSize = size
+	p.mu.Unlock()
+	return nil
+}
+
+// rotate - sync log and switch writes to log of next generation
+func (p *memPersist) rotate() (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return 0, p.err
+	}
+	if p.file == nil {
+		return 0, ErrClosed
+	}
+	f, err := os.OpenFile(p.logPath(p.gen+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	if err := p.file.Sync(); err != nil {
+		f.Close()
+		p.err = err
+		return 0, err
+	}
+	p.file.Close()
+	p.file = f
+	p.gen++
+	p.size = 0
+	p.dirty = false
+	return p.gen, nil
+}
+
+// writeSnapshot - replace snapshot by ops, logs from gen are after it
+func (p *memPersist) writeSnapshot(gen uint64, ops []memOp) (int64, error) {
+	tmp := p.snapPath() + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	w := bufio.NewWriter(f)
+	h := make([]byte, len(memSnapMagic)+8)
+	copy(h, memSnapMagic)
+	binary.BigEndian.PutUint64(h[len(memSnapMagic):], gen)
+	w.Write(h)
+	size := int64(len(h))
+	for i := 0; i < len(ops); i += memSnapFrame {
+		end := i + memSnapFrame
+		if end > len(ops) {
+			end = len(ops)
+		}
+		b := frame(ops[i:end])
+		w.Write(b)
+		size += int64(len(b))
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, p.snapPath()); err != nil {
+		return 0, err
+	}
+	// make the rename durable
+	if d, err := os.Open(p.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return size, nil
+}
+
+// close - stop background work, sync and close log
+// later writes fail with ErrClosed
+func (p *memPersist) close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	p.wg.Wait()
+	p.compactMu.Lock()
+	defer p.compactMu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Sync()
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	p.file = nil
+	if p.err == nil {
+		p.err = ErrClosed
+	}
+	return err
+}
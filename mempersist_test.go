@@ -0,0 +1,186 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openPersisted(t *testing.T, uri string) *MemBucket {
+	t.Helper()
+	db, err := NewMemDB(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.(*MemBucket)
+}
+
+func checkValue(t *testing.T, db KVMethods, key, value string) {
+	t.Helper()
+	v, err := KVStoreOf(db).GetContext(context.Background(), key)
+	if err != nil || string(v) != value {
+		t.Fatalf("%s: %q %v", key, v, err)
+	}
+}
+
+func TestMemDB_Persist(t *testing.T) {
+	dir := t.TempDir()
+	uri := "mem://persist/users?fsync=always&persist=" + dir
+	db := openPersisted(t, uri)
+	db.Set(&KVData{"key1", []byte("value1")})
+	db.Set(&KVData{"key2", []byte("value2")})
+	db.Delete("key2")
+	db.SetWithTTL(&KVData{"ttl", []byte("t")}, time.Hour)
+	db.SetWithTTL(&KVData{"gone", []byte("g")}, time.Millisecond)
+	db.MSet([]KVData{{"m1", []byte("1")}, {"m2", []byte("2")}})
+	db.Update(func(tx KVTxn) error {
+		tx.Set("tx", []byte("committed"))
+		return tx.Delete("m2")
+	})
+	db.Update(func(tx KVTxn) error {
+		tx.Set("rolledback", []byte("x"))
+		return errors.New("rollback")
+	})
+	other := openPersisted(t, "mem://persist/other")
+	other.Set(&KVData{"key1", []byte("other")})
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	db = openPersisted(t, uri)
+	defer db.DB.Close()
+	checkValue(t, db, "key1", "value1")
+	checkValue(t, db, "ttl", "t")
+	checkValue(t, db, "m1", "1")
+	checkValue(t, db, "tx", "committed")
+	for _, key := range []string{"key2", "gone", "m2", "rolledback"} {
+		if db.Exists(key) {
+			t.Fatalf("%s restored", key)
+		}
+	}
+	if ttl, err := db.TTL("ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("ttl: %v %v", ttl, err)
+	}
+	checkValue(t, openPersisted(t, "mem://persist/other"), "key1", "other")
+}
+
+func TestMemDB_PersistCompact(t *testing.T) {
+	dir := t.TempDir()
+	uri := "mem://compact/data?persist=" + dir
+	db := openPersisted(t, uri)
+	for i := 0; i < 100; i++ {
+		db.Set(&KVData{fmt.Sprint(i), []byte("old")})
+	}
+	if err := db.DB.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		db.Set(&KVData{fmt.Sprint(i), []byte("new")})
+	}
+	db.Delete("99")
+	if err := db.DB.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	db.Set(&KVData{"after", []byte("compact")})
+	logs, _ := filepath.Glob(filepath.Join(dir, "compact.*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("logs after compaction: %v", logs)
+	}
+	db.DB.Close()
+
+	db = openPersisted(t, uri)
+	defer db.DB.Close()
+	if db.KeyCount() != 100 {
+		t.Fatalf("%d keys", db.KeyCount())
+	}
+	checkValue(t, db, "5", "new")
+	checkValue(t, db, "50", "old")
+	checkValue(t, db, "after", "compact")
+}
+
+func TestMemDB_PersistTornLog(t *testing.T) {
+	dir := t.TempDir()
+	uri := "mem://torn/data?persist=" + dir
+	db := openPersisted(t, uri)
+	db.Set(&KVData{"key1", []byte("value1")})
+	db.DB.Close()
+
+	logs, _ := filepath.Glob(filepath.Join(dir, "torn.*.log"))
+	if len(logs) != 1 {
+		t.Fatalf("logs: %v", logs)
+	}
+	// write cut by a crash
+	f, err := os.OpenFile(logs[0], os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(frame([]memOp{{bucket: "data", key: "key2", value: []byte("cut")}})[:12])
+	f.Close()
+
+	db = openPersisted(t, uri)
+	checkValue(t, db, "key1", "value1")
+	if db.Exists("key2") {
+		t.Fatal("torn write restored")
+	}
+	db.Set(&KVData{"key3", []byte("value3")})
+	db.DB.Close()
+
+	db = openPersisted(t, uri)
+	defer db.DB.Close()
+	checkValue(t, db, "key3", "value3")
+}
+
+func TestMemDB_PersistParam(t *testing.T) {
+	if _, err := NewMemDB("mem://fsync/data?fsync=sometimes&persist=" + t.TempDir()); err == nil {
+		t.Fatal("wrong fsync accepted")
+	}
+}
+
+func TestMemDB_PersistClose(t *testing.T) {
+	dir := t.TempDir()
+	users, err := OpenOrGet("mem://persistclose/closeusers?persist=" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := OpenOrGet("mem://persistclose/closeother")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Set(&KVData{"key1", []byte("value1")})
+	if err := CloseKVDataBase(other.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if ret := users.Set(&KVData{"key2", []byte("value2")}); !ret.Result {
+		t.Fatal(ret.Info)
+	}
+	// the last bucket closes the db and its log
+	if err := CloseKVDataBase(users.Name()); err != nil {
+		t.Fatal(err)
+	}
+	memDBLock.Lock()
+	_, ok := MemDBList["persistclose"]
+	memDBLock.Unlock()
+	if ok {
+		t.Fatal("closed db still listed")
+	}
+	// writes after close aren't acknowledged
+	if ret := users.Set(&KVData{"late", []byte("x")}); ret.Result {
+		t.Fatal("write after close succeeded")
+	}
+	if ret := users.Delete("key1"); ret.Result {
+		t.Fatal("delete after close succeeded")
+	}
+
+	db := openPersisted(t, "mem://persistclose/closeusers?persist="+dir)
+	defer db.DB.Close()
+	checkValue(t, db, "key1", "value1")
+	checkValue(t, db, "key2", "value2")
+	if db.Exists("late") {
+		t.Fatal("unacknowledged write restored")
+	}
+}
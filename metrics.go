@@ -0,0 +1,612 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// operations recorded by Instrument
+var metricOps = []string{
+	"Exists", "Get", "FindOne", "Set", "Delete", "KeyCount", "Close",
+	"ListKeys", "List", "SetData", "GetData",
+}
+
+const (
+	opExists = iota
+	opGet
+	opFindOne
+	opSet
+	opDelete
+	opKeyCount
+	opClose
+	opListKeys
+	opList
+	opSetData
+	opGetData
+)
+
+var (
+	// LatencyBounds - upper bounds of latency buckets in seconds
+	LatencyBounds = []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+	// PageDepthBounds - upper bounds of page buckets of List and ListKeys
+	PageDepthBounds = []float64{0, 1, 2, 5, 10, 20, 50, 100}
+)
+
+// Histogram - counts of observations by bucket
+// Counts[i] is the number of observations up to Bounds[i] and above
+// the bound before, the last count is of those above every bound
+type Histogram struct {
+	Bounds []float64
+	Counts []uint64
+	Count  uint64
+	Sum    float64
+}
+
+func newHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: bounds,
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+func (h Histogram) clone() Histogram {
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// OpStats - metrics of one operation
+type OpStats struct {
+	Calls uint64
+	// Errors - failed calls, missing keys are counted by Misses
+	Errors uint64
+	Misses uint64
+	// Latency - in seconds
+	Latency Histogram
+}
+
+// DBStats - metrics of one database
+type DBStats struct {
+	Scheme       string
+	Name         string
+	Ops          map[string]OpStats
+	BytesRead    uint64
+	BytesWritten uint64
+	// PageDepth - pages asked by List and ListKeys
+	PageDepth Histogram
+}
+
+// dbMetrics - metrics of a database being recorded
+type dbMetrics struct {
+	sync.Mutex
+	stats DBStats
+	ops   []OpStats
+}
+
+var (
+	metricsLock sync.Mutex
+	// metrics by scheme and name
+	metrics = make(map[[2]string]*dbMetrics)
+)
+
+func init() {
+	expvar.Publish("kvdb", expvar.Func(func() interface{} {
+		return Stats()
+	}))
+}
+
+// metricsOf - metrics of database, created on first use
+func metricsOf(scheme, name string) *dbMetrics {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	m, ok := metrics[[2]string{scheme, name}]
+	if !ok {
+		m = &dbMetrics{
+			stats: DBStats{
+				Scheme:    scheme,
+				Name:      name,
+				PageDepth: newHistogram(PageDepthBounds),
+			},
+			ops: make([]OpStats, len(metricOps)),
+		}
+		for i := range m.ops {
+			m.ops[i].Latency = newHistogram(LatencyBounds)
+		}
+		metrics[[2]string{scheme, name}] = m
+	}
+	return m
+}
+
+// record - record a call of op taking d
+func (m *dbMetrics) record(op int, d time.Duration, failed, missed bool, read, written int) {
+	m.Lock()
+	s := &m.ops[op]
+	s.Calls++
+	if failed {
+		s.Errors++
+	}
+	if missed {
+		s.Misses++
+	}
+	s.Latency.observe(d.Seconds())
+	m.stats.BytesRead += uint64(read)
+	m.stats.BytesWritten += uint64(written)
+	m.Unlock()
+}
+
+func (m *dbMetrics) page(page uint) {
+	m.Lock()
+	m.stats.PageDepth.observe(float64(page))
+	m.Unlock()
+}
+
+func (m *dbMetrics) snapshot() DBStats {
+	m.Lock()
+	defer m.Unlock()
+	s := m.stats
+	s.PageDepth = s.PageDepth.clone()
+	s.Ops = make(map[string]OpStats)
+	for i, op := range m.ops {
+		if op.Calls == 0 {
+			continue
+		}
+		op.Latency = op.Latency.clone()
+		s.Ops[metricOps[i]] = op
+	}
+	return s
+}
+
+// Stats - snapshot of metrics of instrumented databases, by scheme and
+// name
+func Stats() []DBStats {
+	metricsLock.Lock()
+	all := make([]*dbMetrics, 0, len(metrics))
+	for _, m := range metrics {
+		all = append(all, m)
+	}
+	metricsLock.Unlock()
+	stats := make([]DBStats, len(all))
+	for i, m := range all {
+		stats[i] = m.snapshot()
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Scheme != stats[j].Scheme {
+			return stats[i].Scheme < stats[j].Scheme
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// Instrument - middleware recording metrics of every call to db
+// databases of the same scheme and name share their metrics
+// the middleware is a KVStore, recorded like the KVMethods, and a
+// KVScanner, KVRangeScanner, KVBatch, KVExpire and KVWatcher whose
+// calls aren't recorded, failing as unsupported when db isn't one
+// it's a KVTransaction only when db is, as writers fall back to other
+// writes for databases without transactions
+func Instrument(db KVMethods) KVMethods {
+	scheme := ""
+	if t := db.DBType(); t != nil {
+		scheme = t.Scheme
+	}
+	w := &instrumented{
+		KVWrapper: KVWrapper{db},
+		m:         metricsOf(scheme, db.Name()),
+	}
+	if tr, ok := db.(KVTransaction); ok {
+		return &instrumentedTxn{w, tr}
+	}
+	return w
+}
+
+// instrumented - database recording metrics
+type instrumented struct {
+	KVWrapper
+	m *dbMetrics
+}
+
+// instrumentedTxn - instrumented database forwarding transactions
+type instrumentedTxn struct {
+	*instrumented
+	KVTransaction
+}
+
+// storeResult - result of a KVStore call
+func storeResult(data interface{}, err error) *KVResult {
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   data,
+		Result: true,
+	}
+}
+
+// missed - if err is a missing key, counted as a miss, not an error
+func missed(err error) (errored, miss bool) {
+	return err != nil && err != ErrNotFound, err == ErrNotFound
+}
+
+// reading - handler counting bytes of kvs read
+func reading(h func(k, v []byte) *KVResult, read *int) func(k, v []byte) *KVResult {
+	return func(k, v []byte) *KVResult {
+		*read += len(k) + len(v)
+		return h(k, v)
+	}
+}
+
+// Exists - if key existed
+func (w *instrumented) Exists(key string) bool {
+	start := time.Now()
+	ok := w.DB.Exists(key)
+	w.m.record(opExists, time.Since(start), false, false, 0, 0)
+	return ok
+}
+
+// Get - get value of key
+func (w *instrumented) Get(key string) *KVResult {
+	v, err := w.GetContext(context.Background(), key)
+	return storeResult(v, err)
+}
+
+// FindOne - find first kv matched by handler
+func (w *instrumented) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	start := time.Now()
+	read := 0
+	kvr := w.DB.FindOne(reading(handler, &read))
+	w.m.record(opFindOne, time.Since(start), false, !kvr.Result, read, 0)
+	return kvr
+}
+
+// Set - set key value
+func (w *instrumented) Set(kv *KVData) *KVResult {
+	start := time.Now()
+	kvr := w.DB.Set(kv)
+	written := 0
+	if kvr.Result {
+		written = len(kv.Value)
+	}
+	w.m.record(opSet, time.Since(start), !kvr.Result, false, 0, written)
+	return kvr
+}
+
+// Delete - delete key
+func (w *instrumented) Delete(key string) *KVResult {
+	err := w.DeleteContext(context.Background(), key)
+	return storeResult(&KVData{Key: key}, err)
+}
+
+// KeyCount - keys of db
+func (w *instrumented) KeyCount() int {
+	start := time.Now()
+	n := w.DB.KeyCount()
+	w.m.record(opKeyCount, time.Since(start), false, false, 0, 0)
+	return n
+}
+
+// Close - close db
+func (w *instrumented) Close() error {
+	start := time.Now()
+	err := w.DB.Close()
+	w.m.record(opClose, time.Since(start), err != nil, false, 0, 0)
+	return err
+}
+
+// ListKeys - keys on page
+func (w *instrumented) ListKeys(page uint) []string {
+	start := time.Now()
+	keys := w.DB.ListKeys(page)
+	read := 0
+	for _, k := range keys {
+		read += len(k)
+	}
+	w.m.record(opListKeys, time.Since(start), false, false, read, 0)
+	w.m.page(page)
+	return keys
+}
+
+// List - data handler returned on page
+func (w *instrumented) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	start := time.Now()
+	read := 0
+	kvr := w.DB.List(page, reading(handler, &read))
+	w.m.record(opList, time.Since(start), !kvr.Result, false, read, 0)
+	w.m.page(page)
+	return kvr
+}
+
+// SetData - set data encoded by codec of db
+func (w *instrumented) SetData(key string, data interface{}) *KVResult {
+	start := time.Now()
+	value, err := EncodeValue(w.Codec(), data)
+	if err != nil {
+		w.m.record(opSetData, time.Since(start), true, false, 0, 0)
+		return failed(err)
+	}
+	kvr := w.DB.Set(&KVData{key, value})
+	written := 0
+	if kvr.Result {
+		written = len(value)
+	}
+	w.m.record(opSetData, time.Since(start), !kvr.Result, false, 0, written)
+	return kvr
+}
+
+// GetData - decode value of key into out
+func (w *instrumented) GetData(key string, out interface{}) *KVResult {
+	start := time.Now()
+	value, err := KVStoreOf(w.DB).GetContext(context.Background(), key)
+	if err == nil {
+		err = DecodeValue(value, out)
+	}
+	errored, miss := missed(err)
+	w.m.record(opGetData, time.Since(start), errored, miss, len(value), 0)
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   out,
+		Result: true,
+	}
+}
+
+// GetContext - get value of key, recorded as Get
+func (w *instrumented) GetContext(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+	v, err := KVStoreOf(w.DB).GetContext(ctx, key)
+	errored, miss := missed(err)
+	w.m.record(opGet, time.Since(start), errored, miss, len(v), 0)
+	return v, err
+}
+
+// SetContext - set key value, recorded as Set
+func (w *instrumented) SetContext(ctx context.Context, key string, value []byte) error {
+	start := time.Now()
+	err := KVStoreOf(w.DB).SetContext(ctx, key, value)
+	written := 0
+	if err == nil {
+		written = len(value)
+	}
+	w.m.record(opSet, time.Since(start), err != nil, false, 0, written)
+	return err
+}
+
+// DeleteContext - delete key, recorded as Delete
+func (w *instrumented) DeleteContext(ctx context.Context, key string) error {
+	start := time.Now()
+	err := KVStoreOf(w.DB).DeleteContext(ctx, key)
+	errored, miss := missed(err)
+	w.m.record(opDelete, time.Since(start), errored, miss, 0, 0)
+	return err
+}
+
+// ExistsContext - if key existed, recorded as Exists
+func (w *instrumented) ExistsContext(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	ok, err := KVStoreOf(w.DB).ExistsContext(ctx, key)
+	w.m.record(opExists, time.Since(start), err != nil, false, 0, 0)
+	return ok, err
+}
+
+// ScanContext - call handler on every kv of db, not recorded
+func (w *instrumented) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	return KVStoreOf(w.DB).ScanContext(ctx, handler)
+}
+
+// scanner - KVScanner of db
+func (w *instrumented) scanner() (KVScanner, error) {
+	s, ok := w.DB.(KVScanner)
+	if !ok {
+		return nil, unsupported(w.DB, "scan")
+	}
+	return s, nil
+}
+
+// Scan - list at most limit kvs of db after cursor
+func (w *instrumented) Scan(cursor string, limit int) ([]KVData, string, error) {
+	s, err := w.scanner()
+	if err != nil {
+		return nil, "", err
+	}
+	return s.Scan(cursor, limit)
+}
+
+// ScanPrefix - all kvs of db whose key starts with prefix in key order
+func (w *instrumented) ScanPrefix(prefix string) ([]KVData, error) {
+	s, err := w.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScanPrefix(prefix)
+}
+
+// ScanRange - all kvs of db with start <= key < end in key order
+func (w *instrumented) ScanRange(start, end string) ([]KVData, error) {
+	s, err := w.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScanRange(start, end)
+}
+
+// ScanRangeLimit - at most limit kvs of db with start <= key < end in
+// key order
+func (w *instrumented) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	s, err := w.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return scanRangeLimit(s, start, end, limit)
+}
+
+// MGet - get values of keys, one by one when db isn't a KVBatch
+func (w *instrumented) MGet(keys []string) []*KVResult {
+	if b, ok := w.DB.(KVBatch); ok {
+		return b.MGet(keys)
+	}
+	res := make([]*KVResult, len(keys))
+	for i, k := range keys {
+		res[i] = w.DB.Get(k)
+	}
+	return res
+}
+
+// MSet - set kvs, one by one when db isn't a KVBatch
+func (w *instrumented) MSet(kvs []KVData) []*KVResult {
+	if b, ok := w.DB.(KVBatch); ok {
+		return b.MSet(kvs)
+	}
+	res := make([]*KVResult, len(kvs))
+	for i := range kvs {
+		res[i] = w.DB.Set(&kvs[i])
+	}
+	return res
+}
+
+// MDelete - delete keys, one by one when db isn't a KVBatch
+func (w *instrumented) MDelete(keys []string) []*KVResult {
+	if b, ok := w.DB.(KVBatch); ok {
+		return b.MDelete(keys)
+	}
+	res := make([]*KVResult, len(keys))
+	for i, k := range keys {
+		res[i] = w.DB.Delete(k)
+	}
+	return res
+}
+
+// SetWithTTL - set key value that expires after ttl
+func (w *instrumented) SetWithTTL(kv *KVData, ttl time.Duration) *KVResult {
+	e, ok := w.DB.(KVExpire)
+	if !ok {
+		return failed(unsupported(w.DB, "ttl"))
+	}
+	return e.SetWithTTL(kv, ttl)
+}
+
+// TTL - remaining time to live of key
+func (w *instrumented) TTL(key string) (time.Duration, error) {
+	e, ok := w.DB.(KVExpire)
+	if !ok {
+		return 0, unsupported(w.DB, "ttl")
+	}
+	return e.TTL(key)
+}
+
+// Watch - events of keys of db starting with prefix, the channel is
+// closed at once when db isn't a KVWatcher
+func (w *instrumented) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	wt, ok := w.DB.(KVWatcher)
+	if !ok {
+		ch := make(chan KVEvent)
+		close(ch)
+		return ch
+	}
+	return wt.Watch(ctx, prefix)
+}
+
+// MetricsContentType - content type of MetricsHandler
+const MetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler - http handler writing metrics of Stats in the
+// OpenMetrics text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", MetricsContentType)
+		w := bufio.NewWriter(rw)
+		writeMetrics(w, Stats())
+		w.Flush()
+	})
+}
+
+// writeMetrics - write stats in the OpenMetrics text format
+func writeMetrics(w io.Writer, stats []DBStats) {
+	float := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	labels := func(s *DBStats, more ...string) string {
+		l := `scheme="` + labelEscaper.Replace(s.Scheme) + `",db="` + labelEscaper.Replace(s.Name) + `"`
+		for i := 0; i+1 < len(more); i += 2 {
+			l += "," + more[i] + `="` + labelEscaper.Replace(more[i+1]) + `"`
+		}
+		return "{" + l + "}"
+	}
+	histogram := func(name string, h Histogram, s *DBStats, more ...string) {
+		n := uint64(0)
+		for i, c := range h.Counts {
+			n += c
+			le := "+Inf"
+			if i < len(h.Bounds) {
+				le = float(h.Bounds[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(s, append(append([]string(nil), more...), "le", le)...), n)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(s, more...), float(h.Sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels(s, more...), h.Count)
+	}
+	ops := func(s *DBStats) []string {
+		names := make([]string, 0, len(s.Ops))
+		for op := range s.Ops {
+			names = append(names, op)
+		}
+		sort.Strings(names)
+		return names
+	}
+	counter := func(name, help string, value func(s *DBStats, op string) uint64) {
+		fmt.Fprintf(w, "# TYPE %s counter\n# HELP %s %s\n", name, name, help)
+		for i := range stats {
+			for _, op := range ops(&stats[i]) {
+				fmt.Fprintf(w, "%s_total%s %d\n", name, labels(&stats[i], "op", op), value(&stats[i], op))
+			}
+		}
+	}
+	counter("kvdb_operations", "Calls of database methods.", func(s *DBStats, op string) uint64 {
+		return s.Ops[op].Calls
+	})
+	counter("kvdb_errors", "Failed calls of database methods.", func(s *DBStats, op string) uint64 {
+		return s.Ops[op].Errors
+	})
+	counter("kvdb_misses", "Calls of database methods on missing keys.", func(s *DBStats, op string) uint64 {
+		return s.Ops[op].Misses
+	})
+	fmt.Fprintf(w, "# TYPE kvdb_operation_duration_seconds histogram\n# HELP kvdb_operation_duration_seconds Latency of database methods.\n")
+	for i := range stats {
+		for _, op := range ops(&stats[i]) {
+			histogram("kvdb_operation_duration_seconds", stats[i].Ops[op].Latency, &stats[i], "op", op)
+		}
+	}
+	fmt.Fprintf(w, "# TYPE kvdb_read_bytes counter\n# HELP kvdb_read_bytes Bytes of keys and values read.\n")
+	for i := range stats {
+		fmt.Fprintf(w, "kvdb_read_bytes_total%s %d\n", labels(&stats[i]), stats[i].BytesRead)
+	}
+	fmt.Fprintf(w, "# TYPE kvdb_written_bytes counter\n# HELP kvdb_written_bytes Bytes of values written.\n")
+	for i := range stats {
+		fmt.Fprintf(w, "kvdb_written_bytes_total%s %d\n", labels(&stats[i]), stats[i].BytesWritten)
+	}
+	fmt.Fprintf(w, "# TYPE kvdb_page_depth histogram\n# HELP kvdb_page_depth Pages asked by List and ListKeys.\n")
+	for i := range stats {
+		histogram("kvdb_page_depth", stats[i].PageDepth, &stats[i])
+	}
+	fmt.Fprintf(w, "# EOF\n")
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func statsOf(t *testing.T, scheme, name string) DBStats {
+	t.Helper()
+	for _, s := range Stats() {
+		if s.Scheme == scheme && s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no stats of %s %s", scheme, name)
+	return DBStats{}
+}
+
+func TestInstrument(t *testing.T) {
+	inner, err := NewKVDataBase("mem://metrics/metricstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := Chain(inner, Instrument)
+	db.Set(&KVData{"key1", []byte("value1")})
+	db.Set(&KVData{"key2", []byte("value2")})
+	db.Get("key1")
+	db.Get("missing")
+	db.Delete("missing")
+	db.ListKeys(0)
+	db.ListKeys(3)
+	db.List(0, func(k, v []byte) *KVResult {
+		return &KVResult{Data: string(k), Result: true}
+	})
+	var out string
+	db.SetData("data", "document")
+	db.GetData("data", &out)
+
+	s := statsOf(t, "mem", inner.Name())
+	if set := s.Ops["Set"]; set.Calls != 2 || set.Errors != 0 || set.Latency.Count != 2 {
+		t.Fatalf("set: %+v", set)
+	}
+	if get := s.Ops["Get"]; get.Calls != 2 || get.Misses != 1 || get.Errors != 0 {
+		t.Fatalf("get: %+v", get)
+	}
+	if del := s.Ops["Delete"]; del.Misses != 1 {
+		t.Fatalf("delete: %+v", del)
+	}
+	if _, ok := s.Ops["KeyCount"]; ok {
+		t.Fatal("stats of a method not called")
+	}
+	// values, keys listed, values and keys handled by List, data
+	if s.BytesWritten != 12+10 || s.BytesRead != 6+8+20+10 {
+		t.Fatalf("bytes: read %d written %d", s.BytesRead, s.BytesWritten)
+	}
+	if s.PageDepth.Count != 3 || s.PageDepth.Counts[0] != 2 || s.PageDepth.Counts[3] != 1 {
+		t.Fatalf("page depth: %+v", s.PageDepth)
+	}
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Header().Get("Content-Type") != MetricsContentType {
+		t.Fatal(rec.Header())
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`kvdb_operations_total{scheme="mem",db="` + inner.Name() + `",op="Set"} 2`,
+		`kvdb_misses_total{scheme="mem",db="` + inner.Name() + `",op="Get"} 1`,
+		`kvdb_operation_duration_seconds_bucket{scheme="mem",db="` + inner.Name() + `",op="Get",le="+Inf"} 2`,
+		`kvdb_page_depth_count{scheme="mem",db="` + inner.Name() + `"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("%s missing in\n%s", line, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Fatal("no EOF")
+	}
+}
+
+func TestInstrument_Errors(t *testing.T) {
+	s, addr := respServer(t, "mem://metrics/metricsdown", "")
+	inner, err := NewKVDataBase("redis://" + addr + "/metricsdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	db := Instrument(inner)
+	db.Get("missing")
+	// a failing server is an error, not a miss
+	s.Close()
+	db.Get("key")
+	db.Delete("key")
+	st := statsOf(t, "redis", inner.Name())
+	if get := st.Ops["Get"]; get.Calls != 2 || get.Misses != 1 || get.Errors != 1 {
+		t.Fatalf("get: %+v", get)
+	}
+	if del := st.Ops["Delete"]; del.Misses != 0 || del.Errors != 1 {
+		t.Fatalf("delete: %+v", del)
+	}
+}
+
+func TestInstrument_Interfaces(t *testing.T) {
+	inner, err := NewKVDataBase("bolt://metrics.db/forwarded?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(inner.Name())
+	db := Instrument(inner)
+	if _, ok := db.(KVScanner); !ok {
+		t.Fatal("scans not forwarded")
+	}
+	tr, ok := db.(KVTransaction)
+	if !ok {
+		t.Fatal("transactions not forwarded")
+	}
+	if err := tr.Update(func(tx KVTxn) error { return tx.Set("key", []byte("value")) }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KVStoreOf(db).GetContext(context.Background(), "key"); err != nil {
+		t.Fatal(err)
+	}
+	if get := statsOf(t, "bolt", inner.Name()).Ops["Get"]; get.Calls != 1 {
+		t.Fatalf("get: %+v", get)
+	}
+
+	mem, err := NewMemDB("mem://metrics/forwarded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = Instrument(Mirror(mem))
+	if _, ok := db.(KVTransaction); ok {
+		t.Fatal("transactions of a db without them")
+	}
+	if _, ok := db.(KVScanner); !ok {
+		t.Fatal("scans not forwarded")
+	}
+}
+
+func TestInstrument_Optional(t *testing.T) {
+	mem, err := NewKVDataBase("mem://metrics/optional")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := Instrument(mem)
+	var ok [7]bool
+	_, ok[0] = db.(KVScanner)
+	_, ok[1] = db.(KVRangeScanner)
+	_, ok[2] = db.(KVBatch)
+	_, ok[3] = db.(KVExpire)
+	_, ok[4] = db.(KVWatcher)
+	_, ok[5] = db.(KVTransaction)
+	_, ok[6] = db.(KVStore)
+	for i, name := range []string{"scanner", "range scanner", "batch", "expire", "watcher", "transaction", "store"} {
+		if !ok[i] {
+			t.Fatalf("%s lost by Instrument", name)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := db.(KVWatcher).Watch(ctx, "k")
+	for _, kvr := range db.(KVBatch).MSet([]KVData{{"k1", []byte("1")}, {"k2", []byte("2")}}) {
+		if !kvr.Result {
+			t.Fatal(kvr.Info)
+		}
+	}
+	if ev := nextEvent(t, events); ev.Key != "k1" {
+		t.Fatalf("event %+v", ev)
+	}
+	if kvr := db.(KVExpire).SetWithTTL(&KVData{"t", []byte("v")}, time.Hour); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	if ttl, err := db.(KVExpire).TTL("t"); err != nil || ttl <= 0 {
+		t.Fatalf("ttl %v %v", ttl, err)
+	}
+	if items, err := db.(KVRangeScanner).ScanRangeLimit("k", "", 1); err != nil || len(items) != 1 || items[0].Key != "k1" {
+		t.Fatalf("range: %v %v", items, err)
+	}
+
+	// a db without them fails as unsupported, batches go key by key
+	plain := Instrument(&KVWrapper{mem})
+	if _, ok := plain.(KVTransaction); ok {
+		t.Fatal("transactions of a db without them")
+	}
+	if _, _, err := plain.(KVScanner).Scan("", 10); err == nil || !strings.Contains(err.Error(), "doesn't support scan") {
+		t.Fatalf("scan: %v", err)
+	}
+	if _, err := plain.(KVExpire).TTL("t"); err == nil {
+		t.Fatal("ttl of a db without it")
+	}
+	if _, ok := <-plain.(KVWatcher).Watch(ctx, ""); ok {
+		t.Fatal("event of a db without watch")
+	}
+	if res := plain.(KVBatch).MGet([]string{"k2", "missing"}); !res[0].Result || res[1].Result {
+		t.Fatalf("mget: %+v %+v", res[0], res[1])
+	}
+	served := Chain(mem, func(d KVMethods) KVMethods { return Instrument(&KVWrapper{d}) })
+	defer CloseKVDataBase(served.Name())
+	srv := httptest.NewServer(NewHandler())
+	defer srv.Close()
+	if res, _ := request(t, srv, "GET", "/db/"+served.Name()+"/keys", ""); res.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("listing a db without scan: %s", res.Status)
+	}
+}
@@ -0,0 +1,106 @@
+package db
+
+// Middleware - wrap a database to change some of its methods, for
+// logging, metrics, retries and alike
+type Middleware func(KVMethods) KVMethods
+
+// Chain - db wrapped by mws, the first middleware is the outermost
+// when db is registered in DataBases of its type, the chained database
+// takes its place, so it's returned by OpenOrGet and closed by name
+func Chain(db KVMethods, mws ...Middleware) KVMethods {
+	wrapped := db
+	for i := len(mws) - 1; i >= 0; i-- {
+		wrapped = mws[i](wrapped)
+	}
+	if t := db.DBType(); t != nil && wrapped != db {
+		kvdbLock.Lock()
+		if registered, ok := t.DataBases[db.Name()]; ok && registered == db {
+			t.DataBases[db.Name()] = wrapped
+		}
+		kvdbLock.Unlock()
+	}
+	return wrapped
+}
+
+// KVWrapper - base of middlewares forwarding every method to DB
+// embed it and override the methods of interest
+// optional interfaces like KVStore or KVScanner of DB are not forwarded,
+// callers then fall back to KVMethods, through the middleware
+type KVWrapper struct {
+	DB KVMethods
+}
+
+// Name - name of DB
+func (w *KVWrapper) Name() string {
+	return w.DB.Name()
+}
+
+// DBType - type of DB
+func (w *KVWrapper) DBType() *KVDBType {
+	return w.DB.DBType()
+}
+
+// Exists - if key existed
+func (w *KVWrapper) Exists(key string) bool {
+	return w.DB.Exists(key)
+}
+
+// Get - get value of key
+func (w *KVWrapper) Get(key string) *KVResult {
+	return w.DB.Get(key)
+}
+
+// FindOne - find first kv matched by handler
+func (w *KVWrapper) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	return w.DB.FindOne(handler)
+}
+
+// Set - set key value
+func (w *KVWrapper) Set(kv *KVData) *KVResult {
+	return w.DB.Set(kv)
+}
+
+// Delete - delete key
+func (w *KVWrapper) Delete(key string) *KVResult {
+	return w.DB.Delete(key)
+}
+
+// KeyCount - keys of DB
+func (w *KVWrapper) KeyCount() int {
+	return w.DB.KeyCount()
+}
+
+// Close - close DB
+func (w *KVWrapper) Close() error {
+	return w.DB.Close()
+}
+
+// ListKeys - keys on page
+func (w *KVWrapper) ListKeys(page uint) []string {
+	return w.DB.ListKeys(page)
+}
+
+// List - data handler returned on page
+func (w *KVWrapper) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	return w.DB.List(page, handler)
+}
+
+// SetData - set data encoded by codec of DB
+func (w *KVWrapper) SetData(key string, data interface{}) *KVResult {
+	return w.DB.SetData(key, data)
+}
+
+// GetData - decode value of key into out
+func (w *KVWrapper) GetData(key string, out interface{}) *KVResult {
+	return w.DB.GetData(key, out)
+}
+
+// Codec - codec of DB
+func (w *KVWrapper) Codec() Codec {
+	return w.DB.Codec()
+}
+
+// Unwrap - database under the middleware
+func (w *KVWrapper) Unwrap() KVMethods {
+	return w.DB
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+)
+
+// countGets - middleware counting Get calls
+type countGets struct {
+	KVWrapper
+	name  string
+	calls *[]string
+}
+
+func (c *countGets) Get(key string) *KVResult {
+	*c.calls = append(*c.calls, c.name)
+	return c.DB.Get(key)
+}
+
+func counting(name string, calls *[]string) Middleware {
+	return func(db KVMethods) KVMethods {
+		return &countGets{KVWrapper{db}, name, calls}
+	}
+}
+
+func TestChain(t *testing.T) {
+	db, err := OpenOrGet("mem://chain/chaintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	wrapped := Chain(db, counting("outer", &calls), counting("inner", &calls))
+	if wrapped.Set(&KVData{"key", []byte("value")}); !db.Exists("key") {
+		t.Fatal("set not forwarded")
+	}
+	if kvr := wrapped.Get("key"); !kvr.Result || len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+		t.Fatalf("get: %+v %v", kvr, calls)
+	}
+	if wrapped.Name() != db.Name() || wrapped.KeyCount() != 1 || len(wrapped.ListKeys(0)) != 1 {
+		t.Fatal("methods not forwarded")
+	}
+	if w := wrapped.(*countGets).Unwrap(); w.(*countGets).Unwrap() != db {
+		t.Fatal("unwrap")
+	}
+
+	if GetKVDatabaseType("mem").DataBases[db.Name()] != wrapped {
+		t.Fatal("chained database not registered")
+	}
+	again, err := OpenOrGet("mem://chain/chaintest")
+	if err != nil || again != wrapped {
+		t.Fatalf("open again: %v", err)
+	}
+	CloseKVDataBase(db.Name())
+	if err := CloseKVDataBase(db.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetKVDatabaseType("mem").DataBases[db.Name()]; ok {
+		t.Fatal("chained database not closed")
+	}
+}
@@ -0,0 +1,551 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// MirrorPolicy - what happens to a member a write failed on
+type MirrorPolicy int
+
+const (
+	// MirrorKeep - keep writing to the member, it's read only when the
+	// members no write failed on fail, Repair fixes what it missed
+	MirrorKeep MirrorPolicy = iota
+	// MirrorDetach - stop writing to and reading from the member until
+	// Repair brought it up to date
+	MirrorDetach
+)
+
+// MirrorRepair - report of a Repair
+type MirrorRepair struct {
+	// Checked - kvs of members compared with the primary
+	Checked int
+	// Written - kvs missing or different on members
+	Written int
+	// Deleted - kvs on members missing on the primary
+	Deleted int
+}
+
+// MirrorDB - database writing to every member, and reading from the
+// primary, the first member
+// members a write failed on are stale until repaired, reads and Repair
+// then use the first member that isn't
+// a write short of Quorum isn't rolled back, it fails with a
+// *MirrorQuorumError listing the members it was applied on
+// detached members aren't written, so with the default Quorum of every
+// member all writes fail while one is detached, until Repair
+type MirrorDB struct {
+	Type  *KVDBType
+	Label string
+	// Quorum - members a write must succeed on, 0 for all of them
+	Quorum int
+	Policy MirrorPolicy
+
+	members []KVMethods
+	// members opened by uri, released on Close
+	opened   bool
+	lock     sync.RWMutex
+	detached []bool
+	// writes failed on members since they were repaired
+	stale []int
+}
+
+func init() {
+	NewKVDatabaseType("mirror", NewMirrorDB)
+}
+
+// Mirror - database mirroring writes of primary to secondaries
+func Mirror(primary KVMethods, secondaries ...KVMethods) *MirrorDB {
+	return &MirrorDB{
+		Type:     GetKVDatabaseType("mirror"),
+		Label:    primary.Name(),
+		members:  append([]KVMethods{primary}, secondaries...),
+		detached: make([]bool, len(secondaries)+1),
+		stale:    make([]int, len(secondaries)+1),
+	}
+}
+
+// NewMirrorDB - new mirror of databases using uri format description
+// format : mirror://<name>?db=<uri>&db=<uri>[&quorum=]&[policy=keep|detach]
+// members are opened by OpenOrGet, the first one is the primary
+// example mirror://users?db=bolt%3A%2F%2Fusers.db%2Fusers&db=redis%3A%2F%2Flocalhost%3A6379%2Fusers
+func NewMirrorDB(uri string) (KVMethods, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	para := u.Query()
+	uris := para["db"]
+	if len(uris) == 0 {
+		return nil, errors.New("no db parameter")
+	}
+	quorum := 0
+	if para.Get("quorum") != "" {
+		quorum, err = strconv.Atoi(para.Get("quorum"))
+		if err != nil || quorum <= 0 || quorum > len(uris) {
+			return nil, errors.New("wrong quorum parameter")
+		}
+	}
+	policy := MirrorKeep
+	switch para.Get("policy") {
+	case "", "keep":
+	case "detach":
+		policy = MirrorDetach
+	default:
+		return nil, errors.New("wrong policy parameter")
+	}
+	members := make([]KVMethods, 0, len(uris))
+	for _, m := range uris {
+		db, err := OpenOrGet(m)
+		if err != nil {
+			for _, db := range members {
+				CloseKVDataBase(db.Name())
+			}
+			return nil, err
+		}
+		members = append(members, db)
+	}
+	m := Mirror(members[0], members[1:]...)
+	m.Label = u.Host
+	m.Quorum = quorum
+	m.Policy = policy
+	m.opened = true
+	return m, nil
+}
+
+// Name - tag different databases
+func (m *MirrorDB) Name() string {
+	return "Mirror_" + m.Label
+}
+
+// DBType - DataBase Type
+func (m *MirrorDB) DBType() *KVDBType {
+	return m.Type
+}
+
+// Members - databases of mirror, primary first
+func (m *MirrorDB) Members() []KVMethods {
+	return append([]KVMethods(nil), m.members...)
+}
+
+// Detached - if member i is detached
+func (m *MirrorDB) Detached(i int) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.detached[i]
+}
+
+// Stale - if a write failed on member i since it was repaired
+func (m *MirrorDB) Stale(i int) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.stale[i] > 0
+}
+
+// readers - indexes of attached members to read from, those that
+// aren't stale first, the primary when every member is detached
+func (m *MirrorDB) readers() []int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	idx := make([]int, 0, len(m.members))
+	for i := range m.members {
+		if !m.detached[i] && m.stale[i] == 0 {
+			idx = append(idx, i)
+		}
+	}
+	for i := range m.members {
+		if !m.detached[i] && m.stale[i] > 0 {
+			idx = append(idx, i)
+		}
+	}
+	if len(idx) == 0 {
+		idx = append(idx, 0)
+	}
+	return idx
+}
+
+// reader - first member to read from, the primary unless a write
+// failed on it or it's detached
+func (m *MirrorDB) reader() KVMethods {
+	return m.members[m.readers()[0]]
+}
+
+// read - run fn on members to read from in order until it didn't
+// fail, a missing key is not a failure
+func (m *MirrorDB) read(fn func(db KVStore) error) error {
+	var err error
+	for _, i := range m.readers() {
+		db := m.members[i]
+		if err = fn(KVStoreOf(db)); err == nil || err == ErrNotFound {
+			return err
+		}
+	}
+	return err
+}
+
+// write - run fn on every attached member at once
+// missing keys count as success, but ErrNotFound is returned when key
+// was missing on every member
+// MirrorQuorumError - error of a write short of quorum, it stays on
+// the members it was applied on
+type MirrorQuorumError struct {
+	// Quorum - members the write had to succeed on
+	Quorum int
+	// Applied - names of members the write was applied on, or a delete
+	// found the key missing on
+	Applied []string
+	// Err - first error of a member, or of detached members
+	Err error
+}
+
+func (e *MirrorQuorumError) Error() string {
+	return "mirror quorum not reached, " + strconv.Itoa(len(e.Applied)) + " of " + strconv.Itoa(e.Quorum) + ": " + e.Err.Error()
+}
+
+func (e *MirrorQuorumError) Unwrap() error {
+	return e.Err
+}
+
+func (m *MirrorDB) write(fn func(db KVStore) error) error {
+	m.lock.RLock()
+	var idx []int
+	for i := range m.members {
+		if !m.detached[i] {
+			idx = append(idx, i)
+		}
+	}
+	m.lock.RUnlock()
+	errs := make([]error, len(idx))
+	var wg sync.WaitGroup
+	for j, i := range idx {
+		wg.Add(1)
+		go func(j int, db KVMethods) {
+			defer wg.Done()
+			errs[j] = fn(KVStoreOf(db))
+		}(j, m.members[i])
+	}
+	wg.Wait()
+
+	ok, missing := 0, 0
+	var applied []string
+	var err error
+	for j, e := range errs {
+		switch {
+		case e == nil:
+			ok++
+			applied = append(applied, m.members[idx[j]].Name())
+		case e == ErrNotFound:
+			missing++
+			applied = append(applied, m.members[idx[j]].Name())
+		default:
+			if err == nil {
+				err = e
+			}
+			m.lock.Lock()
+			m.stale[idx[j]]++
+			if m.Policy == MirrorDetach {
+				m.detached[idx[j]] = true
+			}
+			m.lock.Unlock()
+		}
+	}
+	quorum := m.Quorum
+	if quorum <= 0 || quorum > len(m.members) {
+		quorum = len(m.members)
+	}
+	if ok+missing < quorum {
+		if err == nil {
+			err = errors.New("members detached")
+		}
+		return &MirrorQuorumError{Quorum: quorum, Applied: applied, Err: err}
+	}
+	if ok == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Exists - if key existed
+func (m *MirrorDB) Exists(key string) bool {
+	ok, _ := m.ExistsContext(context.Background(), key)
+	return ok
+}
+
+// Get - get value of key
+func (m *MirrorDB) Get(key string) *KVResult {
+	v, err := m.GetContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   v,
+		Result: true,
+	}
+}
+
+// Set - set key value on every member
+func (m *MirrorDB) Set(kv *KVData) *KVResult {
+	if err := m.SetContext(context.Background(), kv.Key, kv.Value); err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+	}
+}
+
+// Delete - delete key on every member
+func (m *MirrorDB) Delete(key string) *KVResult {
+	err := m.DeleteContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Result: true,
+	}
+}
+
+// FindOne - find first kv matched by handler on the primary
+func (m *MirrorDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	return m.reader().FindOne(handler)
+}
+
+// KeyCount - keys of the primary
+func (m *MirrorDB) KeyCount() int {
+	return m.reader().KeyCount()
+}
+
+// ListKeys - keys on page of the primary
+func (m *MirrorDB) ListKeys(page uint) []string {
+	return m.reader().ListKeys(page)
+}
+
+// List - data handler returned on page of the primary
+func (m *MirrorDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	return m.reader().List(page, handler)
+}
+
+// SetData - set data encoded by codec of the primary
+func (m *MirrorDB) SetData(key string, data interface{}) *KVResult {
+	return setData(m, m.Codec(), key, data)
+}
+
+// GetData - decode value of key into out
+func (m *MirrorDB) GetData(key string, out interface{}) *KVResult {
+	return getData(m, key, out)
+}
+
+// Codec - codec of the primary
+func (m *MirrorDB) Codec() Codec {
+	return m.members[0].Codec()
+}
+
+// Close - close members, those opened by uri are released
+func (m *MirrorDB) Close() error {
+	var err error
+	for _, db := range m.members {
+		var e error
+		if m.opened {
+			e = CloseKVDataBase(db.Name())
+		} else {
+			e = db.Close()
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// GetContext - get value of key, from the next member when it failed
+func (m *MirrorDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	var v []byte
+	err := m.read(func(db KVStore) error {
+		var err error
+		v, err = db.GetContext(ctx, key)
+		return err
+	})
+	return v, err
+}
+
+// SetContext - set key value on every member
+func (m *MirrorDB) SetContext(ctx context.Context, key string, value []byte) error {
+	return m.write(func(db KVStore) error {
+		return db.SetContext(ctx, key, value)
+	})
+}
+
+// DeleteContext - delete key on every member
+func (m *MirrorDB) DeleteContext(ctx context.Context, key string) error {
+	return m.write(func(db KVStore) error {
+		return db.DeleteContext(ctx, key)
+	})
+}
+
+// ExistsContext - if key existed, from the next member when it failed
+func (m *MirrorDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := m.read(func(db KVStore) error {
+		var err error
+		ok, err = db.ExistsContext(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+// ScanContext - call handler on every kv of the primary
+func (m *MirrorDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	return KVStoreOf(m.reader()).ScanContext(ctx, handler)
+}
+
+// Scan - list at most limit kvs of the primary after cursor
+func (m *MirrorDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	s, err := m.scanner()
+	if err != nil {
+		return nil, "", err
+	}
+	return s.Scan(cursor, limit)
+}
+
+// ScanPrefix - all kvs of the primary whose key starts with prefix
+func (m *MirrorDB) ScanPrefix(prefix string) ([]KVData, error) {
+	s, err := m.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScanPrefix(prefix)
+}
+
+// ScanRange - all kvs of the primary with start <= key < end
+func (m *MirrorDB) ScanRange(start, end string) ([]KVData, error) {
+	s, err := m.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return s.ScanRange(start, end)
+}
+
+// ScanRangeLimit - at most limit kvs of the primary with
+// start <= key < end
+func (m *MirrorDB) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	s, err := m.scanner()
+	if err != nil {
+		return nil, err
+	}
+	return scanRangeLimit(s, start, end, limit)
+}
+
+// scanner - KVScanner of the primary
+func (m *MirrorDB) scanner() (KVScanner, error) {
+	db := m.reader()
+	s, ok := db.(KVScanner)
+	if !ok {
+		return nil, errors.New(db.Name() + " doesn't support scan")
+	}
+	return s, nil
+}
+
+// Repair - make members the same as the primary, kvs missing or
+// different are written and kvs the primary hasn't are deleted,
+// stale and detached members are fresh again once repaired
+// when the primary is stale or detached the first member to read from
+// is used instead
+// a member a write failed on during Repair stays stale, other writes
+// during Repair may be missed by it, run it again when unsure
+func (m *MirrorDB) Repair(ctx context.Context) (MirrorRepair, error) {
+	var r MirrorRepair
+	from := m.readers()[0]
+	source := m.members[from]
+	src, ok := source.(KVScanner)
+	if !ok {
+		return r, errors.New(source.Name() + " doesn't support scan")
+	}
+	for i, db := range m.members {
+		if i == from {
+			continue
+		}
+		m.lock.RLock()
+		failures := m.stale[i]
+		m.lock.RUnlock()
+		if err := m.repair(ctx, src, KVStoreOf(source), db, &r); err != nil {
+			return r, err
+		}
+		m.lock.Lock()
+		if m.stale[i] == failures {
+			m.stale[i] = 0
+			m.detached[i] = false
+		}
+		m.lock.Unlock()
+	}
+	// every member was stale, the others are the same as source now
+	m.lock.Lock()
+	m.stale[from] = 0
+	m.detached[from] = false
+	m.lock.Unlock()
+	return r, nil
+}
+
+// repair - make db the same as source
+func (m *MirrorDB) repair(ctx context.Context, src KVScanner, source KVStore, db KVMethods, r *MirrorRepair) error {
+	s, ok := db.(KVScanner)
+	if !ok {
+		return errors.New(db.Name() + " doesn't support scan")
+	}
+	dst := KVStoreOf(db)
+	var err error
+	serr := scanPages(src, scanBatch, func(kv *KVData) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		r.Checked++
+		var v []byte
+		v, err = dst.GetContext(ctx, kv.Key)
+		if err == nil && bytes.Equal(v, kv.Value) {
+			return true
+		}
+		if err != nil && err != ErrNotFound {
+			return false
+		}
+		if err = dst.SetContext(ctx, kv.Key, kv.Value); err != nil {
+			return false
+		}
+		r.Written++
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return err
+	}
+	serr = scanPages(s, scanBatch, func(kv *KVData) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var found bool
+		if found, err = source.ExistsContext(ctx, kv.Key); err != nil || found {
+			return err == nil
+		}
+		if err = dst.DeleteContext(ctx, kv.Key); err != nil && err != ErrNotFound {
+			return false
+		}
+		err = nil
+		r.Deleted++
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	return err
+}
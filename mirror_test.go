@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// flakyBucket - mem bucket failing writes while fail is set
+type flakyBucket struct {
+	*MemBucket
+	fail bool
+}
+
+func (f *flakyBucket) SetContext(ctx context.Context, key string, value []byte) error {
+	if f.fail {
+		return errors.New("flaky")
+	}
+	return f.MemBucket.SetContext(ctx, key, value)
+}
+
+func (f *flakyBucket) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if f.fail {
+		return nil, errors.New("flaky")
+	}
+	return f.MemBucket.GetContext(ctx, key)
+}
+
+func TestMirrorDB(t *testing.T) {
+	primary, err := NewMemDB("mem://mirror/primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewMemDB("mem://mirror/second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyBucket{MemBucket: second.(*MemBucket)}
+	m := Mirror(primary, flaky)
+	m.Set(&KVData{"key1", []byte("value1")})
+	checkValue(t, second, "key1", "value1")
+
+	// every member must succeed by default
+	flaky.fail = true
+	if kvr := m.Set(&KVData{"key2", []byte("value2")}); kvr.Result {
+		t.Fatal("write without quorum succeeded")
+	}
+	// the members it was applied on are told
+	var qerr *MirrorQuorumError
+	if err := m.SetContext(context.Background(), "key2", []byte("value2")); !errors.As(err, &qerr) ||
+		qerr.Quorum != 2 || len(qerr.Applied) != 1 || qerr.Applied[0] != primary.Name() || qerr.Err.Error() != "flaky" {
+		t.Fatalf("write without quorum: %v", err)
+	}
+	m.Quorum = 1
+	if kvr := m.Set(&KVData{"key3", []byte("value3")}); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	if kvr := m.Delete("key1"); !kvr.Result || second.Exists("key1") {
+		t.Fatal("delete not mirrored")
+	}
+	if kvr := m.Delete("key1"); kvr.Result {
+		t.Fatal("missing key deleted")
+	}
+
+	// detached members are skipped until repaired
+	m.Policy = MirrorDetach
+	m.Set(&KVData{"key4", []byte("value4")})
+	if !m.Detached(1) {
+		t.Fatal("failed member not detached")
+	}
+	flaky.fail = false
+	m.Set(&KVData{"key5", []byte("value5")})
+	second.Set(&KVData{"stale", []byte("x")})
+	second.Set(&KVData{"key2", []byte("diverged")})
+	r, err := m.Repair(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// key2, key3, key4, key5 written, stale deleted
+	if r.Checked != 4 || r.Written != 4 || r.Deleted != 1 || m.Detached(1) {
+		t.Fatalf("repair: %+v", r)
+	}
+	for _, key := range []string{"key2", "key3", "key4", "key5"} {
+		v, _ := KVStoreOf(primary).GetContext(context.Background(), key)
+		checkValue(t, second, key, string(v))
+	}
+	if second.Exists("stale") {
+		t.Fatal("stale key kept")
+	}
+
+	// reads fall back to the next member when the primary fails
+	m = Mirror(&flakyBucket{primary.(*MemBucket), true}, second)
+	checkValue(t, m, "key5", "value5")
+}
+
+func TestMirrorDB_StalePrimary(t *testing.T) {
+	primary, err := NewMemDB("mem://mirror/staleprimary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewMemDB("mem://mirror/stalesecond")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyBucket{MemBucket: primary.(*MemBucket)}
+	m := Mirror(flaky, second)
+	m.Quorum = 1
+	m.Set(&KVData{"old", []byte("value")})
+
+	// a write acknowledged by quorum while the primary failed
+	flaky.fail = true
+	if kvr := m.Set(&KVData{"key", []byte("value")}); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	flaky.fail = false
+	if !m.Stale(0) || m.Stale(1) {
+		t.Fatal("failed member not stale")
+	}
+	checkValue(t, m, "key", "value")
+
+	r, err := m.Repair(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Written != 1 || r.Deleted != 0 || m.Stale(0) {
+		t.Fatalf("repair: %+v", r)
+	}
+	checkValue(t, primary, "key", "value")
+	checkValue(t, second, "key", "value")
+}
+
+func TestMirrorDB_URI(t *testing.T) {
+	a := "mem://mirroruri/a"
+	b := "bolt://mirror.db/b?path=" + t.TempDir()
+	db, err := NewKVDataBase("mirror://users?quorum=1&db=" + url.QueryEscape(a) + "&db=" + url.QueryEscape(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := db.(*MirrorDB)
+	if m.Name() != "Mirror_users" || m.Quorum != 1 || len(m.Members()) != 2 {
+		t.Fatalf("%s %d", m.Name(), m.Quorum)
+	}
+	db.Set(&KVData{"key", []byte("value")})
+	checkValue(t, m.Members()[1], "key", "value")
+	if err := CloseKVDataBase(db.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if GetKVDatabaseType("bolt").DataBases[m.Members()[1].Name()] != nil {
+		t.Fatal("member not released")
+	}
+	if _, err := NewKVDataBase("mirror://bad?policy=never&db=" + url.QueryEscape(a)); err == nil {
+		t.Fatal("wrong policy accepted")
+	}
+}
+
+func TestMirrorDB_OpenOrGet(t *testing.T) {
+	uri := "mirror://openorget?db=" + url.QueryEscape("mem://mirroropen/a") + "&db=" + url.QueryEscape("mem://mirroropen/b")
+	// members are opened by OpenOrGet while the mirror is being opened
+	done := make(chan error, 1)
+	go func() {
+		db, err := OpenOrGet(uri)
+		if err == nil {
+			db.Set(&KVData{"key", []byte("value")})
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OpenOrGet of mirror blocked")
+	}
+	db, err := OpenOrGet(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, db.(*MirrorDB).Members()[1], "key", "value")
+	CloseKVDataBase(db.Name())
+	if err := CloseKVDataBase(db.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if GetKVDataBase(db.Name()) != nil || GetKVDataBase("Memdb_b") != nil {
+		t.Fatal("mirror not released")
+	}
+}
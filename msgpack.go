@@ -0,0 +1,676 @@
+package db
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// msgpack - small reflection based MessagePack encoder and decoder
+// for the msgpack codec, structs are maps keyed by field name or
+// `msgpack:"name,omitempty"` tag, types implementing
+// encoding.BinaryMarshaler are stored as bin
+
+// msgpackMaxDepth - deepest nesting of arrays and maps decoded, so
+// hostile data can't overflow the stack
+const msgpackMaxDepth = 512
+
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+
+// msgpackMarshal - MessagePack encoding of v
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// msgpackUnmarshal - decode MessagePack data into the value pointed by v
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("msgpack: decode needs a non nil pointer")
+	}
+	d := &msgpackDecoder{data: data}
+	src, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(d.data) {
+		return errors.New("msgpack: trailing data")
+	}
+	return msgpackAssign(rv.Elem(), src)
+}
+
+// msgpackEncoder - encoder appending to buf
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) byte1(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *msgpackEncoder) uint16(b byte, n uint16) {
+	e.buf = append(e.buf, b, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], n)
+}
+
+func (e *msgpackEncoder) uint32(b byte, n uint32) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], n)
+}
+
+func (e *msgpackEncoder) uint64(b byte, n uint64) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], n)
+}
+
+// length - header of str, bin, array or map of n items
+// fix is the fix format byte, 0 when there is none
+// and fixMax the largest length fitting in it
+func (e *msgpackEncoder) length(n int, fix byte, fixMax int, b8, b16, b32 byte) {
+	switch {
+	case fix != 0 && n <= fixMax:
+		e.byte1(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(b16, uint16(n))
+	default:
+		e.uint32(b32, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.byte1(byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.uint16(0xd1, uint16(n))
+	case n >= math.MinInt32:
+		e.uint32(0xd2, uint32(n))
+	default:
+		e.uint64(0xd3, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) uint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.byte1(byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.uint16(0xcd, uint16(n))
+	case n <= math.MaxUint32:
+		e.uint32(0xce, uint32(n))
+	default:
+		e.uint64(0xcf, n)
+	}
+}
+
+func (e *msgpackEncoder) string(s string) {
+	e.length(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) bytes(b []byte) {
+	e.length(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.byte1(0xc0)
+		return nil
+	}
+	if v.Type().Implements(binaryMarshalerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.bytes(b)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.byte1(0xc3)
+		} else {
+			e.byte1(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.uint32(0xca, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.uint64(0xcb, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.string(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bytes(v.Bytes())
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		return e.array(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.byte1(0xc0)
+			return nil
+		}
+		e.length(v.Len(), 0x80, 15, 0, 0xde, 0xdf)
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := e.encode(iter.Key()); err != nil {
+				return err
+			}
+			if err := e.encode(iter.Value()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fields := msgpackFields(v.Type())
+		n := 0
+		for _, f := range fields {
+			if !f.omitEmpty || !v.Field(f.index).IsZero() {
+				n++
+			}
+		}
+		e.length(n, 0x80, 15, 0, 0xde, 0xdf)
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			e.string(f.name)
+			if err := e.encode(fv); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) array(v reflect.Value) error {
+	e.length(v.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackField - encoded field of a struct
+type msgpackField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// msgpackFields - exported fields of struct type t
+func msgpackFields(t reflect.Type) []msgpackField {
+	fields := make([]msgpackField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		f := msgpackField{name: sf.Name, index: i}
+		if tag, ok := sf.Tag.Lookup("msgpack"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				f.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					f.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// msgpackPair - decoded map entry, maps keep their order until assigned
+type msgpackPair struct {
+	key, value interface{}
+}
+
+// msgpackDecoder - decoder of data into generic values
+// nil, bool, int64, uint64, float64, string, []byte,
+// []interface{} and []msgpackPair
+type msgpackDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errors.New("msgpack: unexpected end of data")
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint - n bytes big endian unsigned integer
+func (d *msgpackDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+func (d *msgpackDecoder) decode() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return d.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.mapping(int(c & 0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign extend
+		shift := uint(64 - 8*size)
+		return int64(u<<shift) >> shift, nil
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+func (d *msgpackDecoder) str(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// nest - enter an array or map, call the returned func to leave it
+func (d *msgpackDecoder) nest() (func(), error) {
+	if d.depth >= msgpackMaxDepth {
+		return nil, errors.New("msgpack: data nested too deeply")
+	}
+	d.depth++
+	return func() { d.depth-- }, nil
+}
+
+func (d *msgpackDecoder) array(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errors.New("msgpack: unexpected end of data")
+	}
+	leave, err := d.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (d *msgpackDecoder) mapping(n int) (interface{}, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errors.New("msgpack: unexpected end of data")
+	}
+	leave, err := d.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	m := make([]msgpackPair, n)
+	for i := range m {
+		k, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.decode()
+		if err != nil {
+			return nil, err
+		}
+		m[i] = msgpackPair{k, v}
+	}
+	return m, nil
+}
+
+// msgpackKey - map key as it is decoded into interface{}, bin keys
+// become strings, arrays and maps can't be keys of a go map
+func msgpackKey(key interface{}) (interface{}, error) {
+	switch k := key.(type) {
+	case []byte:
+		return string(k), nil
+	case []interface{}, []msgpackPair:
+		return nil, errors.New("msgpack: array or map as map key")
+	}
+	return key, nil
+}
+
+// msgpackNatural - generic value as it is decoded into interface{}
+// maps with string keys become map[string]interface{}
+func msgpackNatural(src interface{}) (interface{}, error) {
+	switch s := src.(type) {
+	case []interface{}:
+		for i := range s {
+			v, err := msgpackNatural(s[i])
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+	case []msgpackPair:
+		keys := make([]interface{}, len(s))
+		strKeys := true
+		for i, p := range s {
+			k, err := msgpackKey(p.key)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.key.(string); !ok {
+				strKeys = false
+			}
+			keys[i] = k
+		}
+		if strKeys {
+			m := make(map[string]interface{}, len(s))
+			for _, p := range s {
+				v, err := msgpackNatural(p.value)
+				if err != nil {
+					return nil, err
+				}
+				m[p.key.(string)] = v
+			}
+			return m, nil
+		}
+		m := make(map[interface{}]interface{}, len(s))
+		for i, p := range s {
+			v, err := msgpackNatural(p.value)
+			if err != nil {
+				return nil, err
+			}
+			m[keys[i]] = v
+		}
+		return m, nil
+	}
+	return src, nil
+}
+
+// msgpackAssign - store generic value src in dst
+func msgpackAssign(dst reflect.Value, src interface{}) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	if b, ok := src.([]byte); ok && dst.CanAddr() && dst.Addr().Type().Implements(binaryUnmarshalerType) {
+		return dst.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+	mismatch := func() error {
+		return fmt.Errorf("msgpack: cannot decode %T into %s", src, dst.Type())
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return msgpackAssign(dst.Elem(), src)
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return mismatch()
+		}
+		v, err := msgpackNatural(src)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(v))
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		switch s := src.(type) {
+		case int64:
+			n = s
+		case uint64:
+			if s > math.MaxInt64 {
+				return mismatch()
+			}
+			n = int64(s)
+		default:
+			return mismatch()
+		}
+		if dst.OverflowInt(n) {
+			return mismatch()
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch s := src.(type) {
+		case int64:
+			if s < 0 {
+				return mismatch()
+			}
+			n = uint64(s)
+		case uint64:
+			n = s
+		default:
+			return mismatch()
+		}
+		if dst.OverflowUint(n) {
+			return mismatch()
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch s := src.(type) {
+		case float64:
+			dst.SetFloat(s)
+		case int64:
+			dst.SetFloat(float64(s))
+		case uint64:
+			dst.SetFloat(float64(s))
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		switch s := src.(type) {
+		case string:
+			dst.SetString(s)
+		case []byte:
+			dst.SetString(string(s))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch s := src.(type) {
+			case []byte:
+				dst.SetBytes(s)
+				return nil
+			case string:
+				dst.SetBytes([]byte(s))
+				return nil
+			}
+		}
+		a, ok := src.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(dst.Type(), len(a), len(a))
+		for i := range a {
+			if err := msgpackAssign(s.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		if b, ok := src.([]byte); ok && dst.Type().Elem().Kind() == reflect.Uint8 {
+			if len(b) != dst.Len() {
+				return mismatch()
+			}
+			reflect.Copy(dst, reflect.ValueOf(b))
+			return nil
+		}
+		a, ok := src.([]interface{})
+		if !ok || len(a) != dst.Len() {
+			return mismatch()
+		}
+		for i := range a {
+			if err := msgpackAssign(dst.Index(i), a[i]); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := src.([]msgpackPair)
+		if !ok {
+			return mismatch()
+		}
+		t := dst.Type()
+		dm := reflect.MakeMapWithSize(t, len(m))
+		for _, p := range m {
+			key, err := msgpackKey(p.key)
+			if err != nil {
+				return err
+			}
+			k := reflect.New(t.Key()).Elem()
+			if err := msgpackAssign(k, key); err != nil {
+				return err
+			}
+			v := reflect.New(t.Elem()).Elem()
+			if err := msgpackAssign(v, p.value); err != nil {
+				return err
+			}
+			dm.SetMapIndex(k, v)
+		}
+		dst.Set(dm)
+	case reflect.Struct:
+		m, ok := src.([]msgpackPair)
+		if !ok {
+			return mismatch()
+		}
+		fields := msgpackFields(dst.Type())
+		for _, p := range m {
+			name, ok := p.key.(string)
+			if !ok {
+				return mismatch()
+			}
+			for _, f := range fields {
+				if f.name == name {
+					if err := msgpackAssign(dst.Field(f.index), p.value); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
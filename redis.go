@@ -1,10 +1,15 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis"
 	jsoniter "github.com/json-iterator/go"
@@ -29,6 +34,16 @@ type RedisDB struct {
 	DB       int
 	Count    uint
 	Client   *redis.Client
+	// events waiting to be published, publisher stops when done closed
+	events    chan KVEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	// guard sweeping, set while a sweeper goroutine runs
+	sweepMu  sync.Mutex
+	sweeping bool
+	// codec of SetData
+	codec Codec
 }
 
 func init() {
@@ -36,8 +51,8 @@ func init() {
 }
 
 // NewRedisDB - new redis db using uri format description
-// format : redis://<redis host address>/<hashkey>?[count=]&[password=]&[dbno=]
-// example redis://localhost:6379/serv?count=20&password=123&dbno=1
+// format : redis://<redis host address>/<hashkey>?[count=]&[password=]&[dbno=]&[codec=]
+// example redis://localhost:6379/serv?count=20&password=123&dbno=1&codec=gob
 func NewRedisDB(uri string) (KVMethods, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
@@ -68,6 +83,10 @@ func NewRedisDB(uri string) (KVMethods, error) {
 	if para.Get("dbno") != "" {
 		redis.DB, _ = strconv.Atoi(para.Get("dbno"))
 	}
+	redis.codec, err = codecParam(para.Get("codec"))
+	if err != nil {
+		return nil, err
+	}
 
 	err = redis.setup()
 	if err != nil {
@@ -87,7 +106,18 @@ func (db *RedisDB) setup() error {
 	})
 
 	_, err := db.Client.Ping().Result()
-	return err
+	if err != nil {
+		db.Client.Close()
+		return err
+	}
+	db.events = make(chan KVEvent, WatchBuffer)
+	db.done = make(chan struct{})
+	db.wg.Add(1)
+	go db.publisher()
+	if n, err := db.Client.ZCard(db.deadlineKey()).Result(); err == nil && n > 0 {
+		db.startSweeper()
+	}
+	return nil
 }
 
 // Name - tag  different databases
@@ -95,6 +125,17 @@ func (db *RedisDB) Name() string {
 	return "Redis_" + db.HashKey
 }
 
+// Close - close redis client and its connection pool
+func (db *RedisDB) Close() error {
+	db.closeOnce.Do(func() {
+		db.sweepMu.Lock()
+		close(db.done)
+		db.sweepMu.Unlock()
+		db.wg.Wait()
+	})
+	return redisError(db.Client.Close())
+}
+
 // DBType - DataBase Type
 func (db *RedisDB) DBType() *KVDBType {
 	return db.Type
@@ -102,12 +143,13 @@ func (db *RedisDB) DBType() *KVDBType {
 
 // Exists - if key existed
 func (db *RedisDB) Exists(key string) bool {
-	return db.Client.HExists(db.HashKey, key).Val()
+	_, err := db.hget(db.Client, key)
+	return err == nil
 }
 
 // Get - get value from key
 func (db *RedisDB) Get(key string) *KVResult {
-	data, err := db.Client.HGet(db.HashKey, key).Bytes()
+	data, err := db.hget(db.Client, key)
 	if err != nil {
 		return &KVResult{
 			Result: false,
@@ -123,8 +165,7 @@ func (db *RedisDB) Get(key string) *KVResult {
 
 // Set - set key value
 func (db *RedisDB) Set(kv *KVData) *KVResult {
-	//	return db.Client.Set(key, data, time.Duration(t)).Err()
-	err := db.Client.HSet(db.HashKey, kv.Key, kv.Value).Err()
+	err := db.apply(db.Client, []redisWrite{{key: kv.Key, value: kv.Value}})
 	if err != nil {
 		return &KVResult{
 			Result: false,
@@ -140,14 +181,14 @@ func (db *RedisDB) Set(kv *KVData) *KVResult {
 
 // Del - del a key
 func (db *RedisDB) Del(key string) error {
-	return db.Client.HDel(db.HashKey, key).Err()
+	return db.apply(db.Client, []redisWrite{{key: key, deleted: true}})
 }
 
 // Delete - delete key
 func (db *RedisDB) Delete(key string) *KVResult {
 	var err error
 	kvr := &KVResult{}
-	kvr.Data, err = db.Client.HGet(db.HashKey, key).Bytes()
+	kvr.Data, err = db.hget(db.Client, key)
 	if err != nil {
 		kvr.Info = err.Error()
 		kvr.Result = false
@@ -157,6 +198,7 @@ func (db *RedisDB) Delete(key string) *KVResult {
 	if err != nil {
 		kvr.Info = err.Error()
 		kvr.Result = false
+		return kvr
 	}
 	kvr.Result = true
 	return kvr
@@ -165,7 +207,15 @@ func (db *RedisDB) Delete(key string) *KVResult {
 // KeyCount - Key Number
 // count of keys
 func (db *RedisDB) KeyCount() int {
-	return int(db.Client.HLen(db.HashKey).Val())
+	n, err := db.Client.HLen(db.HashKey).Result()
+	if err != nil {
+		return 0
+	}
+	expired, err := db.expiredFields()
+	if err != nil {
+		return 0
+	}
+	return int(n) - len(expired)
 }
 
 // FindOne - find first matched content that hander returned
@@ -173,7 +223,7 @@ func (db *RedisDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
 	iter := db.Client.HScan(db.HashKey, 0, "", 10).Iterator()
 	for iter.Next() {
 		k := iter.Val()
-		v, err := db.Client.HGet(db.HashKey, k).Bytes()
+		v, err := db.hget(db.Client, k)
 		if err != nil {
 			continue
 		}
@@ -187,64 +237,776 @@ func (db *RedisDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
 	}
 }
 
-// ListKeys - list keys
-// page - the number of page
-func (db *RedisDB) ListKeys(page uint) []string {
-	var list []string
-	index := uint(0)
-	iter := db.Client.HScan(db.HashKey, 0, "", 10).Iterator()
-	for iter.Next() {
-		if index >= page*db.Count {
-			list = append(list, iter.Val())
+// Scan - list at most limit kvs after cursor in HSCAN order
+// fields of one HSCAN batch are returned in key order and the cursor
+// holds the HSCAN cursor and the last key returned of its batch, so
+// kvs deleted or added while scanning don't shift the next page
+func (db *RedisDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	if limit <= 0 {
+		return nil, "", wrongLimit()
+	}
+	var hc uint64
+	last, resume := "", false
+	if cursor != "" {
+		i := strings.IndexByte(cursor, ':')
+		if i < 0 {
+			return nil, "", errors.New("wrong cursor parameter")
+		}
+		var err error
+		if hc, err = strconv.ParseUint(cursor[:i], 10, 64); err != nil {
+			return nil, "", errors.New("wrong cursor parameter")
 		}
-		index++
-		if index >= (page+1)*db.Count {
-			return list
+		if last, resume, err = cursorKey(cursor[i+1:]); err != nil {
+			return nil, "", err
 		}
 	}
-	return list
+	expired, err := db.expiredFields()
+	if err != nil {
+		return nil, "", err
+	}
+	items := make([]KVData, 0, limit)
+	for {
+		kvs, next, err := db.Client.HScan(db.HashKey, hc, "", int64(limit)).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		// HSCAN returns field and value in turn
+		batch := make([]KVData, 0, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if expired[kvs[i]] || (resume && kvs[i] <= last) {
+				continue
+			}
+			batch = append(batch, KVData{kvs[i], []byte(kvs[i+1])})
+		}
+		sort.Slice(batch, func(i, j int) bool {
+			return batch[i].Key < batch[j].Key
+		})
+		for i := range batch {
+			if len(items) == limit {
+				return items, strconv.FormatUint(hc, 10) + ":" + keyCursor(items[len(items)-1].Key), nil
+			}
+			items = append(items, batch[i])
+		}
+		if next == 0 {
+			return items, "", nil
+		}
+		hc, resume = next, false
+		if len(items) == limit {
+			return items, strconv.FormatUint(hc, 10) + ":", nil
+		}
+	}
+}
+
+// ScanPrefix - all kvs whose key starts with prefix in key order
+// fields are matched by HSCAN MATCH and sorted afterwards
+func (db *RedisDB) ScanPrefix(prefix string) ([]KVData, error) {
+	return db.scanMatch(redisGlobEscape(prefix)+"*", func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// ScanRange - all kvs with start <= key < end in key order
+// redis hashes are unordered, the whole hash is scanned and sorted
+func (db *RedisDB) ScanRange(start, end string) ([]KVData, error) {
+	return db.scanMatch("", func(k string) bool {
+		return k >= start && inRange(k, end)
+	})
+}
+
+// redisGlobEscape - escape glob characters of HSCAN MATCH pattern
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// scanMatch - kvs of fields matched by pattern and in, sorted by key
+func (db *RedisDB) scanMatch(match string, in func(k string) bool) ([]KVData, error) {
+	expired, err := db.expiredFields()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]KVData, 0)
+	var cursor uint64
+	for {
+		kvs, next, err := db.Client.HScan(db.HashKey, cursor, match, scanBatch).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if !in(kvs[i]) || expired[kvs[i]] {
+				continue
+			}
+			items = append(items, KVData{kvs[i], []byte(kvs[i+1])})
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	// HSCAN may return a field more than once
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	n := 0
+	for i := range items {
+		if i > 0 && items[i].Key == items[n-1].Key {
+			continue
+		}
+		items[n] = items[i]
+		n++
+	}
+	return items[:n], nil
+}
+
+// ListKeys - list keys
+// page - the number of page
+func (db *RedisDB) ListKeys(page uint) []string {
+	return scanListKeys(db, page, db.Count)
 }
 
 // List - list content that hander returned
 // page - page number
 func (db *RedisDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
-	data := make([]interface{}, 0)
-	kv := &KVResult{
-		Info:   "",
-		Result: true,
+	return scanList(db, page, db.Count, handler)
+}
+
+// SetData - set data encoded by codec of db
+func (db *RedisDB) SetData(key string, data interface{}) *KVResult {
+	return setData(db, db.codec, key, data)
+}
+
+// GetData - decode value of key into out
+func (db *RedisDB) GetData(key string, out interface{}) *KVResult {
+	return getData(db, key, out)
+}
+
+// Codec - codec used by SetData
+func (db *RedisDB) Codec() Codec {
+	return db.codec
+}
+
+// redisError - map redis errors to kvdb errors
+func redisError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == redis.Nil:
+		return ErrNotFound
+	case err.Error() == "redis: client is closed":
+		return ErrClosed
 	}
-	index := uint(0)
-	iter := db.Client.HScan(db.HashKey, 0, "", 10).Iterator()
-	for iter.Next() {
-		if index >= page*db.Count {
-			k := iter.Val()
-			v, err := db.Client.HGet(db.HashKey, k).Bytes()
-			if err != nil {
+	return err
+}
+
+// do - run redis commands, return early when ctx is done
+// go-redis doesn't cancel on context, so the call is left to finish
+// in background while the caller gets ctx.Err()
+func (db *RedisDB) do(ctx context.Context, fn func(c *redis.Client) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return redisError(fn(db.Client))
+	}
+	ch := make(chan error, 1)
+	go func() {
+		ch <- fn(db.Client.WithContext(ctx))
+	}()
+	select {
+	case err := <-ch:
+		return redisError(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetContext - get value from key
+func (db *RedisDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := db.do(ctx, func(c *redis.Client) error {
+		v, err := db.hget(c, key)
+		data = v
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// SetContext - set key value
+func (db *RedisDB) SetContext(ctx context.Context, key string, value []byte) error {
+	return db.do(ctx, func(c *redis.Client) error {
+		return db.apply(c, []redisWrite{{key: key, value: value}})
+	})
+}
+
+// DeleteContext - delete key
+func (db *RedisDB) DeleteContext(ctx context.Context, key string) error {
+	return db.do(ctx, func(c *redis.Client) error {
+		if _, err := db.hget(c, key); err != nil {
+			return err
+		}
+		return db.apply(c, []redisWrite{{key: key, deleted: true}})
+	})
+}
+
+// ExistsContext - if key existed
+func (db *RedisDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := db.do(ctx, func(c *redis.Client) error {
+		_, err := db.hget(c, key)
+		ok = err == nil
+		if err == redis.Nil {
+			return nil
+		}
+		return err
+	})
+	return ok, err
+}
+
+// ScanContext - call handler on every kv in HSCAN order
+func (db *RedisDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	var expired map[string]bool
+	if err := db.do(ctx, func(c *redis.Client) error {
+		var err error
+		expired, err = db.expiredFields()
+		return err
+	}); err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		var kvs []string
+		err := db.do(ctx, func(c *redis.Client) error {
+			var err error
+			kvs, cursor, err = c.HScan(db.HashKey, cursor, "", 10).Result()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		// HSCAN returns field and value in turn
+		for i := 0; i+1 < len(kvs); i += 2 {
+			if expired[kvs[i]] {
 				continue
 			}
-			if i := handler([]byte(k), v); i.Result {
-				data = append(data, i.Data)
-				index++
+			if err := handler([]byte(kvs[i]), []byte(kvs[i+1])); err != nil {
+				return err
 			}
 		}
-		if index >= (page+1)*db.Count {
-			kv.Data = data
-			return kv
+		if cursor == 0 {
+			return nil
 		}
 	}
-	kv.Data = data
-	return kv
 }
 
-// SetData - set data in object json format
-func (db *RedisDB) SetData(key string, data interface{}) *KVResult {
+// redisTxRetries - times an optimistic transaction is tried
+const redisTxRetries = 10
+
+// redisTxn - KVTxn on watched hashkey, writes are buffered until EXEC
+type redisTxn struct {
+	db       *RedisDB
+	tx       *redis.Tx
+	readOnly bool
+	batch    txBatch
+}
+
+func (t *redisTxn) Get(key string) ([]byte, error) {
+	if v, found, ok := t.batch.get(key); ok {
+		if !found {
+			return nil, ErrNotFound
+		}
+		return v, nil
+	}
+	v, err := t.db.hget(t.tx, key)
+	return v, redisError(err)
+}
+
+func (t *redisTxn) Exists(key string) (bool, error) {
+	if _, found, ok := t.batch.get(key); ok {
+		return found, nil
+	}
+	_, err := t.db.hget(t.tx, key)
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, redisError(err)
+}
+
+func (t *redisTxn) Set(key string, value []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.batch[key] = txWrite{value: value}
+	return nil
+}
+
+func (t *redisTxn) Delete(key string) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.batch[key] = txWrite{deleted: true}
+	return nil
+}
+
+// txn - run fn in a WATCH/MULTI/EXEC transaction on hashkey
+// fn is run again when hashkey changed before EXEC, so it may be
+// called several times and must not have other side effects
+func (db *RedisDB) txn(readOnly bool, fn func(tx KVTxn) error) error {
+	for i := 0; i < redisTxRetries; i++ {
+		err := db.Client.Watch(func(tx *redis.Tx) error {
+			t := &redisTxn{
+				db:       db,
+				tx:       tx,
+				readOnly: readOnly,
+				batch:    make(txBatch),
+			}
+			if err := fn(t); err != nil {
+				return err
+			}
+			if len(t.batch) > 0 {
+				writes := make([]redisWrite, 0, len(t.batch))
+				for k, w := range t.batch {
+					writes = append(writes, redisWrite{key: k, value: w.value, deleted: w.deleted})
+				}
+				return db.apply(tx, writes)
+			}
+			// EXEC fails if hashkey changed since WATCH,
+			// so reads of a View are checked as well
+			_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+				p.HLen(db.HashKey)
+				return nil
+			})
+			return err
+		}, db.HashKey, db.deadlineKey())
+		if err != redis.TxFailedErr {
+			return redisError(err)
+		}
+	}
+	return ErrTxConflict
+}
+
+// Update - run fn in an optimistic redis transaction
+func (db *RedisDB) Update(fn func(tx KVTxn) error) error {
+	return db.txn(false, fn)
+}
+
+// View - run fn on a consistent view of hashkey
+func (db *RedisDB) View(fn func(tx KVTxn) error) error {
+	return db.txn(true, fn)
+}
+
+// deadlineKey - sorted set of fields with ttl, scored by their
+// deadline in unix milliseconds
+func (db *RedisDB) deadlineKey() string {
+	return db.HashKey + ":__deadlines"
+}
+
+// unixMs - t in unix milliseconds
+func unixMs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// expiredAt - if deadline in unix milliseconds has passed at now
+func expiredAt(deadline float64, now time.Time) bool {
+	return int64(deadline) <= unixMs(now)
+}
+
+// hget - value of field, redis.Nil when it's missing or expired
+// c is the client or a transaction watching hashkey
+func (db *RedisDB) hget(c redis.Cmdable, key string) ([]byte, error) {
+	var v *redis.StringCmd
+	var d *redis.FloatCmd
+	if client, ok := c.(*redis.Client); ok {
+		client.Pipelined(func(p redis.Pipeliner) error {
+			v = p.HGet(db.HashKey, key)
+			d = p.ZScore(db.deadlineKey(), key)
+			return nil
+		})
+	} else {
+		v = c.HGet(db.HashKey, key)
+		d = c.ZScore(db.deadlineKey(), key)
+	}
+	data, err := v.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if deadline, err := d.Result(); err == nil && expiredAt(deadline, time.Now()) {
+		return nil, redis.Nil
+	}
+	return data, nil
+}
+
+// redisWrite - write of a field applied by apply
+type redisWrite struct {
+	key     string
+	value   []byte
+	deleted bool
+	// ttl 0 for persistent field
+	ttl time.Duration
+}
+
+// apply - apply writes in one MULTI/EXEC and publish their events
+// old values are got in the same transaction, c is the client or
+// a transaction watching hashkey
+func (db *RedisDB) apply(c redis.Cmdable, writes []redisWrite) error {
+	olds := make([]*redis.StringCmd, len(writes))
+	deadlines := make([]*redis.FloatCmd, len(writes))
+	cmds, err := c.TxPipelined(func(p redis.Pipeliner) error {
+		for i, w := range writes {
+			olds[i] = p.HGet(db.HashKey, w.key)
+			deadlines[i] = p.ZScore(db.deadlineKey(), w.key)
+			if w.deleted {
+				p.HDel(db.HashKey, w.key)
+			} else {
+				p.HSet(db.HashKey, w.key, w.value)
+			}
+			if w.ttl > 0 {
+				deadline := unixMs(time.Now().Add(w.ttl))
+				p.ZAdd(db.deadlineKey(), redis.Z{Score: float64(deadline), Member: w.key})
+			} else {
+				p.ZRem(db.deadlineKey(), w.key)
+			}
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	// HGET and ZSCORE of a new field fail with redis.Nil, any other
+	// failure means writes weren't applied
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && (err != redis.Nil || (cmd.Name() != "hget" && cmd.Name() != "zscore")) {
+			return err
+		}
+	}
+	now := time.Now()
+	events := make([]KVEvent, 0, len(writes))
+	for i, w := range writes {
+		old, err := olds[i].Bytes()
+		if err != nil {
+			old = nil
+		}
+		if w.deleted {
+			if old != nil {
+				events = append(events, deleteEvent(w.key, old))
+			}
+			continue
+		}
+		if d, err := deadlines[i].Result(); err == nil && expiredAt(d, now) {
+			old = nil
+		}
+		events = append(events, putEvent(w.key, old, w.value))
+	}
+	db.notify(events)
+	return nil
+}
+
+// eventChannel - pub/sub channel of changes of hashkey
+func (db *RedisDB) eventChannel() string {
+	return db.HashKey + ":__events"
+}
+
+// notify - queue events for publisher, in order of writes
+func (db *RedisDB) notify(events []KVEvent) {
+	for _, ev := range events {
+		select {
+		case db.events <- ev:
+		case <-db.done:
+			return
+		}
+	}
+}
+
+// publisher - publish queued events until db is closed
+// writes don't wait for PUBLISH, but keep their order
+func (db *RedisDB) publisher() {
+	defer db.wg.Done()
 	var json = jsoniter.ConfigCompatibleWithStandardLibrary
-	value, err := json.Marshal(data)
+	publish := func(ev KVEvent) {
+		if msg, err := json.Marshal(ev); err == nil {
+			db.Client.Publish(db.eventChannel(), msg)
+		}
+	}
+	for {
+		select {
+		case ev := <-db.events:
+			publish(ev)
+		case <-db.done:
+			for {
+				select {
+				case ev := <-db.events:
+					publish(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Watch - events of keys starting with prefix, until ctx is done
+// events come through redis pub/sub, so writes of other processes
+// sharing hashkey are seen as well
+func (db *RedisDB) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	ch := make(chan KVEvent, WatchBuffer)
+	ps := db.Client.Subscribe(db.eventChannel())
+	// wait for subscription, so no later write is missed
+	if _, err := ps.Receive(); err != nil {
+		ps.Close()
+		close(ch)
+		return ch
+	}
+	go func() {
+		defer close(ch)
+		defer ps.Close()
+		var json = jsoniter.ConfigCompatibleWithStandardLibrary
+		msgs := ps.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case m, ok := <-msgs:
+				if !ok {
+					return
+				}
+				var ev KVEvent
+				if err := json.Unmarshal([]byte(m.Payload), &ev); err != nil {
+					continue
+				}
+				if !strings.HasPrefix(ev.Key, prefix) {
+					continue
+				}
+				select {
+				case ch <- ev:
+				default:
+					// fell behind
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// expiredFields - fields expired now and not purged yet, a sweeper
+// purges them, so there are few
+func (db *RedisDB) expiredFields() (map[string]bool, error) {
+	keys, err := db.Client.ZRangeByScore(db.deadlineKey(), redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(unixMs(time.Now()), 10),
+	}).Result()
 	if err != nil {
+		return nil, err
+	}
+	expired := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		expired[k] = true
+	}
+	return expired, nil
+}
+
+// startSweeper - run sweeper if it isn't running and db isn't closed
+func (db *RedisDB) startSweeper() {
+	db.sweepMu.Lock()
+	defer db.sweepMu.Unlock()
+	select {
+	case <-db.done:
+		return
+	default:
+	}
+	if !db.sweeping {
+		db.sweeping = true
+		db.wg.Add(1)
+		go db.sweeper()
+	}
+}
+
+// sweeper - purge expired fields periodically
+// it stops when db is closed or no field with ttl is left
+func (db *RedisDB) sweeper() {
+	defer db.wg.Done()
+	ticker := time.NewTicker(ExpireSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+		}
+		if expired, err := db.expiredFields(); err == nil && len(expired) > 0 {
+			db.purge(expired)
+		}
+		db.sweepMu.Lock()
+		if n, err := db.Client.ZCard(db.deadlineKey()).Result(); err == nil && n == 0 {
+			db.sweeping = false
+			db.sweepMu.Unlock()
+			return
+		}
+		db.sweepMu.Unlock()
+	}
+}
+
+// purge - delete expired fields
+// deadlines are checked again under WATCH, so a field set again
+// meanwhile is kept
+func (db *RedisDB) purge(fields map[string]bool) error {
+	err := db.Client.Watch(func(tx *redis.Tx) error {
+		now := time.Now()
+		var keys []string
+		for k := range fields {
+			if d, err := tx.ZScore(db.deadlineKey(), k).Result(); err == nil && expiredAt(d, now) {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		writes := make([]redisWrite, len(keys))
+		for i, k := range keys {
+			writes[i] = redisWrite{key: k, deleted: true}
+		}
+		return db.apply(tx, writes)
+	}, db.HashKey, db.deadlineKey())
+	if err == redis.TxFailedErr {
+		// changed by others, expired fields are purged next time
+		return nil
+	}
+	return err
+}
+
+// SetWithTTL - set key value that expires after ttl
+// the deadline is kept in a companion sorted set, since redis only
+// expires whole keys
+func (db *RedisDB) SetWithTTL(kv *KVData, ttl time.Duration) *KVResult {
+	if ttl <= 0 {
+		return wrongTTL()
+	}
+	if err := db.apply(db.Client, []redisWrite{{key: kv.Key, value: kv.Value, ttl: ttl}}); err != nil {
 		return &KVResult{
 			Result: false,
 			Info:   err.Error(),
 		}
 	}
-	return db.Set(&KVData{key, value})
+	db.startSweeper()
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+		Info:   "",
+	}
+}
+
+// TTL - remaining time to live of key
+func (db *RedisDB) TTL(key string) (time.Duration, error) {
+	if _, err := db.hget(db.Client, key); err != nil {
+		return 0, redisError(err)
+	}
+	d, err := db.Client.ZScore(db.deadlineKey(), key).Result()
+	if err == redis.Nil {
+		return NoExpiration, nil
+	}
+	if err != nil {
+		return 0, redisError(err)
+	}
+	return time.Until(time.Unix(0, int64(d)*int64(time.Millisecond))), nil
+}
+
+// hmget - values of fields, nil for missing or expired ones
+func (db *RedisDB) hmget(keys []string) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var v *redis.SliceCmd
+	deadlines := make([]*redis.FloatCmd, len(keys))
+	_, err := db.Client.Pipelined(func(p redis.Pipeliner) error {
+		v = p.HMGet(db.HashKey, keys...)
+		for i, k := range keys {
+			deadlines[i] = p.ZScore(db.deadlineKey(), k)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	vals := v.Val()
+	now := time.Now()
+	for i := range vals {
+		if d, err := deadlines[i].Result(); err == nil && expiredAt(d, now) {
+			vals[i] = nil
+		}
+	}
+	return vals, nil
+}
+
+// hmResults - results of values got by hmget
+func hmResults(vals []interface{}) []*KVResult {
+	res := make([]*KVResult, len(vals))
+	for i, v := range vals {
+		s, ok := v.(string)
+		if !ok {
+			res[i] = notExisted()
+			continue
+		}
+		res[i] = &KVResult{
+			Data:   []byte(s),
+			Result: true,
+		}
+	}
+	return res
+}
+
+// MGet - get values of keys by HMGET
+func (db *RedisDB) MGet(keys []string) []*KVResult {
+	vals, err := db.hmget(keys)
+	if err != nil {
+		return batchFailed(len(keys), err)
+	}
+	return hmResults(vals)
+}
+
+// MSet - set kvs in one round trip
+func (db *RedisDB) MSet(kvs []KVData) []*KVResult {
+	if len(kvs) > 0 {
+		writes := make([]redisWrite, len(kvs))
+		for i := range kvs {
+			writes[i] = redisWrite{key: kvs[i].Key, value: kvs[i].Value}
+		}
+		if err := db.apply(db.Client, writes); err != nil {
+			return batchFailed(len(kvs), err)
+		}
+	}
+	res := make([]*KVResult, len(kvs))
+	for i := range kvs {
+		res[i] = &KVResult{
+			Data:   &kvs[i],
+			Result: true,
+		}
+	}
+	return res
+}
+
+// MDelete - delete keys by HDEL, old values are got by HMGET first
+func (db *RedisDB) MDelete(keys []string) []*KVResult {
+	vals, err := db.hmget(keys)
+	if err != nil {
+		return batchFailed(len(keys), err)
+	}
+	if len(keys) > 0 {
+		writes := make([]redisWrite, len(keys))
+		for i, k := range keys {
+			writes[i] = redisWrite{key: k, deleted: true}
+		}
+		if err := db.apply(db.Client, writes); err != nil {
+			return batchFailed(len(keys), err)
+		}
+	}
+	return hmResults(vals)
 }
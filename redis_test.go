@@ -1,10 +1,13 @@
 package db
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -20,9 +23,16 @@ func initRedisDB() error {
 	// 	Count:    20,
 	// }
 	// return testredisdb.Setup()
-	db, err := NewKVDataBase("redis://localhost:6379/serv?count=20")
+	db, err := OpenOrGet("redis://localhost:6379/serv?count=20")
 	if err != nil {
-		return err
+		// no redis server, use a RESPServer over mem instead
+		addr, serr := redisStandIn()
+		if serr != nil {
+			return err
+		}
+		if db, err = OpenOrGet("redis://" + addr + "/serv?count=20"); err != nil {
+			return err
+		}
 	}
 	testredisdb = db.(*RedisDB)
 	return nil
@@ -32,7 +42,7 @@ func initRedisDB() error {
 func TestRedisDB_Set(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 
 	for i := 0; i < 50; i++ {
@@ -47,7 +57,7 @@ func TestRedisDB_Set(t *testing.T) {
 func TestRedisDB_Get(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 
 	for i := 0; i < 50; i++ {
@@ -64,7 +74,7 @@ func TestRedisDB_Get(t *testing.T) {
 func TestRedisDB_KeyCount(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 	fmt.Println(testredisdb.KeyCount())
 }
@@ -72,7 +82,7 @@ func TestRedisDB_KeyCount(t *testing.T) {
 func TestRedisDB_ListKeys(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 	res := testredisdb.ListKeys(1)
 	fmt.Println(res)
@@ -81,7 +91,7 @@ func TestRedisDB_ListKeys(t *testing.T) {
 func TestRedisDB_FindOne(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 	v := testredisdb.FindOne(func(k, v []byte) *KVResult {
 		if strings.Compare(string(k), "key11") == 0 {
@@ -102,7 +112,7 @@ func TestRedisDB_FindOne(t *testing.T) {
 func TestRedisDB_List(t *testing.T) {
 	err := initRedisDB()
 	if err != nil {
-		fmt.Println(err)
+		t.Fatal(err)
 	}
 	v := testredisdb.List(0, func(k, v []byte) *KVResult {
 		if strings.Contains(string(k), "key1") {
@@ -123,3 +133,145 @@ func TestRedisDB_List(t *testing.T) {
 	}
 	fmt.Println(str)
 }
+
+func TestRedisGlobEscape(t *testing.T) {
+	if s := redisGlobEscape(`user:[1]*?\`); s != `user:\[1\]\*\?\\` {
+		t.Fatal(s)
+	}
+}
+
+func TestRedisDB_ScanDelete(t *testing.T) {
+	err := initRedisDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewKVDataBase("redis://" + testredisdb.Address + "/scandelete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(d.Name())
+	db := d.(*RedisDB)
+	for i := 0; i < 25; i++ {
+		if ret := db.Set(&KVData{"key" + strconv.Itoa(i), []byte("v")}); !ret.Result {
+			t.Fatal(ret.Info)
+		}
+	}
+	// deleting returned kvs while scanning must not skip any
+	seen := 0
+	cursor := ""
+	for {
+		items, next, err := db.Scan(cursor, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range items {
+			seen++
+			if ret := db.Delete(kv.Key); !ret.Result {
+				t.Fatal(ret.Info)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if seen != 25 || db.KeyCount() != 0 {
+		t.Fatal(seen, db.KeyCount())
+	}
+}
+
+// failingSet - database failing Set of keys containing "fail" and
+// Delete of those containing "keep"
+type failingSet struct {
+	KVWrapper
+}
+
+func (w *failingSet) Set(kv *KVData) *KVResult {
+	if strings.Contains(kv.Key, "fail") {
+		return failed(ErrReadOnly)
+	}
+	return w.DB.Set(kv)
+}
+
+func (w *failingSet) Delete(key string) *KVResult {
+	if strings.Contains(key, "keep") {
+		return failed(ErrReadOnly)
+	}
+	return w.DB.Delete(key)
+}
+
+func TestRedisDB_FailedWrite(t *testing.T) {
+	mem, err := NewKVDataBase("mem://redis-failing/failing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(mem.Name())
+	s := NewRESPServer(&failingSet{KVWrapper{mem}})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	d, err := NewKVDataBase("redis://" + l.Addr().String() + "/writes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(d.Name())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := d.(KVWatcher).Watch(ctx, "")
+
+	// HSET of a new field fails after its HGET returned nil
+	if ret := d.Set(&KVData{"fail", []byte("x")}); ret.Result {
+		t.Fatal("failed write succeeded")
+	}
+	if ret := d.Set(&KVData{"ok", []byte("y")}); !ret.Result {
+		t.Fatal(ret.Info)
+	}
+	if ev := nextEvent(t, ch); ev.Key != "ok" {
+		t.Fatalf("event of failed write: %+v", ev)
+	}
+
+	// a failed delete fails, and the key is kept
+	if ret := d.Set(&KVData{"keep", []byte("z")}); !ret.Result {
+		t.Fatal(ret.Info)
+	}
+	if ret := d.Delete("keep"); ret.Result || !d.Exists("keep") {
+		t.Fatalf("failed delete: %+v", ret)
+	}
+}
+
+func TestRedisDB_Sweep(t *testing.T) {
+	_, addr := respServer(t, "mem://redis-sweep/sweep", "")
+	d, err := NewKVDataBase("redis://" + addr + "/sweep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(d.Name())
+	db := d.(*RedisDB)
+	db.Set(&KVData{"persist", []byte("v")})
+	db.SetWithTTL(&KVData{"session", []byte("v")}, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	// expired fields are hidden by reads and purged by the sweeper
+	if n := db.KeyCount(); n != 1 {
+		t.Fatalf("%d keys", n)
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		db.sweepMu.Lock()
+		sweeping := db.sweeping
+		db.sweepMu.Unlock()
+		if !sweeping {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("sweeper kept running")
+		}
+	}
+	if n, _ := db.Client.HLen(db.HashKey).Result(); n != 1 {
+		t.Fatalf("%d fields after sweep", n)
+	}
+	if n, _ := db.Client.ZCard(db.deadlineKey()).Result(); n != 0 {
+		t.Fatalf("%d deadlines after sweep", n)
+	}
+}
@@ -0,0 +1,601 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RemoteDefaultRetries - retries of a failed request
+	RemoteDefaultRetries = 3
+	// RemoteDefaultConns - pooled connections to the server
+	RemoteDefaultConns = 16
+	// RemoteDefaultTimeout - timeout waiting for a response
+	RemoteDefaultTimeout = 30 * time.Second
+)
+
+// RemoteDB - database served by a kvdb server (see Handler) over http
+// requests reuse pooled connections, failed idempotent ones are retried
+// with exponential backoff, and MGet, MSet and MDelete send their ops in
+// one pipelined batch request, which is sent once
+type RemoteDB struct {
+	Type *KVDBType
+	// Label - host:port of the server
+	Label string
+	// Remote - name of the database on the server
+	Remote  string
+	Count   uint
+	Retries int
+	// URL - base url of the database, http://host:port/db/<name>
+	URL string
+
+	client *http.Client
+	codec  Codec
+}
+
+func init() {
+	NewKVDatabaseType("kvdb+http", NewRemoteDB)
+	NewKVDatabaseType("kvdb+https", NewRemoteDB)
+}
+
+// NewRemoteDB - new remote database using uri format description
+// format : kvdb+http[s]://<host:port>/<name>?[count=]&[retries=]&[conns=]&[timeout=]&[codec=]
+// name is the database name on the server, as listed by GET /db,
+// which must be open there
+// example kvdb+http://localhost:8080/Bolt_users?retries=5&timeout=10s
+func NewRemoteDB(uri string) (KVMethods, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	t := GetKVDatabaseType(u.Scheme)
+	if t == nil {
+		return nil, errors.New("This type of database [" + u.Scheme + "] didn't exist")
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return nil, errors.New("wrong remote database uri")
+	}
+	db := &RemoteDB{
+		Type:    t,
+		Label:   u.Host,
+		Remote:  name,
+		Retries: RemoteDefaultRetries,
+		URL:     strings.TrimPrefix(u.Scheme, "kvdb+") + "://" + u.Host + "/db/" + url.PathEscape(name),
+	}
+	para := u.Query()
+	if para.Get("count") != "" {
+		i, _ := strconv.Atoi(para.Get("count"))
+		if i <= 0 {
+			return nil, errors.New("wrong count parameter")
+		}
+		db.Count = uint(i)
+	}
+	if para.Get("retries") != "" {
+		db.Retries, err = strconv.Atoi(para.Get("retries"))
+		if err != nil || db.Retries < 0 {
+			return nil, errors.New("wrong retries parameter")
+		}
+	}
+	conns := RemoteDefaultConns
+	if para.Get("conns") != "" {
+		conns, err = strconv.Atoi(para.Get("conns"))
+		if err != nil || conns <= 0 {
+			return nil, errors.New("wrong conns parameter")
+		}
+	}
+	timeout := RemoteDefaultTimeout
+	if para.Get("timeout") != "" {
+		timeout, err = time.ParseDuration(para.Get("timeout"))
+		if err != nil || timeout <= 0 {
+			return nil, errors.New("wrong timeout parameter")
+		}
+	}
+	db.codec, err = codecParam(para.Get("codec"))
+	if err != nil {
+		return nil, err
+	}
+	db.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          conns,
+			MaxIdleConnsPerHost:   conns,
+			MaxConnsPerHost:       conns,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+	// the database must exist on the server
+	if _, err := db.info(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Name - tag different databases
+func (db *RemoteDB) Name() string {
+	return "Remote_" + db.Label + "_" + db.Remote
+}
+
+// DBType - DataBase Type
+func (db *RemoteDB) DBType() *KVDBType {
+	return db.Type
+}
+
+// retryable - if a response status is worth another attempt
+func retryable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// idempotent - if a request of method may be sent again, a POST may have
+// been applied before failing
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// remoteError - error of a KVResult Info sent by the server
+func remoteError(info string) error {
+	switch info {
+	case ErrNotFound.Error():
+		return ErrNotFound
+	case ErrReadOnly.Error():
+		return ErrReadOnly
+	case ErrClosed.Error():
+		return ErrClosed
+	case ErrBucketMissing.Error():
+		return ErrBucketMissing
+	}
+	return errors.New(info)
+}
+
+// do - send a request, retrying network errors and unavailable servers
+// for idempotent methods only
+// a response with an error status is returned as error and closed
+func (db *RemoteDB) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	uri := db.URL + path
+	var err error
+	for i := 0; ; i++ {
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, uri, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+		var res *http.Response
+		res, err = db.client.Do(req)
+		if err == nil {
+			if res.StatusCode < 400 {
+				return res, nil
+			}
+			err = statusError(res)
+			if !retryable(res.StatusCode) {
+				return nil, err
+			}
+		}
+		if ctx.Err() != nil || i >= db.Retries || !idempotent(method) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After((50 * time.Millisecond) << i):
+		}
+	}
+}
+
+// statusError - error of a failed response, the body is closed
+func statusError(res *http.Response) error {
+	defer res.Body.Close()
+	var kvr KVResult
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&kvr); err != nil || kvr.Info == "" {
+		if res.StatusCode == http.StatusNotFound {
+			return ErrNotFound
+		}
+		return errors.New("kvdb server: " + res.Status)
+	}
+	return remoteError(kvr.Info)
+}
+
+// keyPath - path of key below URL
+func keyPath(key string) string {
+	return "/keys/" + url.PathEscape(key)
+}
+
+// GetContext - get value of key
+func (db *RemoteDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	res, err := db.do(ctx, http.MethodGet, keyPath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return io.ReadAll(res.Body)
+}
+
+// SetContext - set key value
+func (db *RemoteDB) SetContext(ctx context.Context, key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	res, err := db.do(ctx, http.MethodPut, keyPath(key), value)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// DeleteContext - delete key
+// a retried delete may find key deleted by the attempt before
+func (db *RemoteDB) DeleteContext(ctx context.Context, key string) error {
+	res, err := db.do(ctx, http.MethodDelete, keyPath(key), nil)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// ExistsContext - if key existed
+func (db *RemoteDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	res, err := db.do(ctx, http.MethodHead, keyPath(key), nil)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// ScanContext - call handler on every kv
+func (db *RemoteDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	cursor := ""
+	for {
+		items, next, err := db.scan(ctx, cursor, scanBatch, nil)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			if err := handler([]byte(items[i].Key), items[i].Value); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// keyListing - body of a key listing
+type keyListing struct {
+	Data   []KVData
+	Next   string
+	Result bool
+	Info   string
+}
+
+// scan - list kvs after cursor, every one when limit is 0
+// q has the prefix or the range of the listing
+func (db *RemoteDB) scan(ctx context.Context, cursor string, limit int, bounds url.Values) ([]KVData, string, error) {
+	q := url.Values{"values": {"1"}}
+	for k, v := range bounds {
+		q[k] = v
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	res, err := db.do(ctx, http.MethodGet, "/keys/?"+q.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	var l keyListing
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return nil, "", err
+	}
+	if !l.Result {
+		return nil, "", remoteError(l.Info)
+	}
+	return l.Data, l.Next, nil
+}
+
+// Scan - list at most limit kvs after cursor
+func (db *RemoteDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	if limit <= 0 {
+		return nil, "", wrongLimit()
+	}
+	return db.scan(context.Background(), cursor, limit, nil)
+}
+
+// ScanPrefix - all kvs whose key starts with prefix in key order
+func (db *RemoteDB) ScanPrefix(prefix string) ([]KVData, error) {
+	return db.scanAll(url.Values{"prefix": {prefix}})
+}
+
+// ScanRange - all kvs with start <= key < end in key order, the server
+// reads only the range
+func (db *RemoteDB) ScanRange(start, end string) ([]KVData, error) {
+	return db.scanAll(rangeQuery(start, end))
+}
+
+// ScanRangeLimit - at most limit kvs with start <= key < end in key order
+func (db *RemoteDB) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	items, _, err := db.scan(context.Background(), "", limit, rangeQuery(start, end))
+	return items, err
+}
+
+// rangeQuery - listing query of start <= key < end
+func rangeQuery(start, end string) url.Values {
+	q := url.Values{}
+	if start != "" {
+		q.Set("start", start)
+	}
+	if end != "" {
+		q.Set("end", end)
+	}
+	return q
+}
+
+// scanAll - every kv listed by q, page by page
+func (db *RemoteDB) scanAll(q url.Values) ([]KVData, error) {
+	bounded := q.Get("prefix") != "" || q.Get("start") != "" || q.Get("end") != ""
+	var items []KVData
+	cursor := ""
+	for {
+		page, next, err := db.scan(context.Background(), cursor, scanBatch, q)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	// without prefix or range kvs are in the order of the server scanner
+	if !bounded {
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	}
+	return items, nil
+}
+
+// batch - run ops in one request
+func (db *RemoteDB) batch(ops []BatchOp) ([]BatchResult, error) {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.do(context.Background(), http.MethodPost, "/batch", body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var out struct {
+		Data   []BatchResult
+		Result bool
+		Info   string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if !out.Result {
+		return nil, remoteError(out.Info)
+	}
+	if len(out.Data) != len(ops) {
+		return nil, errors.New("wrong batch response")
+	}
+	return out.Data, nil
+}
+
+// batchResults - KVResults of ops sent by batch
+func (db *RemoteDB) batchResults(ops []BatchOp, kvs []KVData) []*KVResult {
+	if len(ops) == 0 {
+		return []*KVResult{}
+	}
+	out, err := db.batch(ops)
+	if err != nil {
+		return batchFailed(len(ops), err)
+	}
+	res := make([]*KVResult, len(out))
+	for i, r := range out {
+		switch {
+		case r.Info == ErrNotFound.Error():
+			res[i] = notExisted()
+		case !r.Result:
+			res[i] = failed(remoteError(r.Info))
+		case kvs != nil:
+			res[i] = &KVResult{Data: &kvs[i], Result: true}
+		case ops[i].Op == "get":
+			if r.Value == nil {
+				r.Value = []byte{}
+			}
+			res[i] = &KVResult{Data: r.Value, Result: true}
+		default:
+			res[i] = &KVResult{Result: true}
+		}
+	}
+	return res
+}
+
+// MGet - get values of keys in one request
+func (db *RemoteDB) MGet(keys []string) []*KVResult {
+	ops := make([]BatchOp, len(keys))
+	for i, k := range keys {
+		ops[i] = BatchOp{Op: "get", Key: k}
+	}
+	return db.batchResults(ops, nil)
+}
+
+// MSet - set kvs in one request
+func (db *RemoteDB) MSet(kvs []KVData) []*KVResult {
+	ops := make([]BatchOp, len(kvs))
+	for i := range kvs {
+		ops[i] = BatchOp{Op: "set", Key: kvs[i].Key, Value: kvs[i].Value}
+	}
+	return db.batchResults(ops, kvs)
+}
+
+// MDelete - delete keys in one request
+func (db *RemoteDB) MDelete(keys []string) []*KVResult {
+	ops := make([]BatchOp, len(keys))
+	for i, k := range keys {
+		ops[i] = BatchOp{Op: "delete", Key: k}
+	}
+	return db.batchResults(ops, nil)
+}
+
+// Exists - if key existed
+func (db *RemoteDB) Exists(key string) bool {
+	ok, _ := db.ExistsContext(context.Background(), key)
+	return ok
+}
+
+// Get - get value of key
+func (db *RemoteDB) Get(key string) *KVResult {
+	v, err := db.GetContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   v,
+		Result: true,
+	}
+}
+
+// Set - set key value
+func (db *RemoteDB) Set(kv *KVData) *KVResult {
+	if err := db.SetContext(context.Background(), kv.Key, kv.Value); err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+	}
+}
+
+// Delete - delete key
+func (db *RemoteDB) Delete(key string) *KVResult {
+	err := db.DeleteContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Result: true,
+	}
+}
+
+// FindOne - find first kv matched by handler
+func (db *RemoteDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	var found *KVResult
+	err := scanPages(db, scanBatch, func(kv *KVData) bool {
+		if i := handler([]byte(kv.Key), kv.Value); i.Result {
+			found = i
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return failed(err)
+	}
+	if found == nil {
+		return &KVResult{
+			Result: false,
+			Info:   "not found",
+		}
+	}
+	return found
+}
+
+// info - database as sent by GET /db/{name} of the server
+func (db *RemoteDB) info(ctx context.Context) (*dbInfo, error) {
+	res, err := db.do(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var out struct {
+		Data dbInfo
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return &out.Data, nil
+}
+
+// KeyCount - keys of the database on the server
+func (db *RemoteDB) KeyCount() int {
+	info, err := db.info(context.Background())
+	if err != nil {
+		return 0
+	}
+	return info.Keys
+}
+
+// ListKeys - list keys
+// page - page number
+func (db *RemoteDB) ListKeys(page uint) []string {
+	return scanListKeys(db, page, db.Count)
+}
+
+// List - list content that hander returned
+// page - page number
+func (db *RemoteDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	return scanList(db, page, db.Count, handler)
+}
+
+// SetData - set data encoded by the database codec
+func (db *RemoteDB) SetData(key string, data interface{}) *KVResult {
+	return setData(db, db.codec, key, data)
+}
+
+// GetData - decode value of key into out
+func (db *RemoteDB) GetData(key string, out interface{}) *KVResult {
+	return getData(db, key, out)
+}
+
+// Codec - codec used by SetData
+func (db *RemoteDB) Codec() Codec {
+	return db.codec
+}
+
+// Close - close pooled connections, the server keeps the database open
+func (db *RemoteDB) Close() error {
+	db.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// remoteDB - db served by an httptest server, and a remote client of it
+func remoteDB(t *testing.T, h http.Handler) (KVMethods, *RemoteDB) {
+	t.Helper()
+	db, err := NewKVDataBase("bolt://remote.db/remotetest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseKVDataBase(db.Name()) })
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	r, err := NewKVDataBase("kvdb+" + srv.URL + "/" + url.PathEscape(db.Name()) + "?count=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseKVDataBase(r.Name()) })
+	return db, r.(*RemoteDB)
+}
+
+func TestRemoteDB(t *testing.T) {
+	db, r := remoteDB(t, NewHandler())
+
+	if kvr := r.Set(&KVData{"user/1", []byte("alice")}); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	checkValue(t, db, "user/1", "alice")
+	checkValue(t, r, "user/1", "alice")
+	if !r.Exists("user/1") || r.Exists("missing") {
+		t.Fatal("exists")
+	}
+	if kvr := r.Get("missing"); kvr.Result {
+		t.Fatal("got missing key")
+	}
+	if _, err := r.GetContext(context.Background(), "missing"); err != ErrNotFound {
+		t.Fatalf("get missing: %v", err)
+	}
+	if kvr := r.Delete("user/1"); !kvr.Result || db.Exists("user/1") {
+		t.Fatalf("delete: %+v", kvr)
+	}
+	if kvr := r.Delete("user/1"); kvr.Result {
+		t.Fatal("deleted missing key")
+	}
+
+	// batches
+	kvs := make([]KVData, 25)
+	keys := make([]string, 25)
+	for i := range kvs {
+		keys[i] = string(rune('a'+i)) + "key"
+		kvs[i] = KVData{keys[i], []byte(keys[i])}
+	}
+	for _, kvr := range r.MSet(kvs) {
+		if !kvr.Result {
+			t.Fatal(kvr.Info)
+		}
+	}
+	res := r.MGet(append(keys, "missing"))
+	if len(res) != 26 || string(res[3].Data.([]byte)) != "dkey" || res[25].Result {
+		t.Fatalf("mget: %+v", res)
+	}
+	if r.KeyCount() != 25 || db.KeyCount() != 25 {
+		t.Fatalf("%d keys", r.KeyCount())
+	}
+
+	// listing
+	if page := r.ListKeys(2); len(page) != 5 || page[0] != "ukey" {
+		t.Fatalf("page 2: %v", page)
+	}
+	items, err := r.ScanRange("c", "f")
+	if err != nil || len(items) != 3 || items[2].Key != "ekey" {
+		t.Fatalf("range: %v %v", items, err)
+	}
+	if items, err = r.ScanPrefix("x"); err != nil || len(items) != 1 || string(items[0].Value) != "xkey" {
+		t.Fatalf("prefix: %v %v", items, err)
+	}
+	if kvr := r.FindOne(func(k, v []byte) *KVResult {
+		return &KVResult{Data: string(k), Result: strings.HasPrefix(string(k), "q")}
+	}); !kvr.Result || kvr.Data != "qkey" {
+		t.Fatalf("find: %+v", kvr)
+	}
+	for _, kvr := range r.MDelete(keys[:20]) {
+		if !kvr.Result {
+			t.Fatal(kvr.Info)
+		}
+	}
+	if db.KeyCount() != 5 {
+		t.Fatalf("%d keys after mdelete", db.KeyCount())
+	}
+
+	var out KVData
+	r.SetData("data", &KVData{"a", []byte("1")})
+	if kvr := r.GetData("data", &out); !kvr.Result || out.Key != "a" {
+		t.Fatalf("data: %+v", kvr)
+	}
+
+	if _, err := NewKVDataBase("kvdb+http://" + r.Label + "/nodb"); err == nil {
+		t.Fatal("opened missing database")
+	}
+}
+
+func TestRemoteDB_Retry(t *testing.T) {
+	var calls int32
+	h := NewHandler()
+	_, r := remoteDB(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// every other request is refused once
+		if atomic.AddInt32(&calls, 1)%2 == 0 {
+			replyError(w, http.StatusServiceUnavailable, ErrClosed)
+			return
+		}
+		h.ServeHTTP(w, req)
+	}))
+	if kvr := r.Set(&KVData{"key", []byte("value")}); !kvr.Result {
+		t.Fatal(kvr.Info)
+	}
+	checkValue(t, r, "key", "value")
+
+	r.Retries = 0
+	n := 0
+	for i := 0; i < 4; i++ {
+		if _, err := r.GetContext(context.Background(), "key"); err == ErrClosed {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("%d of 4 requests failed without retries", n)
+	}
+}
+
+func TestRemoteDB_Range(t *testing.T) {
+	var listed []string
+	h := NewHandler()
+	db, r := remoteDB(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/keys/") {
+			listed = append(listed, req.URL.RawQuery)
+		}
+		h.ServeHTTP(w, req)
+	}))
+	kvs := make([]KVData, 300)
+	for i := range kvs {
+		kvs[i] = KVData{fmt.Sprintf("k%03d", i), []byte("v")}
+	}
+	db.(KVBatch).MSet(kvs)
+
+	// the range is sent to the server, in pages
+	items, err := r.ScanRange("k010", "k260")
+	if err != nil || len(items) != 250 || items[0].Key != "k010" || items[249].Key != "k259" {
+		t.Fatalf("range: %d %v", len(items), err)
+	}
+	if len(listed) != 3 {
+		t.Fatalf("%d requests for 250 kvs", len(listed))
+	}
+	for _, q := range listed {
+		if v, _ := url.ParseQuery(q); v.Get("start") != "k010" || v.Get("end") != "k260" {
+			t.Fatalf("listed %s", q)
+		}
+	}
+	if items, err = r.ScanRangeLimit("k290", "", 5); err != nil || len(items) != 5 || items[4].Key != "k294" {
+		t.Fatalf("range limit: %v %v", items, err)
+	}
+	if items, err = r.ScanPrefix("k1"); err != nil || len(items) != 100 || items[99].Key != "k199" {
+		t.Fatalf("prefix: %d %v", len(items), err)
+	}
+	if items, err = r.ScanRange("", ""); err != nil || len(items) != 300 {
+		t.Fatalf("whole range: %d %v", len(items), err)
+	}
+}
+
+func TestRemoteDB_RetryIdempotent(t *testing.T) {
+	var posts int32
+	h := NewHandler()
+	_, r := remoteDB(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// a batch is lost behind a failing proxy
+		if req.Method == http.MethodPost {
+			atomic.AddInt32(&posts, 1)
+			replyError(w, http.StatusBadGateway, ErrClosed)
+			return
+		}
+		h.ServeHTTP(w, req)
+	}))
+	for _, kvr := range r.MSet([]KVData{{"a", []byte("1")}, {"b", []byte("2")}}) {
+		if kvr.Result {
+			t.Fatal("mset through a failing proxy")
+		}
+	}
+	if posts != 1 {
+		t.Fatalf("batch sent %d times", posts)
+	}
+}
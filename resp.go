@@ -0,0 +1,1214 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RESPSeparator - between hash key and field in keys of the database
+const RESPSeparator = "\x00"
+
+// RESPMaxBulk - largest bulk string of a request, as in redis
+const RESPMaxBulk = 512 << 20
+
+// RESPScanCursors - cursors of SCAN and HSCAN kept, the oldest are
+// invalid once more were returned
+const RESPScanCursors = 1 << 14
+
+// RESPPubSubBuffer - messages waiting to be written to a subscriber, a
+// subscriber falling further behind is disconnected, as by redis
+const RESPPubSubBuffer = 1024
+
+// RESPServer - redis protocol (RESP2) server on a kvdb database, so
+// redis-cli, other redis clients and RedisDB can use any backend
+// field f of hash key h is the key h+RESPSeparator+f of DB, and GET,
+// SET, DEL, EXISTS and SCAN work on the fields of hash key Default
+//
+//	PING ECHO QUIT AUTH SELECT 0 COMMAND
+//	HGET HSET HMSET HDEL HEXISTS HLEN HGETALL HMGET HSCAN
+//	ZADD ZREM ZSCORE ZCARD ZRANGEBYSCORE
+//	GET SET DEL EXISTS SCAN DBSIZE
+//	MULTI EXEC DISCARD WATCH UNWATCH
+//	PUBLISH SUBSCRIBE UNSUBSCRIBE
+//
+// a sorted set is kept like a hash of the scores of its members
+// HSCAN and SCAN read COUNT fields in key order, their cursor stands
+// for the last field read
+// commands of different clients run at once, but not during an EXEC,
+// so MULTI/EXEC is atomic as long as DB is only written through the
+// server
+// PUBLISH doesn't wait for subscribers, their messages are buffered
+type RESPServer struct {
+	// DB - database of every hash key
+	DB KVMethods
+	// Default - hash key of GET, SET, DEL, EXISTS and SCAN
+	Default string
+	// Password - password of AUTH, "" for none
+	Password string
+
+	// commands run under RLock, EXEC under Lock
+	lock sync.RWMutex
+	// serialize HSET of a field, so it tells new fields
+	stripes [shardStripes]sync.Mutex
+	// guards everything below
+	mu       sync.Mutex
+	versions map[string]uint64
+	cursors  map[uint64]scanCursor
+	// cursors in the order they were made, the oldest first
+	cursorIDs []uint64
+	channels  map[string]map[*respConn]bool
+	listeners map[net.Listener]bool
+	conns     map[*respConn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewRESPServer - server on db, with hash key "default" for strings
+func NewRESPServer(db KVMethods) *RESPServer {
+	return &RESPServer{
+		DB:      db,
+		Default: "default",
+	}
+}
+
+// respConn - client connection
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// w is written under wmu, by the connection and by PUBLISH
+	wmu     sync.Mutex
+	w       *bufio.Writer
+	auth    bool
+	multi   bool
+	queued  [][]string
+	dirty   bool
+	watched map[string]uint64
+	subs    map[string]bool
+	// messages published to c, written by a goroutine of their own
+	msgs chan [2]string
+}
+
+// ListenAndServe - listen on tcp addr and serve clients
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve - serve clients connecting to l until Close
+// nil is returned once the server was closed
+func (s *RESPServer) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]bool)
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		c := &respConn{
+			conn: conn,
+			r:    bufio.NewReaderSize(conn, 64<<10),
+			w:    bufio.NewWriter(conn),
+			auth: s.Password == "",
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		if s.conns == nil {
+			s.conns = make(map[*respConn]bool)
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			s.serve(c)
+			conn.Close()
+			s.mu.Lock()
+			delete(s.conns, c)
+			for ch := range c.subs {
+				s.drop(c, ch)
+			}
+			if c.msgs != nil {
+				close(c.msgs)
+			}
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close - stop listeners and close client connections, DB is left open
+func (s *RESPServer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// touch - key was written, failing EXEC of clients watching it
+func (s *RESPServer) touch(key string) {
+	s.mu.Lock()
+	if s.versions == nil {
+		s.versions = make(map[string]uint64)
+	}
+	s.versions[key]++
+	s.mu.Unlock()
+}
+
+// version - times key was written
+func (s *RESPServer) version(key string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[key]
+}
+
+// errProtocol - malformed request, the connection is closed
+var errProtocol = errors.New("Protocol error")
+
+// readLine - line without \r\n
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errProtocol
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// readCommand - next request, an array of bulk strings or an inline
+// command split on spaces
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > 1<<20 {
+		return nil, errProtocol
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > RESPMaxBulk {
+			return nil, errProtocol
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		if b[size] != '\r' || b[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+func (c *respConn) status(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+func (c *respConn) error(s string) {
+	c.w.WriteString("-" + s + "\r\n")
+}
+
+func (c *respConn) int(n int) {
+	c.w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+// bulk - bulk string, nil for the null bulk string
+func (c *respConn) bulk(b []byte) {
+	if b == nil {
+		c.w.WriteString("$-1\r\n")
+		return
+	}
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+// array - header of n elements, -1 for the null array
+func (c *respConn) array(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// fail - reply err as ERR
+func (c *respConn) fail(err error) {
+	c.error("ERR " + strings.ReplaceAll(err.Error(), "\n", " "))
+}
+
+// serve - run commands of c until it quit or failed
+func (s *RESPServer) serve(c *respConn) {
+	for {
+		args, err := c.readCommand()
+		if err == errProtocol {
+			c.wmu.Lock()
+			c.error("ERR Protocol error")
+			c.w.Flush()
+			c.wmu.Unlock()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if s.dispatch(c, args) {
+			return
+		}
+	}
+}
+
+// respCommand - command of RESPServer
+type respCommand struct {
+	// arity - args with the command name, -n for at least n
+	arity int
+	run   func(s *RESPServer, c *respConn, args []string)
+}
+
+var respCommands = map[string]respCommand{
+	"ping":          {-1, (*RESPServer).ping},
+	"echo":          {2, (*RESPServer).echo},
+	"quit":          {1, (*RESPServer).quit},
+	"auth":          {2, (*RESPServer).auth},
+	"select":        {2, (*RESPServer).selectDB},
+	"command":       {-1, (*RESPServer).command},
+	"hget":          {3, (*RESPServer).hget},
+	"hset":          {-4, (*RESPServer).hset},
+	"hmset":         {-4, (*RESPServer).hset},
+	"hdel":          {-3, (*RESPServer).hdel},
+	"hexists":       {3, (*RESPServer).hexists},
+	"hlen":          {2, (*RESPServer).hlen},
+	"hgetall":       {2, (*RESPServer).hgetall},
+	"hmget":         {-3, (*RESPServer).hmget},
+	"hscan":         {-3, (*RESPServer).hscan},
+	"zadd":          {-4, (*RESPServer).zadd},
+	"zrem":          {-3, (*RESPServer).hdel},
+	"zscore":        {3, (*RESPServer).hget},
+	"zcard":         {2, (*RESPServer).hlen},
+	"zrangebyscore": {-4, (*RESPServer).zrangebyscore},
+	"get":           {2, (*RESPServer).get},
+	"set":           {3, (*RESPServer).set},
+	"del":           {-2, (*RESPServer).del},
+	"exists":        {-2, (*RESPServer).exists},
+	"scan":          {-2, (*RESPServer).scan},
+	"dbsize":        {1, (*RESPServer).dbsize},
+	"multi":         {1, (*RESPServer).multi},
+	"discard":       {1, (*RESPServer).discard},
+	"watch":         {-2, (*RESPServer).watch},
+	"unwatch":       {1, (*RESPServer).unwatch},
+	"publish":       {3, (*RESPServer).publish},
+	"subscribe":     {-2, (*RESPServer).subscribe},
+	"unsubscribe":   {-1, (*RESPServer).unsubscribe},
+}
+
+// dispatch - run or queue a command, reply it and tell if c quit
+// commands run under RLock and EXEC under Lock, the reply is written
+// under wmu taken after them
+func (s *RESPServer) dispatch(c *respConn, args []string) bool {
+	name := strings.ToLower(args[0])
+	if name == "exec" && c.multi {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+	} else {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+	}
+	c.wmu.Lock()
+	defer func() {
+		if c.r.Buffered() == 0 {
+			c.w.Flush()
+		}
+		c.wmu.Unlock()
+	}()
+
+	if name == "exec" {
+		s.exec(c)
+		return false
+	}
+	cmd, ok := respCommands[name]
+	switch {
+	case !ok:
+		c.error("ERR unknown command '" + args[0] + "'")
+	case (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity:
+		c.error("ERR wrong number of arguments for '" + name + "' command")
+	case !c.auth && name != "auth" && name != "quit":
+		c.error("NOAUTH Authentication required.")
+		return false
+	case len(c.subs) > 0 && name != "subscribe" && name != "unsubscribe" && name != "ping" && name != "quit":
+		c.error("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context")
+		return false
+	case c.multi && name != "multi" && name != "discard" && name != "watch":
+		c.queued = append(c.queued, args)
+		c.status("QUEUED")
+		return false
+	default:
+		cmd.run(s, c, args)
+		return name == "quit"
+	}
+	if c.multi {
+		c.dirty = true
+	}
+	return false
+}
+
+// exec - run queued commands unless a watched key was written
+func (s *RESPServer) exec(c *respConn) {
+	if !c.multi {
+		c.error("ERR EXEC without MULTI")
+		return
+	}
+	queued, dirty, watched := c.queued, c.dirty, c.watched
+	c.multi, c.queued, c.dirty, c.watched = false, nil, false, nil
+	if dirty {
+		c.error("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	for key, v := range watched {
+		if s.version(key) != v {
+			c.array(-1)
+			return
+		}
+	}
+	c.array(len(queued))
+	for _, args := range queued {
+		respCommands[strings.ToLower(args[0])].run(s, c, args)
+	}
+}
+
+func (s *RESPServer) ping(c *respConn, args []string) {
+	if len(args) > 2 {
+		c.error("ERR wrong number of arguments for 'ping' command")
+		return
+	}
+	msg := ""
+	if len(args) == 2 {
+		msg = args[1]
+	}
+	if len(c.subs) > 0 {
+		c.array(2)
+		c.bulk([]byte("pong"))
+		c.bulk([]byte(msg))
+		return
+	}
+	if len(args) == 2 {
+		c.bulk([]byte(msg))
+		return
+	}
+	c.status("PONG")
+}
+
+func (s *RESPServer) echo(c *respConn, args []string) {
+	c.bulk([]byte(args[1]))
+}
+
+func (s *RESPServer) quit(c *respConn, args []string) {
+	c.status("OK")
+}
+
+func (s *RESPServer) auth(c *respConn, args []string) {
+	if s.Password == "" {
+		c.error("ERR Client sent AUTH, but no password is set")
+		return
+	}
+	if args[1] != s.Password {
+		c.auth = false
+		c.error("ERR invalid password")
+		return
+	}
+	c.auth = true
+	c.status("OK")
+}
+
+// selectDB - only db 0 is served
+func (s *RESPServer) selectDB(c *respConn, args []string) {
+	if args[1] != "0" {
+		c.error("ERR DB index is out of range")
+		return
+	}
+	c.status("OK")
+}
+
+// command - no command docs, enough for redis-cli
+func (s *RESPServer) command(c *respConn, args []string) {
+	c.array(0)
+}
+
+// prefix - prefix of fields of hash key in DB, replying an error for
+// hash keys holding RESPSeparator
+func (s *RESPServer) prefix(c *respConn, hashkey string) (string, bool) {
+	if strings.Contains(hashkey, RESPSeparator) {
+		c.error("ERR wrong hash key")
+		return "", false
+	}
+	return hashkey + RESPSeparator, true
+}
+
+// fieldValue - value of field, nil when it's missing
+func (s *RESPServer) fieldValue(prefix, field string) ([]byte, error) {
+	v, err := KVStoreOf(s.DB).GetContext(context.Background(), prefix+field)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err == nil && v == nil {
+		v = []byte{}
+	}
+	return v, err
+}
+
+// fields - fields of hash key with prefix in key order
+func (s *RESPServer) fields(prefix string) ([]KVData, error) {
+	var items []KVData
+	if sc, ok := s.DB.(KVScanner); ok {
+		var err error
+		if items, err = sc.ScanPrefix(prefix); err != nil {
+			return nil, err
+		}
+	} else {
+		err := KVStoreOf(s.DB).ScanContext(context.Background(), func(k, v []byte) error {
+			if strings.HasPrefix(string(k), prefix) {
+				items = append(items, KVData{string(k), v})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	}
+	for i := range items {
+		items[i].Key = strings.TrimPrefix(items[i].Key, prefix)
+		if items[i].Value == nil {
+			items[i].Value = []byte{}
+		}
+	}
+	return items, nil
+}
+
+func (s *RESPServer) hget(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	v, err := s.fieldValue(prefix, args[2])
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.bulk(v)
+}
+
+// hset - HSET replies fields added, HMSET OK
+func (s *RESPServer) hset(c *respConn, args []string) {
+	if len(args)%2 != 0 {
+		c.error("ERR wrong number of arguments for '" + strings.ToLower(args[0]) + "' command")
+		return
+	}
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	st := KVStoreOf(s.DB)
+	ctx := context.Background()
+	added := 0
+	for i := 2; i < len(args); i += 2 {
+		key := prefix + args[i]
+		l := &s.stripes[ringHash(key)%shardStripes]
+		l.Lock()
+		ok, err := st.ExistsContext(ctx, key)
+		if err == nil {
+			err = st.SetContext(ctx, key, []byte(args[i+1]))
+		}
+		l.Unlock()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if !ok {
+			added++
+		}
+		s.touch(args[1])
+	}
+	if strings.ToLower(args[0]) == "hmset" {
+		c.status("OK")
+		return
+	}
+	c.int(added)
+}
+
+func (s *RESPServer) hdel(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	n := 0
+	for _, f := range args[2:] {
+		err := KVStoreOf(s.DB).DeleteContext(context.Background(), prefix+f)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		n++
+		s.touch(args[1])
+	}
+	c.int(n)
+}
+
+func (s *RESPServer) hexists(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	ok, err := KVStoreOf(s.DB).ExistsContext(context.Background(), prefix+args[2])
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	if ok {
+		c.int(1)
+	} else {
+		c.int(0)
+	}
+}
+
+func (s *RESPServer) hlen(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	items, err := s.fields(prefix)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.int(len(items))
+}
+
+func (s *RESPServer) hgetall(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	items, err := s.fields(prefix)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.array(2 * len(items))
+	for _, kv := range items {
+		c.bulk([]byte(kv.Key))
+		c.bulk(kv.Value)
+	}
+}
+
+func (s *RESPServer) hmget(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	fields := args[2:]
+	vals := make([][]byte, len(fields))
+	if b, ok := s.DB.(KVBatch); ok {
+		keys := make([]string, len(fields))
+		for i, f := range fields {
+			keys[i] = prefix + f
+		}
+		for i, kvr := range b.MGet(keys) {
+			if !kvr.Result {
+				continue
+			}
+			v, err := resultBytes(kvr.Data)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if v == nil {
+				v = []byte{}
+			}
+			vals[i] = v
+		}
+	} else {
+		for i, f := range fields {
+			v, err := s.fieldValue(prefix, f)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			vals[i] = v
+		}
+	}
+	c.array(len(vals))
+	for _, v := range vals {
+		c.bulk(v)
+	}
+}
+
+func (s *RESPServer) hscan(c *respConn, args []string) {
+	s.scanReply(c, args[1], args[2:], true)
+}
+
+// scanCursor - position of a SCAN or HSCAN, after field of the hash
+// key of prefix
+type scanCursor struct {
+	prefix, field string
+}
+
+// cursorID - cursor of SCAN or HSCAN after field, kept until
+// RESPScanCursors newer ones were made
+func (s *RESPServer) cursorID(prefix, field string) uint64 {
+	id := ringHash(prefix + field)
+	if id == 0 {
+		id = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cursors == nil {
+		s.cursors = make(map[uint64]scanCursor)
+	}
+	if _, ok := s.cursors[id]; !ok {
+		if len(s.cursorIDs) == RESPScanCursors {
+			delete(s.cursors, s.cursorIDs[0])
+			s.cursorIDs = s.cursorIDs[1:]
+		}
+		s.cursorIDs = append(s.cursorIDs, id)
+	}
+	s.cursors[id] = scanCursor{prefix, field}
+	return id
+}
+
+// scanField - field a cursor of prefix stands for
+func (s *RESPServer) scanField(prefix string, id uint64) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.cursors[id]
+	return cur.field, ok && cur.prefix == prefix
+}
+
+// fieldPage - at most count fields of hash key with prefix in key
+// order, after field after when resume, more tells if any is left
+func (s *RESPServer) fieldPage(prefix, after string, resume bool, count int) ([]KVData, bool, error) {
+	var items []KVData
+	if sc, ok := s.DB.(KVScanner); ok {
+		start := prefix
+		if resume {
+			start = prefix + after + "\x00"
+		}
+		var err error
+		if items, err = scanRangeLimit(sc, start, prefixEnd(prefix), count+1); err != nil {
+			return nil, false, err
+		}
+		for i := range items {
+			items[i].Key = strings.TrimPrefix(items[i].Key, prefix)
+			if items[i].Value == nil {
+				items[i].Value = []byte{}
+			}
+		}
+	} else {
+		all, err := s.fields(prefix)
+		if err != nil {
+			return nil, false, err
+		}
+		if resume {
+			n := sort.Search(len(all), func(i int) bool { return all[i].Key > after })
+			all = all[n:]
+		}
+		if len(all) > count+1 {
+			all = all[:count+1]
+		}
+		items = all
+	}
+	if len(items) > count {
+		return items[:count], true, nil
+	}
+	return items, false, nil
+}
+
+// scanReply - reply SCAN or HSCAN of hash key
+// args are cursor [MATCH pattern] [COUNT count], values are replied
+// after their fields for HSCAN
+// COUNT fields are read, 10 by default, those matched are replied
+func (s *RESPServer) scanReply(c *respConn, hashkey string, args []string, values bool) {
+	prefix, ok := s.prefix(c, hashkey)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		c.error("ERR invalid cursor")
+		return
+	}
+	match := ""
+	count := 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.error("ERR syntax error")
+			return
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			match = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count <= 0 {
+				c.error("ERR syntax error")
+				return
+			}
+		default:
+			c.error("ERR syntax error")
+			return
+		}
+	}
+	after := ""
+	if id != 0 {
+		if after, ok = s.scanField(prefix, id); !ok {
+			c.error("ERR invalid cursor")
+			return
+		}
+	}
+	items, more, err := s.fieldPage(prefix, after, id != 0, count)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	next := uint64(0)
+	if more {
+		next = s.cursorID(prefix, items[len(items)-1].Key)
+	}
+	n := 0
+	for _, kv := range items {
+		if match == "" || globMatch(match, kv.Key) {
+			items[n] = kv
+			n++
+		}
+	}
+	items = items[:n]
+	c.array(2)
+	c.bulk([]byte(strconv.FormatUint(next, 10)))
+	if values {
+		c.array(2 * len(items))
+	} else {
+		c.array(len(items))
+	}
+	for _, kv := range items {
+		c.bulk([]byte(kv.Key))
+		if values {
+			c.bulk(kv.Value)
+		}
+	}
+}
+
+// zadd - ZADD key score member [score member ...], members are fields
+// of key valued by their score
+func (s *RESPServer) zadd(c *respConn, args []string) {
+	if len(args)%2 != 0 {
+		c.error("ERR syntax error")
+		return
+	}
+	hset := []string{"hset", args[1]}
+	for i := 2; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(score) {
+			c.error("ERR value is not a valid float")
+			return
+		}
+		hset = append(hset, args[i+1], strconv.FormatFloat(score, 'f', -1, 64))
+	}
+	s.hset(c, hset)
+}
+
+// scoreBound - min or max of ZRANGEBYSCORE, exclusive when it starts
+// with (
+type scoreBound struct {
+	v         float64
+	exclusive bool
+}
+
+func parseScoreBound(bound string) (scoreBound, bool) {
+	b := scoreBound{exclusive: strings.HasPrefix(bound, "(")}
+	v, err := strconv.ParseFloat(strings.TrimPrefix(bound, "("), 64)
+	b.v = v
+	return b, err == nil && !math.IsNaN(v)
+}
+
+// above - if score is above b as a min
+func (b scoreBound) above(score float64) bool {
+	return score > b.v || (!b.exclusive && score == b.v)
+}
+
+// below - if score is below b as a max
+func (b scoreBound) below(score float64) bool {
+	return score < b.v || (!b.exclusive && score == b.v)
+}
+
+// zrangebyscore - ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+// members in order of score, then member
+func (s *RESPServer) zrangebyscore(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, args[1])
+	if !ok {
+		return
+	}
+	min, minOK := parseScoreBound(args[2])
+	max, maxOK := parseScoreBound(args[3])
+	if !minOK || !maxOK {
+		c.error("ERR min or max is not a float")
+		return
+	}
+	withScores := false
+	offset, count := 0, -1
+	for i := 4; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "withscores"):
+			withScores = true
+		case strings.EqualFold(args[i], "limit") && i+2 < len(args):
+			var oerr, cerr error
+			offset, oerr = strconv.Atoi(args[i+1])
+			count, cerr = strconv.Atoi(args[i+2])
+			if oerr != nil || cerr != nil {
+				c.error("ERR value is not an integer or out of range")
+				return
+			}
+			i += 2
+		default:
+			c.error("ERR syntax error")
+			return
+		}
+	}
+	items, err := s.fields(prefix)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	type member struct {
+		name  string
+		score float64
+	}
+	var members []member
+	for _, kv := range items {
+		score, err := strconv.ParseFloat(string(kv.Value), 64)
+		if err != nil {
+			c.error("WRONGTYPE Operation against a key holding the wrong kind of value")
+			return
+		}
+		if min.above(score) && max.below(score) {
+			members = append(members, member{kv.Key, score})
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool { return members[i].score < members[j].score })
+	if offset < 0 || offset > len(members) {
+		offset = len(members)
+	}
+	members = members[offset:]
+	if count >= 0 && count < len(members) {
+		members = members[:count]
+	}
+	if withScores {
+		c.array(2 * len(members))
+	} else {
+		c.array(len(members))
+	}
+	for _, m := range members {
+		c.bulk([]byte(m.name))
+		if withScores {
+			c.bulk([]byte(strconv.FormatFloat(m.score, 'f', -1, 64)))
+		}
+	}
+}
+
+// globMatch - if s matches redis glob pattern, * ? [a-z] [^a] and \ escapes
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					match = match || pattern[1] == s[0]
+					pattern = pattern[2:]
+				case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					match = match || (s[0] >= lo && s[0] <= hi)
+					pattern = pattern[3:]
+				default:
+					match = match || pattern[0] == s[0]
+					pattern = pattern[1:]
+				}
+			}
+			if match == not || len(pattern) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func (s *RESPServer) get(c *respConn, args []string) {
+	s.hget(c, []string{"hget", s.Default, args[1]})
+}
+
+// set - field of Default, watched as key of its own as well
+func (s *RESPServer) set(c *respConn, args []string) {
+	s.hset(c, []string{"hmset", s.Default, args[1], args[2]})
+	s.touch(args[1])
+}
+
+func (s *RESPServer) del(c *respConn, args []string) {
+	s.hdel(c, append([]string{"hdel", s.Default}, args[1:]...))
+	for _, key := range args[1:] {
+		s.touch(key)
+	}
+}
+
+func (s *RESPServer) exists(c *respConn, args []string) {
+	prefix, ok := s.prefix(c, s.Default)
+	if !ok {
+		return
+	}
+	n := 0
+	for _, key := range args[1:] {
+		ok, err := KVStoreOf(s.DB).ExistsContext(context.Background(), prefix+key)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		if ok {
+			n++
+		}
+	}
+	c.int(n)
+}
+
+func (s *RESPServer) scan(c *respConn, args []string) {
+	s.scanReply(c, s.Default, args[1:], false)
+}
+
+func (s *RESPServer) dbsize(c *respConn, args []string) {
+	s.hlen(c, []string{"hlen", s.Default})
+}
+
+func (s *RESPServer) multi(c *respConn, args []string) {
+	if c.multi {
+		c.error("ERR MULTI calls can not be nested")
+		return
+	}
+	c.multi = true
+	c.status("OK")
+}
+
+func (s *RESPServer) discard(c *respConn, args []string) {
+	if !c.multi {
+		c.error("ERR DISCARD without MULTI")
+		return
+	}
+	c.multi, c.queued, c.dirty, c.watched = false, nil, false, nil
+	c.status("OK")
+}
+
+func (s *RESPServer) watch(c *respConn, args []string) {
+	if c.multi {
+		c.error("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	if c.watched == nil {
+		c.watched = make(map[string]uint64)
+	}
+	for _, key := range args[1:] {
+		if _, ok := c.watched[key]; !ok {
+			c.watched[key] = s.version(key)
+		}
+	}
+	c.status("OK")
+}
+
+func (s *RESPServer) unwatch(c *respConn, args []string) {
+	c.watched = nil
+	c.status("OK")
+}
+
+// publish - send message to subscribers of channel
+// messages are only queued, a subscriber whose queue is full is
+// disconnected and not counted
+func (s *RESPServer) publish(c *respConn, args []string) {
+	s.mu.Lock()
+	n := 0
+	for sub := range s.channels[args[1]] {
+		select {
+		case sub.msgs <- [2]string{args[1], args[2]}:
+			n++
+		default:
+			sub.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+	c.int(n)
+}
+
+// deliver - write messages published to c until it's gone
+func (s *RESPServer) deliver(c *respConn) {
+	defer s.wg.Done()
+	for m := range c.msgs {
+		c.wmu.Lock()
+		c.array(3)
+		c.bulk([]byte("message"))
+		c.bulk([]byte(m[0]))
+		c.bulk([]byte(m[1]))
+		if len(c.msgs) == 0 {
+			c.w.Flush()
+		}
+		c.wmu.Unlock()
+	}
+}
+
+func (s *RESPServer) subscribe(c *respConn, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[string]bool)
+	}
+	if c.msgs == nil {
+		c.msgs = make(chan [2]string, RESPPubSubBuffer)
+		s.wg.Add(1)
+		go s.deliver(c)
+	}
+	if s.channels == nil {
+		s.channels = make(map[string]map[*respConn]bool)
+	}
+	for _, ch := range args[1:] {
+		c.subs[ch] = true
+		if s.channels[ch] == nil {
+			s.channels[ch] = make(map[*respConn]bool)
+		}
+		s.channels[ch][c] = true
+		c.array(3)
+		c.bulk([]byte("subscribe"))
+		c.bulk([]byte(ch))
+		c.int(len(c.subs))
+	}
+}
+
+// unsubscribe - unsubscribe channels, every one without args
+func (s *RESPServer) unsubscribe(c *respConn, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chs := args[1:]
+	if len(chs) == 0 {
+		for ch := range c.subs {
+			chs = append(chs, ch)
+		}
+	}
+	if len(chs) == 0 {
+		c.array(3)
+		c.bulk([]byte("unsubscribe"))
+		c.bulk(nil)
+		c.int(0)
+		return
+	}
+	for _, ch := range chs {
+		s.drop(c, ch)
+		c.array(3)
+		c.bulk([]byte("unsubscribe"))
+		c.bulk([]byte(ch))
+		c.int(len(c.subs))
+	}
+}
+
+// drop - remove c from subscribers of ch, under mu
+func (s *RESPServer) drop(c *respConn, ch string) {
+	delete(c.subs, ch)
+	delete(s.channels[ch], c)
+	if len(s.channels[ch]) == 0 {
+		delete(s.channels, ch)
+	}
+}
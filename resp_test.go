@@ -0,0 +1,367 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// respServer - RESPServer with password over database of uri on a
+// local port
+func respServer(t *testing.T, uri, password string) (*RESPServer, string) {
+	t.Helper()
+	db, err := NewKVDataBase(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseKVDataBase(db.Name()) })
+	s := NewRESPServer(db)
+	s.Password = password
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+var (
+	standInOnce sync.Once
+	standInAddr string
+	standInErr  error
+)
+
+// redisStandIn - address of a RESPServer over mem, for redis tests
+// when no redis server is running
+func redisStandIn() (string, error) {
+	standInOnce.Do(func() {
+		db, err := OpenOrGet("mem://redis-standin/redis")
+		if err != nil {
+			standInErr = err
+			return
+		}
+		s := NewRESPServer(db)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			standInErr = err
+			return
+		}
+		go s.Serve(l)
+		standInAddr = l.Addr().String()
+	})
+	return standInAddr, standInErr
+}
+
+func TestRESPServer(t *testing.T) {
+	_, addr := respServer(t, "bolt://resp.db/resp?path="+t.TempDir(), "")
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	defer c.Close()
+
+	if n, err := c.HSet("users", "1", "alice").Result(); err != nil || !n {
+		t.Fatalf("hset: %v %v", n, err)
+	}
+	if err := c.HMSet("users", map[string]interface{}{"2": "bob", "3": "carol", "x": "y"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.HGet("users", "2").Result(); err != nil || v != "bob" {
+		t.Fatalf("hget: %q %v", v, err)
+	}
+	if _, err := c.HGet("users", "9").Result(); err != redis.Nil {
+		t.Fatalf("hget missing: %v", err)
+	}
+	if n, _ := c.HLen("users").Result(); n != 4 {
+		t.Fatalf("hlen: %d", n)
+	}
+	if ok, _ := c.HExists("users", "x").Result(); !ok {
+		t.Fatal("hexists")
+	}
+	if n, _ := c.HDel("users", "x", "9").Result(); n != 1 {
+		t.Fatalf("hdel: %d", n)
+	}
+	if m, _ := c.HGetAll("users").Result(); len(m) != 3 || m["3"] != "carol" {
+		t.Fatalf("hgetall: %v", m)
+	}
+	if vals, _ := c.HMGet("users", "1", "9").Result(); len(vals) != 2 || vals[0] != "alice" || vals[1] != nil {
+		t.Fatalf("hmget: %v", vals)
+	}
+	for i := 0; i < 30; i++ {
+		c.HSet("big", strings.Repeat("k", i%3+1)+string(rune('a'+i%26)), i)
+	}
+	var fields []string
+	var cursor uint64
+	for pages := 0; ; pages++ {
+		kvs, next, err := c.HScan("big", cursor, "kk*", 4).Result()
+		if err != nil || pages > 10 {
+			t.Fatalf("hscan: %v", err)
+		}
+		for i := 0; i < len(kvs); i += 2 {
+			fields = append(fields, kvs[i])
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if len(fields) != 20 {
+		t.Fatalf("hscan matched %v", fields)
+	}
+
+	// sorted sets
+	if n, err := c.ZAdd("deadlines", redis.Z{Score: 3, Member: "c"}, redis.Z{Score: 1, Member: "a"}, redis.Z{Score: 2, Member: "b"}).Result(); err != nil || n != 3 {
+		t.Fatalf("zadd: %d %v", n, err)
+	}
+	if v, err := c.ZScore("deadlines", "b").Result(); err != nil || v != 2 {
+		t.Fatalf("zscore: %v %v", v, err)
+	}
+	if m, err := c.ZRangeByScore("deadlines", redis.ZRangeBy{Min: "-inf", Max: "(3"}).Result(); err != nil || strings.Join(m, ",") != "a,b" {
+		t.Fatalf("zrangebyscore: %v %v", m, err)
+	}
+	if n, _ := c.ZRem("deadlines", "a", "x").Result(); n != 1 {
+		t.Fatalf("zrem: %d", n)
+	}
+	if n, _ := c.ZCard("deadlines").Result(); n != 2 {
+		t.Fatalf("zcard: %d", n)
+	}
+
+	// strings of the default bucket
+	c.Set("greeting", "hello", 0)
+	if v, _ := c.Get("greeting").Result(); v != "hello" {
+		t.Fatalf("get: %q", v)
+	}
+	if keys, _, err := c.Scan(0, "*", 10).Result(); err != nil || len(keys) != 1 {
+		t.Fatalf("scan: %v %v", keys, err)
+	}
+	if n, _ := c.Del("greeting", "missing").Result(); n != 1 {
+		t.Fatalf("del: %d", n)
+	}
+
+	// transactions
+	other := redis.NewClient(&redis.Options{Addr: addr})
+	defer other.Close()
+	err := c.Watch(func(tx *redis.Tx) error {
+		other.HSet("users", "1", "changed")
+		_, err := tx.Pipelined(func(p redis.Pipeliner) error {
+			p.HSet("users", "1", "lost")
+			return nil
+		})
+		return err
+	}, "users")
+	if err != redis.TxFailedErr {
+		t.Fatalf("watch: %v", err)
+	}
+	if v, _ := c.HGet("users", "1").Result(); v != "changed" {
+		t.Fatalf("write of failed transaction: %q", v)
+	}
+	cmds, err := c.TxPipelined(func(p redis.Pipeliner) error {
+		p.HSet("users", "4", "dave")
+		p.HGet("users", "4")
+		return nil
+	})
+	if err != nil || cmds[1].(*redis.StringCmd).Val() != "dave" {
+		t.Fatalf("multi: %v", err)
+	}
+
+	// pub/sub
+	ps := other.Subscribe("news")
+	if _, err := ps.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Publish("news", "hi").Result(); n != 1 {
+		t.Fatalf("published to %d", n)
+	}
+	if m, err := ps.ReceiveMessage(); err != nil || m.Payload != "hi" {
+		t.Fatalf("message: %v %v", m, err)
+	}
+	ps.Close()
+
+	// inline commands and protocol errors
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("PING\r\nMULTI\r\nNOSUCH\r\nEXEC\r\n*1\r\nPING\r\n"))
+	for _, want := range []string{"+PONG", "+OK", "-ERR unknown command 'NOSUCH'", "-EXECABORT", "-ERR Protocol error"} {
+		line, _ := r.ReadString('\n')
+		if !strings.HasPrefix(line, want) {
+			t.Fatalf("%q, want %q", line, want)
+		}
+	}
+}
+
+func TestRESPServer_Auth(t *testing.T) {
+	_, addr := respServer(t, "mem://resp/auth", "secret")
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	defer c.Close()
+	if err := c.HSet("h", "f", "v").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Fatalf("no auth: %v", err)
+	}
+	c = redis.NewClient(&redis.Options{Addr: addr, Password: "secret"})
+	defer c.Close()
+	if err := c.HSet("h", "f", "v").Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRESPServer_RedisDB - RedisDB on a bolt file
+func TestRESPServer_RedisDB(t *testing.T) {
+	_, addr := respServer(t, "bolt://resp.db/redisdb?path="+t.TempDir(), "")
+	open := func(hashkey string) KVMethods {
+		db, err := NewKVDataBase("redis://" + addr + "/" + hashkey + "?count=10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { CloseKVDataBase(db.Name()) })
+		return db
+	}
+	testKVStore(t, KVStoreOf(open("resp-store")))
+	testKVScanner(t, open("resp-scan"))
+	testKVBatch(t, open("resp-batch"))
+	testKVTransaction(t, open("resp-txn"))
+	testKVExpire(t, open("resp-expire"))
+	testKVWatcher(t, open("resp-watch"))
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"u?er", "user", true},
+		{"k[a-c]", "kb", true},
+		{"k[^a-c]", "kb", false},
+		{"k[xy]z", "kyz", true},
+		{`user:\*`, "user:*", true},
+		{`user:\*`, "user:1", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"[abc", "a", false},
+	} {
+		if globMatch(c.pattern, c.s) != c.match {
+			t.Errorf("%q %q: %v", c.pattern, c.s, !c.match)
+		}
+	}
+}
+
+func TestRESPServer_ScanCursor(t *testing.T) {
+	_, addr := respServer(t, "mem://resp/cursor", "")
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	defer c.Close()
+	for i := 0; i < 25; i++ {
+		c.Set(fmt.Sprintf("key%02d", i), "v", 0)
+	}
+	var keys []string
+	var cursor uint64
+	pages := 0
+	for ; ; pages++ {
+		page, next, err := c.Scan(cursor, "", 10).Result()
+		if err != nil || len(page) > 10 {
+			t.Fatalf("scan: %v %v", page, err)
+		}
+		keys = append(keys, page...)
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	if pages != 2 || len(keys) != 25 || keys[0] != "key00" || keys[24] != "key24" {
+		t.Fatalf("%d pages of %v", pages, keys)
+	}
+	// a cursor is valid again, not with another hash key
+	_, next, _ := c.Scan(0, "", 10).Result()
+	if a, _, _ := c.Scan(next, "", 5).Result(); len(a) != 5 || a[0] != "key10" {
+		t.Fatalf("resumed %v", a)
+	}
+	if _, _, err := c.HScan("other", next, "", 5).Result(); err == nil {
+		t.Fatal("cursor of another hash key")
+	}
+	if _, _, err := c.Scan(12345, "", 5).Result(); err == nil {
+		t.Fatal("unknown cursor")
+	}
+}
+
+// slowExists - mem bucket taking its time to reply if a key existed
+type slowExists struct {
+	*MemBucket
+}
+
+func (s *slowExists) ExistsContext(ctx context.Context, key string) (bool, error) {
+	ok, err := s.MemBucket.ExistsContext(ctx, key)
+	time.Sleep(time.Millisecond)
+	return ok, err
+}
+
+func TestRESPServer_HSetRace(t *testing.T) {
+	mem, err := NewMemDB("mem://resp/hsetrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRESPServer(&slowExists{mem.(*MemBucket)})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+	c := redis.NewClient(&redis.Options{Addr: l.Addr().String(), PoolSize: 8})
+	defer c.Close()
+	for f := 0; f < 20; f++ {
+		var added int64
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				n, _ := c.HSet("race", fmt.Sprint(f), "v").Result()
+				if n {
+					atomic.AddInt64(&added, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if added != 1 {
+			t.Fatalf("field %d added %d times", f, added)
+		}
+	}
+}
+
+func TestRESPServer_SlowSubscriber(t *testing.T) {
+	_, addr := respServer(t, "mem://resp/slowsub", "")
+	// a subscriber never reading its messages
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("SUBSCRIBE news\r\n"))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); line != "*3\r\n" {
+		t.Fatalf("subscribe: %q", line)
+	}
+	c := redis.NewClient(&redis.Options{Addr: addr})
+	defer c.Close()
+	msg := strings.Repeat("x", 16<<10)
+	for i := 0; ; i++ {
+		n, err := c.Publish("news", msg).Result()
+		if err != nil {
+			t.Fatalf("publish %d: %v", i, err)
+		}
+		// the subscriber is dropped once it fell behind
+		if n == 0 {
+			break
+		}
+		if i > 100000 {
+			t.Fatal("slow subscriber kept")
+		}
+	}
+}
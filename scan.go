@@ -0,0 +1,171 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// scanBatch - page size used by page wrappers when Count is 0
+const scanBatch = 100
+
+// KVScanner - interface of cursor based pagination and key queries
+// a cursor is opaque, "" starts from the beginning and a returned
+// nextCursor of "" means there are no more kvs
+type KVScanner interface {
+	// Scan - list at most limit kvs after cursor in a stable order
+	Scan(cursor string, limit int) (items []KVData, nextCursor string, err error)
+	// ScanPrefix - all kvs whose key starts with prefix in key order
+	ScanPrefix(prefix string) ([]KVData, error)
+	// ScanRange - all kvs with start <= key < end in key order
+	// end "" means no upper bound
+	ScanRange(start, end string) ([]KVData, error)
+}
+
+// KVRangeScanner - interface of scanners reading part of a range, so
+// a range listed page by page isn't read whole for every page
+type KVRangeScanner interface {
+	// ScanRangeLimit - at most limit kvs with start <= key < end in
+	// key order, end "" means no upper bound
+	ScanRangeLimit(start, end string, limit int) ([]KVData, error)
+}
+
+// scanRangeLimit - ScanRangeLimit of s, or ScanRange cut to limit
+func scanRangeLimit(s KVScanner, start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	if r, ok := s.(KVRangeScanner); ok {
+		return r.ScanRangeLimit(start, end, limit)
+	}
+	items, err := s.ScanRange(start, end)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, err
+}
+
+// inRange - if key is before end, end "" means no upper bound
+func inRange(key, end string) bool {
+	return end == "" || key < end
+}
+
+// prefixEnd - first key after every key starting with prefix, "" when
+// there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// wrongLimit - error of a limit out of range
+func wrongLimit() error {
+	return errors.New("wrong limit parameter")
+}
+
+// keyCursor - cursor after key for backends scanning in key order
+func keyCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// cursorKey - key of a cursor made by keyCursor
+// ok is false for the empty cursor starting from the first key
+func cursorKey(cursor string) (key string, ok bool, err error) {
+	if cursor == "" {
+		return "", false, nil
+	}
+	k, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", false, errors.New("wrong cursor parameter")
+	}
+	return string(k), true, nil
+}
+
+// scanPages - call fn on every kv of s in scan order until it returned false
+func scanPages(s KVScanner, batch int, fn func(kv *KVData) bool) error {
+	cursor := ""
+	for {
+		items, next, err := s.Scan(cursor, batch)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			if !fn(&items[i]) {
+				return nil
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// pageBatch - scan batch for page size count
+func pageBatch(count uint) int {
+	if count == 0 {
+		return scanBatch
+	}
+	return int(count)
+}
+
+// scanListKeys - keys on page of a scanner, count keys in one page
+// count 0 puts every key on page 0
+func scanListKeys(s KVScanner, page, count uint) []string {
+	var list []string
+	if count == 0 && page > 0 {
+		return list
+	}
+	index := uint(0)
+	scanPages(s, pageBatch(count), func(kv *KVData) bool {
+		if index >= page*count {
+			list = append(list, kv.Key)
+		}
+		index++
+		return count == 0 || index < (page+1)*count
+	})
+	return list
+}
+
+// scanList - data handler returned on page of a scanner
+// only kvs matched by handler are counted in pages
+func scanList(s KVScanner, page, count uint, handler func(k, v []byte) *KVResult) *KVResult {
+	data := make([]interface{}, 0)
+	if count == 0 && page > 0 {
+		return &KVResult{
+			Data:   data,
+			Info:   "",
+			Result: true,
+		}
+	}
+	index := uint(0)
+	err := scanPages(s, pageBatch(count), func(kv *KVData) bool {
+		if count != 0 && index >= (page+1)*count {
+			return false
+		}
+		i := handler([]byte(kv.Key), kv.Value)
+		if !i.Result {
+			return true
+		}
+		if index >= page*count {
+			data = append(data, i.Data)
+		}
+		index++
+		return true
+	})
+	if err != nil {
+		return &KVResult{
+			Info:   err.Error(),
+			Result: false,
+		}
+	}
+	return &KVResult{
+		Data:   data,
+		Info:   "",
+		Result: true,
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func testKVScanner(t *testing.T, db KVMethods) {
+	s, ok := db.(KVScanner)
+	if !ok {
+		t.Fatalf("%s doesn't support scan", db.Name())
+	}
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		want = append(want, key)
+		db.Set(&KVData{key, []byte(key)})
+	}
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		items, next, err := s.Scan(cursor, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) > 10 {
+			t.Fatalf("page of %d items", len(items))
+		}
+		for _, kv := range items {
+			if string(kv.Value) != kv.Key {
+				t.Fatalf("value of %s: %s", kv.Key, kv.Value)
+			}
+			got = append(got, kv.Key)
+		}
+		if pages == 0 {
+			// writes behind the cursor don't move later pages
+			db.Set(&KVData{"key00", []byte("key00")})
+			db.Delete(items[0].Key)
+			db.Set(&KVData{items[0].Key, []byte(items[0].Key)})
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if !sort.StringsAreSorted(got) || fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("scanned %v", got)
+	}
+	if keys := db.ListKeys(1); fmt.Sprint(keys) != fmt.Sprint(want[10:20]) {
+		t.Fatalf("page 1: %v", keys)
+	}
+	if _, _, err := s.Scan("", 0); err == nil {
+		t.Fatal("scan with limit 0")
+	}
+}
+
+func testKVQuery(t *testing.T, db KVMethods) {
+	s := db.(KVScanner)
+	for _, key := range []string{"user:1:name", "user:12:name", "user:12:profile", "user:2:name", "users", "group:1"} {
+		db.Set(&KVData{key, []byte(key)})
+	}
+	keys := func(items []KVData, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list []string
+		for _, kv := range items {
+			list = append(list, kv.Key)
+		}
+		return fmt.Sprint(list)
+	}
+	if got := keys(s.ScanPrefix("user:12:")); got != "[user:12:name user:12:profile]" {
+		t.Fatalf("prefix user:12: %s", got)
+	}
+	if got := keys(s.ScanPrefix("nouser")); got != "[]" {
+		t.Fatalf("prefix nouser: %s", got)
+	}
+	if got := keys(s.ScanRange("user:1", "user:2")); got != "[user:12:name user:12:profile user:1:name]" {
+		t.Fatalf("range user:1 user:2: %s", got)
+	}
+	if got := keys(s.ScanRange("user:2", "")); got != "[user:2:name users]" {
+		t.Fatalf("range from user:2: %s", got)
+	}
+	r := db.(KVRangeScanner)
+	if got := keys(r.ScanRangeLimit("user:1", "user:2", 2)); got != "[user:12:name user:12:profile]" {
+		t.Fatalf("range user:1 user:2 limit 2: %s", got)
+	}
+	if got := keys(r.ScanRangeLimit("user:2", "", 5)); got != "[user:2:name users]" {
+		t.Fatalf("range from user:2 limit 5: %s", got)
+	}
+	if _, err := r.ScanRangeLimit("", "", 0); err == nil {
+		t.Fatal("range with limit 0")
+	}
+}
+
+func TestKVScanner_Mem(t *testing.T) {
+	db, err := OpenOrGet("mem://scan/scantest?count=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVScanner(t, db)
+	testKVQuery(t, db)
+}
+
+func TestKVScanner_Bolt(t *testing.T) {
+	db, err := OpenOrGet("bolt://scan.db/scantest?count=10&path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVScanner(t, db)
+	testKVQuery(t, db)
+}
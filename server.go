@@ -0,0 +1,504 @@
+package db
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handler - http handler serving registered databases as REST resources
+//
+//	GET  /db                            databases and their key counts
+//	GET  /db/{name}                     key count of one database
+//	GET  /db/{name}/keys                list keys, streamed as json
+//	     ?prefix=p&limit=n&cursor=c&values=1
+//	     ?start=s&end=e&limit=n&cursor=c&values=1
+//	                                    start <= key < end, a cursor is
+//	                                    only valid with the prefix or
+//	                                    range it was returned for
+//	GET|HEAD|PUT|DELETE /db/{name}/keys/{key}
+//	POST /db/{name}/batch               run many gets, sets and deletes
+//	                                    in one request, see BatchOp
+//
+// values are sent as they are, with an ETag of their content, PUT and
+// DELETE are conditional on If-Match and If-None-Match
+// names and keys are path escaped
+type Handler struct {
+	// ReadOnly - refuse PUT and DELETE
+	ReadOnly bool
+	// MaxValueSize - largest value of a PUT, 32 MB by default
+	MaxValueSize int64
+	// serialize conditional writes of dbs without transactions
+	stripes [shardStripes]sync.Mutex
+}
+
+// NewHandler - handler serving every registered database
+func NewHandler() *Handler {
+	return &Handler{MaxValueSize: 32 << 20}
+}
+
+// errPrecondition - If-Match or If-None-Match of a write didn't match
+var errPrecondition = errors.New("precondition failed")
+
+// ETag - entity tag of value
+func ETag(value []byte) string {
+	sum := sha256.Sum256(value)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatch - if header lists tag or is *, exists is false for a
+// missing key, which matches nothing
+func etagMatch(header, tag string, exists bool) bool {
+	if !exists {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditions - if conditions of a write request hold for the
+// current value of key
+func preconditions(r *http.Request, value []byte, exists bool) bool {
+	tag := ""
+	if exists {
+		tag = ETag(value)
+	}
+	if m := r.Header.Get("If-Match"); m != "" && !etagMatch(m, tag, exists) {
+		return false
+	}
+	if m := r.Header.Get("If-None-Match"); m != "" && etagMatch(m, tag, exists) {
+		return false
+	}
+	return true
+}
+
+// replyError - json KVResult of err with status
+func replyError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&KVResult{
+		Result: false,
+		Info:   err.Error(),
+	})
+}
+
+// ServeHTTP - serve a request
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/", 4)
+	if len(parts) == 0 || parts[0] != "db" {
+		replyError(w, http.StatusNotFound, errors.New("no such resource"))
+		return
+	}
+	if len(parts) == 1 || (len(parts) == 2 && parts[1] == "") {
+		h.databases(w, r)
+		return
+	}
+	if len(parts) > 2 && parts[2] != "" && parts[2] != "keys" && parts[2] != "batch" {
+		replyError(w, http.StatusNotFound, errors.New("no such resource"))
+		return
+	}
+	name, err := url.PathUnescape(parts[1])
+	if err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	db := GetKVDataBase(name)
+	if db == nil {
+		replyError(w, http.StatusNotFound, errors.New(name+" didn't exist"))
+		return
+	}
+	if len(parts) == 2 || parts[2] == "" {
+		h.database(w, r, db)
+		return
+	}
+	if parts[2] == "batch" {
+		h.batch(w, r, db)
+		return
+	}
+	if len(parts) == 3 || parts[3] == "" {
+		h.list(w, r, db)
+		return
+	}
+	key, err := url.PathUnescape(parts[3])
+	if err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, db, key)
+	case http.MethodPut, http.MethodDelete:
+		if h.ReadOnly {
+			replyError(w, http.StatusMethodNotAllowed, ErrReadOnly)
+			return
+		}
+		h.write(w, r, db, key)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		replyError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" not allowed"))
+	}
+}
+
+// dbInfo - database listed by GET /db
+type dbInfo struct {
+	Name   string
+	Scheme string
+	Keys   int
+}
+
+// infoOf - dbInfo of db
+func infoOf(db KVMethods) dbInfo {
+	info := dbInfo{Name: db.Name(), Keys: db.KeyCount()}
+	if t := db.DBType(); t != nil {
+		info.Scheme = t.Scheme
+	}
+	return info
+}
+
+// databases - list registered databases
+func (h *Handler) databases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		replyError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" not allowed"))
+		return
+	}
+	var dbs []KVMethods
+	kvdbLock.RLock()
+	for _, t := range KVDBs {
+		for _, db := range t.DataBases {
+			dbs = append(dbs, db)
+		}
+	}
+	kvdbLock.RUnlock()
+	infos := make([]dbInfo, 0, len(dbs))
+	for _, db := range dbs {
+		infos = append(infos, infoOf(db))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&KVResult{
+		Data:   infos,
+		Result: true,
+	})
+}
+
+// database - info of db
+func (h *Handler) database(w http.ResponseWriter, r *http.Request, db KVMethods) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		replyError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" not allowed"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&KVResult{
+		Data:   infoOf(db),
+		Result: true,
+	})
+}
+
+// get - value of key
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, db KVMethods, key string) {
+	v, err := KVStoreOf(db).GetContext(r.Context(), key)
+	if err == ErrNotFound {
+		replyError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		replyError(w, http.StatusInternalServerError, err)
+		return
+	}
+	tag := ETag(v)
+	w.Header().Set("ETag", tag)
+	if m := r.Header.Get("If-None-Match"); m != "" && etagMatch(m, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(v)))
+	if r.Method == http.MethodGet {
+		w.Write(v)
+	}
+}
+
+// write - PUT or DELETE key
+func (h *Handler) write(w http.ResponseWriter, r *http.Request, db KVMethods, key string) {
+	var value []byte
+	if r.Method == http.MethodPut {
+		var err error
+		value, err = io.ReadAll(http.MaxBytesReader(w, r.Body, h.MaxValueSize))
+		if err != nil {
+			replyError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+	}
+	created := false
+	apply := func(old []byte, exists bool, set func([]byte) error, del func() error) error {
+		if !preconditions(r, old, exists) {
+			return errPrecondition
+		}
+		if r.Method == http.MethodDelete {
+			if !exists {
+				return ErrNotFound
+			}
+			return del()
+		}
+		created = !exists
+		return set(value)
+	}
+	var err error
+	if tr, ok := db.(KVTransaction); ok {
+		err = tr.Update(func(tx KVTxn) error {
+			old, err := tx.Get(key)
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+			return apply(old, err == nil, func(v []byte) error {
+				return tx.Set(key, v)
+			}, func() error {
+				return tx.Delete(key)
+			})
+		})
+	} else {
+		l := &h.stripes[ringHash(key)%shardStripes]
+		l.Lock()
+		s := KVStoreOf(db)
+		ctx := r.Context()
+		old, gerr := s.GetContext(ctx, key)
+		if gerr != nil && gerr != ErrNotFound {
+			err = gerr
+		} else {
+			err = apply(old, gerr == nil, func(v []byte) error {
+				return s.SetContext(ctx, key, v)
+			}, func() error {
+				return s.DeleteContext(ctx, key)
+			})
+		}
+		l.Unlock()
+	}
+	switch {
+	case err == errPrecondition:
+		replyError(w, http.StatusPreconditionFailed, err)
+	case err == ErrNotFound:
+		replyError(w, http.StatusNotFound, err)
+	case err == ErrReadOnly:
+		replyError(w, http.StatusMethodNotAllowed, err)
+	case err != nil:
+		replyError(w, http.StatusInternalServerError, err)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("ETag", ETag(value))
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// list - stream kvs of db as json
+// {"Data":[{"Key":..,"Value":..}],"Next":cursor,"Result":true,"Info":""}
+// Value is null unless values=1, Next is "" after the last kv, and an
+// error while streaming ends the object with Result false
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, db KVMethods) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		replyError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" not allowed"))
+		return
+	}
+	s, ok := db.(KVScanner)
+	if !ok {
+		replyError(w, http.StatusNotImplemented, errors.New(db.Name()+" doesn't support scan"))
+		return
+	}
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	start, end := q.Get("start"), q.Get("end")
+	cursor := q.Get("cursor")
+	values := q.Get("values") == "1" || q.Get("values") == "true"
+	limit := 0
+	if q.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(q.Get("limit")); err != nil || limit <= 0 {
+			replyError(w, http.StatusBadRequest, errors.New("wrong limit parameter"))
+			return
+		}
+	}
+	// first page before answering, so a wrong cursor is a bad request
+	batch := scanBatch
+	if limit > 0 && limit < batch {
+		batch = limit
+	}
+	if prefix != "" {
+		if start != "" || end != "" {
+			replyError(w, http.StatusBadRequest, errors.New("prefix with start or end parameter"))
+			return
+		}
+		start, end = prefix, prefixEnd(prefix)
+	}
+	scan := s.Scan
+	if start != "" || end != "" {
+		scan = func(cursor string, limit int) ([]KVData, string, error) {
+			return rangePage(s, start, end, cursor, limit)
+		}
+	}
+	items, next, err := scan(cursor, batch)
+	var unsup *unsupportedError
+	if errors.As(err, &unsup) {
+		replyError(w, http.StatusNotImplemented, err)
+		return
+	}
+	if err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	bw := bufio.NewWriter(w)
+	bw.WriteString(`{"Data":[`)
+	enc := json.NewEncoder(bw)
+	n := 0
+	for {
+		for i := range items {
+			if !values {
+				items[i].Value = nil
+			}
+			if n > 0 {
+				bw.WriteByte(',')
+			}
+			enc.Encode(&items[i])
+			n++
+		}
+		if next == "" || (limit > 0 && n >= limit) || r.Context().Err() != nil {
+			break
+		}
+		if flusher != nil {
+			bw.Flush()
+			flusher.Flush()
+		}
+		batch = scanBatch
+		if limit > 0 && limit-n < batch {
+			batch = limit - n
+		}
+		var page []KVData
+		var after string
+		if page, after, err = scan(next, batch); err != nil {
+			break
+		}
+		items, next = page, after
+	}
+	bw.WriteString(`],"Next":`)
+	enc.Encode(next)
+	if err != nil {
+		bw.WriteString(`,"Result":false,"Info":`)
+		enc.Encode(err.Error())
+		bw.WriteString("}\n")
+	} else {
+		bw.WriteString(`,"Result":true,"Info":""}` + "\n")
+	}
+	bw.Flush()
+}
+
+// rangePage - at most limit kvs with start <= key < end after cursor in
+// key order, next is the cursor after the last one when more are left
+// kvs are read by ScanRangeLimit from the cursor on, so neither keys
+// out of range nor those of later pages are read
+func rangePage(s KVScanner, start, end, cursor string, limit int) ([]KVData, string, error) {
+	after, resume, err := cursorKey(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if resume {
+		if after < start || !inRange(after, end) {
+			return nil, "", errors.New("wrong cursor parameter")
+		}
+		start = after + "\x00"
+	}
+	// one more tells if any is left
+	items, err := scanRangeLimit(s, start, end, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(items) > limit {
+		return items[:limit], keyCursor(items[limit-1].Key), nil
+	}
+	return items, "", nil
+}
+
+// BatchOp - operation of a batch request, Op is get, set or delete
+type BatchOp struct {
+	Op    string
+	Key   string
+	Value []byte `json:",omitempty"`
+}
+
+// BatchResult - result of a BatchOp, Value of a get
+type BatchResult struct {
+	Result bool
+	Info   string `json:",omitempty"`
+	Value  []byte `json:",omitempty"`
+}
+
+// batch - run ops of the request body in order, reply their results
+func (h *Handler) batch(w http.ResponseWriter, r *http.Request, db KVMethods) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		replyError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" not allowed"))
+		return
+	}
+	var ops []BatchOp
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.MaxValueSize)).Decode(&ops); err != nil {
+		replyError(w, http.StatusBadRequest, err)
+		return
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case "get":
+		case "set", "delete":
+			if h.ReadOnly {
+				replyError(w, http.StatusMethodNotAllowed, ErrReadOnly)
+				return
+			}
+		default:
+			replyError(w, http.StatusBadRequest, errors.New("unknown op "+op.Op))
+			return
+		}
+	}
+	s := KVStoreOf(db)
+	ctx := r.Context()
+	res := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op.Op {
+		case "get":
+			res[i].Value, err = s.GetContext(ctx, op.Key)
+		case "set":
+			err = s.SetContext(ctx, op.Key, op.Value)
+		case "delete":
+			err = s.DeleteContext(ctx, op.Key)
+		}
+		res[i].Result = err == nil
+		if err != nil {
+			res[i].Info = err.Error()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&KVResult{
+		Data:   res,
+		Result: true,
+	})
+}
@@ -0,0 +1,255 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// request - send request to srv, return response and body
+func request(t *testing.T, srv *httptest.Server, method, path, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res, string(b)
+}
+
+// listing - body of a key listing
+type listing struct {
+	Data   []KVData
+	Next   string
+	Result bool
+	Info   string
+}
+
+func TestHandler(t *testing.T) {
+	db, err := NewKVDataBase("bolt://http.db/httptest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	srv := httptest.NewServer(NewHandler())
+	defer srv.Close()
+	keys := "/db/" + url.PathEscape(db.Name()) + "/keys/"
+
+	res, _ := request(t, srv, "PUT", keys+"user%2F1", "alice")
+	if res.StatusCode != http.StatusCreated {
+		t.Fatal(res.Status)
+	}
+	tag := res.Header.Get("ETag")
+	checkValue(t, db, "user/1", "alice")
+	res, body := request(t, srv, "GET", keys+"user%2F1", "")
+	if res.StatusCode != http.StatusOK || body != "alice" || res.Header.Get("ETag") != tag {
+		t.Fatalf("get: %s %q", res.Status, body)
+	}
+	if res, body = request(t, srv, "HEAD", keys+"user%2F1", ""); res.StatusCode != http.StatusOK || body != "" || res.ContentLength != 5 {
+		t.Fatalf("head: %s %q", res.Status, body)
+	}
+	if res, _ = request(t, srv, "GET", keys+"user%2F1", "", "If-None-Match", tag); res.StatusCode != http.StatusNotModified {
+		t.Fatalf("get not modified: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "GET", keys+"missing", ""); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("get missing: %s", res.Status)
+	}
+
+	// conditional writes
+	if res, _ = request(t, srv, "PUT", keys+"user%2F1", "bob", "If-Match", `"stale"`); res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("put stale: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "PUT", keys+"user%2F1", "bob", "If-None-Match", "*"); res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("create existing: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "PUT", keys+"user%2F1", "bob", "If-Match", tag); res.StatusCode != http.StatusNoContent || res.Header.Get("ETag") != ETag([]byte("bob")) {
+		t.Fatalf("put: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "DELETE", keys+"user%2F1", "", "If-Match", tag); res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("delete stale: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "DELETE", keys+"user%2F1", ""); res.StatusCode != http.StatusNoContent || db.Exists("user/1") {
+		t.Fatalf("delete: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "DELETE", keys+"user%2F1", ""); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("delete missing: %s", res.Status)
+	}
+
+	// listing in pages
+	for i := 0; i < 250; i++ {
+		db.Set(&KVData{fmt.Sprintf("k%03d", i), []byte("v")})
+	}
+	db.Set(&KVData{"other", []byte("x")})
+	var all []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		_, body = request(t, srv, "GET", keys+"?prefix=k&limit=100&cursor="+url.QueryEscape(cursor), "")
+		var l listing
+		if err := json.Unmarshal([]byte(body), &l); err != nil || !l.Result || len(l.Data) > 100 {
+			t.Fatalf("list: %v %s", err, body)
+		}
+		for _, kv := range l.Data {
+			all = append(all, kv.Key)
+		}
+		if cursor = l.Next; cursor == "" || pages > 3 {
+			break
+		}
+	}
+	if len(all) != 250 || all[0] != "k000" || all[249] != "k249" {
+		t.Fatalf("listed %d keys", len(all))
+	}
+	_, body = request(t, srv, "GET", keys+"?values=1", "")
+	var l listing
+	if err := json.Unmarshal([]byte(body), &l); err != nil || len(l.Data) != 251 || string(l.Data[250].Value) != "x" {
+		t.Fatalf("stream: %v %d", err, len(l.Data))
+	}
+
+	_, body = request(t, srv, "GET", "/db", "")
+	if !strings.Contains(body, `"Name":"`+db.Name()+`","Scheme":"bolt","Keys":251`) {
+		t.Fatalf("databases: %s", body)
+	}
+	if res, _ = request(t, srv, "GET", "/db/nodb/keys/k", ""); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing db: %s", res.Status)
+	}
+	if res, _ = request(t, srv, "GET", keys+"?cursor=%25%25", ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("wrong cursor: %s", res.Status)
+	}
+}
+
+func TestHandler_ReadOnly(t *testing.T) {
+	db, err := NewKVDataBase("mem://http/readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler()
+	h.ReadOnly = true
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	res, _ := request(t, srv, "PUT", "/db/"+db.Name()+"/keys/key", "value")
+	if res.StatusCode != http.StatusMethodNotAllowed || db.Exists("key") {
+		t.Fatalf("put: %s", res.Status)
+	}
+	res, _ = request(t, srv, "POST", "/db/"+db.Name()+"/batch", `[{"Op":"get","Key":"key"},{"Op":"set","Key":"key"}]`)
+	if res.StatusCode != http.StatusMethodNotAllowed || db.Exists("key") {
+		t.Fatalf("batch: %s", res.Status)
+	}
+	res, body := request(t, srv, "POST", "/db/"+db.Name()+"/batch", `[{"Op":"get","Key":"key"}]`)
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, `"Info":"`+ErrNotFound.Error()+`"`) {
+		t.Fatalf("batch get: %s %s", res.Status, body)
+	}
+}
+
+// prefixOnly - mem bucket failing full scans and unbounded ranges,
+// counting the kvs read
+type prefixOnly struct {
+	*MemBucket
+	read int
+}
+
+func (p *prefixOnly) Scan(cursor string, limit int) ([]KVData, string, error) {
+	return nil, "", fmt.Errorf("full scan")
+}
+
+func (p *prefixOnly) ScanPrefix(prefix string) ([]KVData, error) {
+	return nil, fmt.Errorf("unbounded scan")
+}
+
+func (p *prefixOnly) ScanRange(start, end string) ([]KVData, error) {
+	return nil, fmt.Errorf("unbounded scan")
+}
+
+func (p *prefixOnly) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	items, err := p.MemBucket.ScanRangeLimit(start, end, limit)
+	p.read += len(items)
+	return items, err
+}
+
+func TestHandler_Prefix(t *testing.T) {
+	db, err := NewKVDataBase("mem://http/prefixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		db.Set(&KVData{fmt.Sprintf("a%03d", i), []byte("v")})
+	}
+	for i := 0; i < 5; i++ {
+		db.Set(&KVData{fmt.Sprintf("p%d", i), []byte("v")})
+	}
+	p := &prefixOnly{MemBucket: db.(*MemBucket)}
+	db = Chain(db, func(KVMethods) KVMethods { return p })
+	defer CloseKVDataBase(db.Name())
+	srv := httptest.NewServer(NewHandler())
+	defer srv.Close()
+	keys := "/db/" + db.Name() + "/keys"
+
+	// listed without a full scan, in pages of the cursor
+	var all []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		_, body := request(t, srv, "GET", keys+"?prefix=p&limit=2&cursor="+url.QueryEscape(cursor), "")
+		var l listing
+		if err := json.Unmarshal([]byte(body), &l); err != nil || !l.Result || len(l.Data) > 2 {
+			t.Fatalf("list: %v %s", err, body)
+		}
+		for _, kv := range l.Data {
+			all = append(all, kv.Key)
+		}
+		if cursor = l.Next; cursor == "" || pages > 3 {
+			break
+		}
+	}
+	if fmt.Sprint(all) != "[p0 p1 p2 p3 p4]" {
+		t.Fatalf("listed %v", all)
+	}
+	if res, _ := request(t, srv, "GET", keys+"?prefix=p&cursor="+keyCursor("a001"), ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("cursor of another prefix: %s", res.Status)
+	}
+
+	// a page reads its own kvs, not the rest of the prefix
+	p.read = 0
+	_, body := request(t, srv, "GET", keys+"?prefix=a&limit=10&cursor="+keyCursor("a100"), "")
+	var l listing
+	if err := json.Unmarshal([]byte(body), &l); err != nil || len(l.Data) != 10 || l.Data[0].Key != "a101" || l.Next != keyCursor("a110") {
+		t.Fatalf("page: %v %s", err, body)
+	}
+	if p.read > 11 {
+		t.Fatalf("read %d kvs for a page of 10", p.read)
+	}
+	// and a prefix is listed whole in batches without a limit
+	_, body = request(t, srv, "GET", keys+"?prefix=a", "")
+	var whole listing
+	if err := json.Unmarshal([]byte(body), &whole); err != nil || len(whole.Data) != 300 || whole.Next != "" {
+		t.Fatalf("whole prefix: %v %d %s", err, len(whole.Data), whole.Next)
+	}
+
+	// a range, with the cursor of the range
+	_, body = request(t, srv, "GET", keys+"?start=a298&end=p1&limit=2", "")
+	var r listing
+	if err := json.Unmarshal([]byte(body), &r); err != nil || len(r.Data) != 2 || r.Data[1].Key != "a299" {
+		t.Fatalf("range: %v %s", err, body)
+	}
+	_, body = request(t, srv, "GET", keys+"?start=a298&end=p1&cursor="+url.QueryEscape(r.Next), "")
+	var rest listing
+	if err := json.Unmarshal([]byte(body), &rest); err != nil || len(rest.Data) != 1 || rest.Data[0].Key != "p0" || rest.Next != "" {
+		t.Fatalf("rest of range: %v %s", err, body)
+	}
+	if res, _ := request(t, srv, "GET", keys+"?start=a298&end=p1&cursor="+keyCursor("p3"), ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("cursor out of range: %s", res.Status)
+	}
+	if res, _ := request(t, srv, "GET", keys+"?prefix=a&end=b", ""); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("prefix with end: %s", res.Status)
+	}
+}
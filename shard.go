@@ -0,0 +1,705 @@
+package db
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes - points of every shard on the hash ring
+const DefaultVirtualNodes = 160
+
+// shardStripes - locks of keys, writes and moves of a key are serialized
+const shardStripes = 64
+
+// hashRing - consistent hash ring of shards
+type hashRing struct {
+	points []uint64
+	owners []KVMethods
+}
+
+// ringHash - hash of s on the ring, fnv-1a mixed to spread close names
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// newHashRing - ring of vnodes points of every shard, placed by shard name
+func newHashRing(shards []KVMethods, vnodes int) *hashRing {
+	type point struct {
+		hash  uint64
+		owner KVMethods
+	}
+	points := make([]point, 0, len(shards)*vnodes)
+	for _, db := range shards {
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{ringHash(db.Name() + "#" + strconv.Itoa(i)), db})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	r := &hashRing{
+		points: make([]uint64, len(points)),
+		owners: make([]KVMethods, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// owner - shard of key, the first point after the hash of key
+func (r *hashRing) owner(key string) KVMethods {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ShardedDB - database spreading keys over shards by consistent hashing
+// ListKeys, List and Scan merge the shards in key order, FindOne and
+// ScanContext go through them one after another, in order of their names
+type ShardedDB struct {
+	Type  *KVDBType
+	Label string
+	// Count - keys on a page of ListKeys and List, 0 for all on page 0
+	Count uint
+
+	vnodes int
+	// names of shards opened by uri, released on Close or when removed
+	owned  map[string]bool
+	lock   sync.RWMutex
+	shards []KVMethods
+	ring   *hashRing
+	// ring before AddShard or RemoveShard, until its keys are moved
+	prev *hashRing
+	// shards removed before their keys are moved
+	draining []KVMethods
+	stripes  [shardStripes]sync.Mutex
+}
+
+func init() {
+	NewKVDatabaseType("shard", NewShardedDB)
+}
+
+// Shard - database spreading keys over shards, shards are told apart
+// by Name, so their order doesn't matter, but their names must differ
+func Shard(shards ...KVMethods) (*ShardedDB, error) {
+	return newSharded("", DefaultVirtualNodes, shards)
+}
+
+func newSharded(label string, vnodes int, shards []KVMethods) (*ShardedDB, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	names := make(map[string]bool, len(shards))
+	for _, db := range shards {
+		if names[db.Name()] {
+			return nil, errors.New("shards of the same name " + db.Name())
+		}
+		names[db.Name()] = true
+	}
+	s := &ShardedDB{
+		Type:   GetKVDatabaseType("shard"),
+		Label:  label,
+		vnodes: vnodes,
+		shards: sortShards(append([]KVMethods(nil), shards...)),
+	}
+	if s.Label == "" {
+		s.Label = s.shards[0].Name()
+	}
+	s.ring = newHashRing(s.shards, vnodes)
+	return s, nil
+}
+
+// sortShards - shards in order of names
+func sortShards(shards []KVMethods) []KVMethods {
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Name() < shards[j].Name() })
+	return shards
+}
+
+// NewShardedDB - new sharded database using uri format description
+// format : shard://<name>?db=<uri>&db=<uri>[&vnodes=]&[count=]
+// shards are opened by OpenOrGet, and need different names, like
+// redis hashkeys or bolt buckets of their own
+// example shard://users?db=redis%3A%2F%2Fhost1%3A6379%2Fusers1&db=redis%3A%2F%2Fhost2%3A6379%2Fusers2
+func NewShardedDB(uri string) (KVMethods, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	para := u.Query()
+	uris := para["db"]
+	if len(uris) == 0 {
+		return nil, errors.New("no db parameter")
+	}
+	vnodes := DefaultVirtualNodes
+	if para.Get("vnodes") != "" {
+		vnodes, err = strconv.Atoi(para.Get("vnodes"))
+		if err != nil || vnodes <= 0 {
+			return nil, errors.New("wrong vnodes parameter")
+		}
+	}
+	count := 0
+	if para.Get("count") != "" {
+		count, err = strconv.Atoi(para.Get("count"))
+		if err != nil || count <= 0 {
+			return nil, errors.New("wrong count parameter")
+		}
+	}
+	shards := make([]KVMethods, 0, len(uris))
+	for _, m := range uris {
+		db, err := OpenOrGet(m)
+		if err != nil {
+			for _, db := range shards {
+				CloseKVDataBase(db.Name())
+			}
+			return nil, err
+		}
+		shards = append(shards, db)
+	}
+	s, err := newSharded(u.Host, vnodes, shards)
+	if err != nil {
+		for _, db := range shards {
+			CloseKVDataBase(db.Name())
+		}
+		return nil, err
+	}
+	s.Count = uint(count)
+	s.owned = make(map[string]bool, len(shards))
+	for _, db := range shards {
+		s.owned[db.Name()] = true
+	}
+	return s, nil
+}
+
+// Name - tag different databases
+func (s *ShardedDB) Name() string {
+	return "Shard_" + s.Label
+}
+
+// DBType - DataBase Type
+func (s *ShardedDB) DBType() *KVDBType {
+	return s.Type
+}
+
+// Shards - databases keys are spread over, in order of names
+func (s *ShardedDB) Shards() []KVMethods {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]KVMethods(nil), s.shards...)
+}
+
+// stores - shards and shards removed before their keys were moved, in
+// order of names
+func (s *ShardedDB) stores() []KVMethods {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return sortShards(append(append([]KVMethods(nil), s.shards...), s.draining...))
+}
+
+// ShardOf - shard keeping key
+func (s *ShardedDB) ShardOf(key string) KVMethods {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ring.owner(key)
+}
+
+// owners - shard of key and the one before a change of shards, nil
+// when it's the same
+func (s *ShardedDB) owners(key string) (KVMethods, KVMethods) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	owner := s.ring.owner(key)
+	if s.prev != nil {
+		if prev := s.prev.owner(key); prev != owner {
+			return owner, prev
+		}
+	}
+	return owner, nil
+}
+
+// keyLock - lock serializing writes and moves of key
+func (s *ShardedDB) keyLock(key string) *sync.Mutex {
+	return &s.stripes[ringHash(key)%shardStripes]
+}
+
+// Exists - if key existed
+func (s *ShardedDB) Exists(key string) bool {
+	ok, _ := s.ExistsContext(context.Background(), key)
+	return ok
+}
+
+// Get - get value of key
+func (s *ShardedDB) Get(key string) *KVResult {
+	v, err := s.GetContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   v,
+		Result: true,
+	}
+}
+
+// Set - set key value on its shard
+func (s *ShardedDB) Set(kv *KVData) *KVResult {
+	if err := s.SetContext(context.Background(), kv.Key, kv.Value); err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+	}
+}
+
+// Delete - delete key from its shard
+func (s *ShardedDB) Delete(key string) *KVResult {
+	err := s.DeleteContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Result: true,
+	}
+}
+
+// FindOne - find first kv matched by handler, shard by shard
+func (s *ShardedDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	for _, db := range s.stores() {
+		if kvr := db.FindOne(handler); kvr.Result {
+			return kvr
+		}
+	}
+	return &KVResult{
+		Result: false,
+		Info:   "not found",
+	}
+}
+
+// KeyCount - keys of every shard
+func (s *ShardedDB) KeyCount() int {
+	n := 0
+	for _, db := range s.stores() {
+		n += db.KeyCount()
+	}
+	return n
+}
+
+// Close - close shards, those opened by uri are released
+func (s *ShardedDB) Close() error {
+	var err error
+	for _, db := range s.stores() {
+		if e := s.release(db); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// release - release db when it was opened by uri, or close it
+func (s *ShardedDB) release(db KVMethods) error {
+	s.lock.Lock()
+	owned := s.owned[db.Name()]
+	delete(s.owned, db.Name())
+	s.lock.Unlock()
+	if owned {
+		return CloseKVDataBase(db.Name())
+	}
+	return db.Close()
+}
+
+// ListKeys - list keys
+// page - the number of page
+func (s *ShardedDB) ListKeys(page uint) []string {
+	return scanListKeys(s, page, s.Count)
+}
+
+// List - list content that hander returned
+// page - page number
+func (s *ShardedDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	return scanList(s, page, s.Count, handler)
+}
+
+// SetData - set data encoded by codec of the first shard
+func (s *ShardedDB) SetData(key string, data interface{}) *KVResult {
+	return setData(s, s.Codec(), key, data)
+}
+
+// GetData - decode value of key into out
+func (s *ShardedDB) GetData(key string, out interface{}) *KVResult {
+	return getData(s, key, out)
+}
+
+// Codec - codec of the first shard
+func (s *ShardedDB) Codec() Codec {
+	return s.Shards()[0].Codec()
+}
+
+// GetContext - get value of key from its shard
+// while shards change keys not moved yet are read from their old shard
+func (s *ShardedDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	owner, prev := s.owners(key)
+	v, err := KVStoreOf(owner).GetContext(ctx, key)
+	if err == ErrNotFound && prev != nil {
+		return KVStoreOf(prev).GetContext(ctx, key)
+	}
+	return v, err
+}
+
+// SetContext - set key value on its shard
+func (s *ShardedDB) SetContext(ctx context.Context, key string, value []byte) error {
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	owner, prev := s.owners(key)
+	if err := KVStoreOf(owner).SetContext(ctx, key, value); err != nil {
+		return err
+	}
+	if prev != nil {
+		if err := KVStoreOf(prev).DeleteContext(ctx, key); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteContext - delete key from its shard
+func (s *ShardedDB) DeleteContext(ctx context.Context, key string) error {
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	owner, prev := s.owners(key)
+	err := KVStoreOf(owner).DeleteContext(ctx, key)
+	if prev != nil && (err == nil || err == ErrNotFound) {
+		if perr := KVStoreOf(prev).DeleteContext(ctx, key); perr != ErrNotFound {
+			err = perr
+		}
+	}
+	return err
+}
+
+// ExistsContext - if key existed on its shard
+func (s *ShardedDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	owner, prev := s.owners(key)
+	ok, err := KVStoreOf(owner).ExistsContext(ctx, key)
+	if err == nil && !ok && prev != nil {
+		return KVStoreOf(prev).ExistsContext(ctx, key)
+	}
+	return ok, err
+}
+
+// ScanContext - call handler on every kv, shard by shard
+func (s *ShardedDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	for _, db := range s.stores() {
+		if err := KVStoreOf(db).ScanContext(ctx, handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanners - KVScanner of every shard
+func scanners(shards []KVMethods) ([]KVScanner, error) {
+	ss := make([]KVScanner, len(shards))
+	for i, db := range shards {
+		sc, ok := db.(KVScanner)
+		if !ok {
+			return nil, errors.New(db.Name() + " doesn't support scan")
+		}
+		ss[i] = sc
+	}
+	return ss, nil
+}
+
+// Scan - list at most limit kvs after cursor in key order, merged from
+// every shard
+// cursor is the last key listed, so it stays valid while shards change
+func (s *ShardedDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	if limit <= 0 {
+		return nil, "", wrongLimit()
+	}
+	after, resume, err := cursorKey(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	start := ""
+	if resume {
+		start = after + "\x00"
+	}
+	// one more tells if any is left
+	items, err := s.merged(limit+1, func(sc KVScanner) ([]KVData, error) {
+		return scanRangeLimit(sc, start, "", limit+1)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(items) > limit {
+		return items[:limit], keyCursor(items[limit-1].Key), nil
+	}
+	return items, "", nil
+}
+
+// mergeHeap - heap of kvs lists in key order by their first kv
+type mergeHeap [][]KVData
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i][0].Key < h[j][0].Key }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.([]KVData)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// mergeSorted - at most limit kvs of lists in key order, every one when
+// limit is 0, lists are in key order each
+// a key on two shards while it's moved is listed once
+func mergeSorted(lists [][]KVData, limit int) []KVData {
+	h := make(mergeHeap, 0, len(lists))
+	for _, l := range lists {
+		if len(l) > 0 {
+			h = append(h, l)
+		}
+	}
+	heap.Init(&h)
+	items := make([]KVData, 0)
+	for h.Len() > 0 && (limit == 0 || len(items) < limit) {
+		kv := h[0][0]
+		if n := len(items); n == 0 || items[n-1].Key != kv.Key {
+			items = append(items, kv)
+		}
+		if h[0] = h[0][1:]; len(h[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return items
+}
+
+// merged - at most limit kvs of every shard in key order, every one
+// when limit is 0
+func (s *ShardedDB) merged(limit int, scan func(sc KVScanner) ([]KVData, error)) ([]KVData, error) {
+	ss, err := scanners(s.stores())
+	if err != nil {
+		return nil, err
+	}
+	lists := make([][]KVData, len(ss))
+	for i, sc := range ss {
+		if lists[i], err = scan(sc); err != nil {
+			return nil, err
+		}
+	}
+	return mergeSorted(lists, limit), nil
+}
+
+// ScanPrefix - all kvs whose key starts with prefix in key order
+func (s *ShardedDB) ScanPrefix(prefix string) ([]KVData, error) {
+	return s.merged(0, func(sc KVScanner) ([]KVData, error) {
+		return sc.ScanPrefix(prefix)
+	})
+}
+
+// ScanRange - all kvs with start <= key < end in key order
+func (s *ShardedDB) ScanRange(start, end string) ([]KVData, error) {
+	return s.merged(0, func(sc KVScanner) ([]KVData, error) {
+		return sc.ScanRange(start, end)
+	})
+}
+
+// ScanRangeLimit - at most limit kvs with start <= key < end in key
+// order, at most limit of every shard are merged
+func (s *ShardedDB) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	return s.merged(limit, func(sc KVScanner) ([]KVData, error) {
+		return scanRangeLimit(sc, start, end, limit)
+	})
+}
+
+// errChanging - error of a change of shards before the last one is done
+var errChanging = errors.New("shards are being changed, Rebalance first")
+
+// AddShard - add db to shards and move the keys it now keeps to it,
+// return number of keys moved
+// keys are read from their old shard until they are moved, when it
+// fails Rebalance moves the others
+func (s *ShardedDB) AddShard(ctx context.Context, db KVMethods) (int, error) {
+	s.lock.Lock()
+	if s.prev != nil {
+		s.lock.Unlock()
+		return 0, errChanging
+	}
+	for _, sh := range s.shards {
+		if sh.Name() == db.Name() {
+			s.lock.Unlock()
+			return 0, errors.New(db.Name() + " already existed")
+		}
+	}
+	prev := s.ring
+	from := s.shards
+	s.prev = prev
+	s.shards = sortShards(append(append([]KVMethods(nil), s.shards...), db))
+	s.ring = newHashRing(s.shards, s.vnodes)
+	s.lock.Unlock()
+	return s.finish(ctx, prev, from)
+}
+
+// RemoveShard - move keys of shard of name to the other shards and
+// remove it, return number of keys moved
+// a shard opened by uri is released once its keys are moved, others
+// aren't closed, when moving fails it's read until Rebalance moved the
+// others
+func (s *ShardedDB) RemoveShard(ctx context.Context, name string) (int, error) {
+	s.lock.Lock()
+	if s.prev != nil {
+		s.lock.Unlock()
+		return 0, errChanging
+	}
+	var removed KVMethods
+	shards := make([]KVMethods, 0, len(s.shards))
+	for _, sh := range s.shards {
+		if sh.Name() == name {
+			removed = sh
+		} else {
+			shards = append(shards, sh)
+		}
+	}
+	if removed == nil || len(shards) == 0 {
+		s.lock.Unlock()
+		return 0, errors.New("can't remove " + name)
+	}
+	prev := s.ring
+	s.prev = prev
+	s.shards = shards
+	s.draining = []KVMethods{removed}
+	s.ring = newHashRing(s.shards, s.vnodes)
+	s.lock.Unlock()
+	return s.finish(ctx, prev, []KVMethods{removed})
+}
+
+// finish - drain dbs, and forget ring prev and the removed shards when
+// every key was moved, releasing those opened by uri
+func (s *ShardedDB) finish(ctx context.Context, prev *hashRing, dbs []KVMethods) (int, error) {
+	n := 0
+	for _, db := range dbs {
+		moved, err := s.Drain(ctx, db)
+		n += moved
+		if err != nil {
+			return n, err
+		}
+	}
+	var removed []KVMethods
+	s.lock.Lock()
+	if prev != nil && s.prev == prev {
+		s.prev = nil
+		for _, db := range s.draining {
+			if s.owned[db.Name()] {
+				removed = append(removed, db)
+			}
+		}
+		s.draining = nil
+	}
+	s.lock.Unlock()
+	for _, db := range removed {
+		if err := s.release(db); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Rebalance - move every key not on its shard to it, return number of
+// keys moved, for shards changed by uri or a failed AddShard or
+// RemoveShard
+// time to live of keys moved is not kept
+func (s *ShardedDB) Rebalance(ctx context.Context) (int, error) {
+	s.lock.RLock()
+	prev := s.prev
+	s.lock.RUnlock()
+	return s.finish(ctx, prev, s.stores())
+}
+
+// Drain - move keys of db not kept by it to their shards, every key
+// when db isn't a shard any more, return number of keys moved
+// a key already on its shard isn't overwritten, it was set after
+func (s *ShardedDB) Drain(ctx context.Context, db KVMethods) (int, error) {
+	sc, ok := db.(KVScanner)
+	if !ok {
+		return 0, errors.New(db.Name() + " doesn't support scan")
+	}
+	from := KVStoreOf(db)
+	n := 0
+	var err error
+	serr := scanPages(sc, scanBatch, func(kv *KVData) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		var moved bool
+		moved, err = s.move(ctx, kv.Key, db, from)
+		if moved {
+			n++
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// move - move key from db to its shard
+func (s *ShardedDB) move(ctx context.Context, key string, db KVMethods, from KVStore) (bool, error) {
+	l := s.keyLock(key)
+	l.Lock()
+	defer l.Unlock()
+	owner := s.ShardOf(key)
+	if owner == db {
+		return false, nil
+	}
+	v, err := from.GetContext(ctx, key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	to := KVStoreOf(owner)
+	ok, err := to.ExistsContext(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		if err := to.SetContext(ctx, key, v); err != nil {
+			return false, err
+		}
+	}
+	if err := from.DeleteContext(ctx, key); err != nil && err != ErrNotFound {
+		return false, err
+	}
+	return true, nil
+}
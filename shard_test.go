@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func memShards(t *testing.T, labels ...string) []KVMethods {
+	t.Helper()
+	shards := make([]KVMethods, len(labels))
+	for i, l := range labels {
+		db, err := NewMemDB("mem://shard/" + l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards[i] = db
+	}
+	return shards
+}
+
+func TestShardedDB(t *testing.T) {
+	shards := memShards(t, "s1", "s2", "s3")
+	s, err := Shard(shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		s.Set(&KVData{fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i))})
+	}
+	for _, db := range shards {
+		// about a third each
+		if n := db.KeyCount(); n < 50 || n > 150 {
+			t.Fatalf("%s keeps %d keys", db.Name(), n)
+		}
+	}
+	if s.KeyCount() != 300 || len(s.ListKeys(0)) != 300 {
+		t.Fatalf("%d keys", s.KeyCount())
+	}
+	checkValue(t, s, "key042", "42")
+	if !shards[0].Exists("key042") && !shards[1].Exists("key042") && !shards[2].Exists("key042") {
+		t.Fatal("key not on a shard")
+	}
+	if kvr := s.Delete("key042"); !kvr.Result || s.Exists("key042") {
+		t.Fatal("delete")
+	}
+
+	// same order on every call, and across shard order
+	s.Count = 40
+	again, err := Shard(shards[2], shards[0], shards[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Count = 40
+	for page := uint(0); page < 8; page++ {
+		a, b := s.ListKeys(page), again.ListKeys(page)
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			t.Fatalf("page %d differs", page)
+		}
+	}
+	kvs, err := s.ScanPrefix("key1")
+	if err != nil || len(kvs) != 100 || kvs[0].Key != "key100" || kvs[99].Key != "key199" {
+		t.Fatalf("scan prefix: %d %v", len(kvs), err)
+	}
+	var keys []string
+	if err := scanPages(s, 7, func(kv *KVData) bool { keys = append(keys, kv.Key); return true }); err != nil || len(keys) != 299 {
+		t.Fatalf("scan: %d %v", len(keys), err)
+	}
+	// merged in key order
+	if !sort.StringsAreSorted(keys) || keys[0] != "key000" || keys[298] != "key299" {
+		t.Fatalf("scanned %v", keys)
+	}
+	if page := s.ListKeys(1); page[0] != "key040" || page[39] != "key080" {
+		t.Fatalf("page 1: %v", page)
+	}
+}
+
+func TestShardedDB_ScanCursor(t *testing.T) {
+	shards := memShards(t, "c1", "c2")
+	s, err := Shard(shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		s.Set(&KVData{fmt.Sprintf("key%03d", i), []byte("v")})
+	}
+	items, next, err := s.Scan("", 10)
+	if err != nil || len(items) != 10 || items[9].Key != "key009" {
+		t.Fatalf("first page: %v %v", items, err)
+	}
+	// the cursor is a key, so it stays valid across a change of shards
+	if _, err := s.AddShard(context.Background(), memShards(t, "c3")[0]); err != nil {
+		t.Fatal(err)
+	}
+	n := 10
+	for next != "" {
+		if items, next, err = s.Scan(next, 10); err != nil {
+			t.Fatal(err)
+		}
+		for _, kv := range items {
+			if want := fmt.Sprintf("key%03d", n); kv.Key != want {
+				t.Fatalf("got %s for %s", kv.Key, want)
+			}
+			n++
+		}
+	}
+	if n != 100 {
+		t.Fatalf("scanned %d keys", n)
+	}
+	if _, _, err := s.Scan("no cursor", 10); err == nil {
+		t.Fatal("wrong cursor")
+	}
+}
+
+func TestShardedDB_RemoveOpened(t *testing.T) {
+	db, err := NewKVDataBase("shard://owned?db=" + url.QueryEscape("mem://shard/o1") + "&db=" + url.QueryEscape("mem://shard/o2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	s := db.(*ShardedDB)
+	for i := 0; i < 50; i++ {
+		s.Set(&KVData{fmt.Sprint(i), []byte("v")})
+	}
+	if _, err := s.RemoveShard(context.Background(), "Memdb_o1"); err != nil {
+		t.Fatal(err)
+	}
+	// released once drained, the other is kept
+	if GetKVDataBase("Memdb_o1") != nil || GetKVDataBase("Memdb_o2") == nil {
+		t.Fatal("removed shard not released")
+	}
+	if s.KeyCount() != 50 {
+		t.Fatalf("%d keys", s.KeyCount())
+	}
+	CloseKVDataBase(db.Name())
+	if GetKVDataBase("Memdb_o2") != nil {
+		t.Fatal("shard not released on close")
+	}
+}
+
+func TestShardedDB_AddRemove(t *testing.T) {
+	shards := memShards(t, "r1", "r2")
+	s, err := Shard(shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		s.Set(&KVData{fmt.Sprint(i), []byte("v")})
+	}
+	added := memShards(t, "r3")[0]
+	moved, err := s.AddShard(context.Background(), added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only keys of the new shard move
+	if moved != added.KeyCount() || moved < 30 || moved > 110 {
+		t.Fatalf("moved %d, new shard keeps %d", moved, added.KeyCount())
+	}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprint(i)
+		if !s.ShardOf(key).Exists(key) {
+			t.Fatalf("%s not on its shard", key)
+		}
+	}
+	if n, err := s.Rebalance(context.Background()); err != nil || n != 0 {
+		t.Fatalf("balanced shards rebalanced %d: %v", n, err)
+	}
+
+	moved, err = s.RemoveShard(context.Background(), shards[0].Name())
+	if err != nil || shards[0].KeyCount() != 0 || len(s.Shards()) != 2 {
+		t.Fatalf("remove: %v", err)
+	}
+	if s.KeyCount() != 200 || moved == 0 {
+		t.Fatalf("%d keys after remove, moved %d", s.KeyCount(), moved)
+	}
+	if _, err := s.RemoveShard(context.Background(), "nothing"); err == nil {
+		t.Fatal("removed a missing shard")
+	}
+}
+
+func TestShardedDB_Unfinished(t *testing.T) {
+	shards := memShards(t, "u1", "u2")
+	if _, err := Shard(shards[0], shards[0]); err == nil {
+		t.Fatal("shards of the same name accepted")
+	}
+	s, err := Shard(shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		s.Set(&KVData{fmt.Sprint(i), []byte(fmt.Sprint(i))})
+	}
+	// keys are still read from their old shard when moving fails
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.AddShard(ctx, memShards(t, "u3")[0]); err == nil {
+		t.Fatal("add with cancelled context")
+	}
+	if _, err := s.RemoveShard(context.Background(), shards[0].Name()); err != errChanging {
+		t.Fatalf("remove before rebalance: %v", err)
+	}
+	for i := 0; i < 200; i++ {
+		checkValue(t, s, fmt.Sprint(i), fmt.Sprint(i))
+	}
+	if n, err := s.Rebalance(context.Background()); err != nil || n == 0 {
+		t.Fatalf("rebalanced %d: %v", n, err)
+	}
+
+	if _, err := s.RemoveShard(ctx, shards[0].Name()); err == nil {
+		t.Fatal("remove with cancelled context")
+	}
+	if s.KeyCount() != 200 {
+		t.Fatalf("%d keys", s.KeyCount())
+	}
+	for i := 0; i < 200; i++ {
+		checkValue(t, s, fmt.Sprint(i), fmt.Sprint(i))
+	}
+	if _, err := s.Rebalance(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if shards[0].KeyCount() != 0 || s.KeyCount() != 200 {
+		t.Fatalf("%d keys left on removed shard", shards[0].KeyCount())
+	}
+	if _, err := s.AddShard(context.Background(), shards[0]); err != nil {
+		t.Fatal(err)
+	}
+}
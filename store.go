@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNotFound - key didn't exist in database
+	ErrNotFound = errors.New("kvdb: key not found")
+	// ErrClosed - database has been closed
+	ErrClosed = errors.New("kvdb: database closed")
+	// ErrBucketMissing - bucket (or hashkey) could not be opened
+	ErrBucketMissing = errors.New("kvdb: bucket missing")
+)
+
+// KVStore - context aware interface for KV DB
+// every method returns a plain error, so callers can use errors.Is
+// with ErrNotFound, ErrClosed, ErrBucketMissing or context errors
+type KVStore interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+	SetContext(ctx context.Context, key string, value []byte) error
+	DeleteContext(ctx context.Context, key string) error
+	ExistsContext(ctx context.Context, key string) (bool, error)
+	// ScanContext call handler on every kv, stop and return the
+	// first error handler returned
+	ScanContext(ctx context.Context, handler func(k, v []byte) error) error
+}
+
+// KVStoreOf - get KVStore interface of a database
+// backends implementing KVStore are returned directly, any other
+// KVMethods is wrapped by an adapter over the KVResult methods
+func KVStoreOf(db KVMethods) KVStore {
+	if s, ok := db.(KVStore); ok {
+		return s
+	}
+	return &kvStoreAdapter{db: db}
+}
+
+// kvStoreAdapter - KVStore on top of KVMethods
+type kvStoreAdapter struct {
+	db KVMethods
+}
+
+func (a *kvStoreAdapter) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !a.db.Exists(key) {
+		return nil, ErrNotFound
+	}
+	kvr := a.db.Get(key)
+	if !kvr.Result {
+		return nil, errors.New(kvr.Info)
+	}
+	return resultBytes(kvr.Data)
+}
+
+func (a *kvStoreAdapter) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if kvr := a.db.Set(&KVData{key, value}); !kvr.Result {
+		return errors.New(kvr.Info)
+	}
+	return nil
+}
+
+func (a *kvStoreAdapter) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !a.db.Exists(key) {
+		return ErrNotFound
+	}
+	if kvr := a.db.Delete(key); !kvr.Result {
+		return errors.New(kvr.Info)
+	}
+	return nil
+}
+
+func (a *kvStoreAdapter) ExistsContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return a.db.Exists(key), nil
+}
+
+func (a *kvStoreAdapter) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	var err error
+	a.db.FindOne(func(k, v []byte) *KVResult {
+		if err = ctx.Err(); err != nil {
+			return &KVResult{Result: true}
+		}
+		if err = handler(k, v); err != nil {
+			return &KVResult{Result: true}
+		}
+		return &KVResult{Result: false}
+	})
+	return err
+}
+
+// resultBytes - value bytes of KVResult.Data
+func resultBytes(data interface{}) ([]byte, error) {
+	switch v := data.(type) {
+	case []byte:
+		return v, nil
+	case *KVData:
+		return v.Value, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errors.New("unknown data type")
+}
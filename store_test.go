@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func testKVStore(t *testing.T, s KVStore) {
+	ctx := context.Background()
+	if err := s.SetContext(ctx, "key1", []byte("value1")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.GetContext(ctx, "key1")
+	if err != nil || string(v) != "value1" {
+		t.Fatalf("get key1: %q %v", v, err)
+	}
+	if ok, err := s.ExistsContext(ctx, "key1"); !ok || err != nil {
+		t.Fatalf("exists key1: %v %v", ok, err)
+	}
+	if _, err := s.GetContext(ctx, "nokey"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get nokey: %v", err)
+	}
+	n := 0
+	if err := s.ScanContext(ctx, func(k, v []byte) error {
+		n++
+		return nil
+	}); err != nil || n != 1 {
+		t.Fatalf("scan: %d %v", n, err)
+	}
+	if err := s.DeleteContext(ctx, "key1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteContext(ctx, "key1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete twice: %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.GetContext(cancelled, "key1"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("get on cancelled context: %v", err)
+	}
+}
+
+func TestKVStore_Mem(t *testing.T) {
+	db, err := NewKVDataBase("mem://store/storetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKVStore(t, KVStoreOf(db))
+}
+
+func TestKVStore_Bolt(t *testing.T) {
+	db, err := NewKVDataBase("bolt://store.db/storetest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKVStore(t, KVStoreOf(db))
+}
+
+func TestKVStore_Adapter(t *testing.T) {
+	db, err := NewKVDataBase("mem://store/adaptertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKVStore(t, &kvStoreAdapter{db: db})
+}
+
+func TestOpenOrGet(t *testing.T) {
+	uri := "bolt://lifecycle.db/lifecycle?path=" + t.TempDir()
+	db1, err := OpenOrGet(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, err := OpenOrGet(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db1 != db2 {
+		t.Fatal("OpenOrGet returned different handles")
+	}
+	if err := CloseKVDataBase(db1.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KVStoreOf(db2).GetContext(context.Background(), "key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("closed with references left: %v", err)
+	}
+	if err := CloseKVDataBase(db2.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := KVStoreOf(db2).GetContext(context.Background(), "key"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("get on closed db: %v", err)
+	}
+	db3, err := OpenOrGet(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CloseKVDataBase(db3.Name()); err != nil {
+		t.Fatal(err)
+	}
+}
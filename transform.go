@@ -0,0 +1,665 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transformer - change of keys and values between a wrapper and the
+// database under it, used by encryption and compression
+type transformer interface {
+	// storedKey - key stored for key
+	storedKey(key string) string
+	// storedKeys - keys a value of key may be stored under, storedKey
+	// first, others are written by older settings
+	storedKeys(key string) []string
+	// key - key of a stored key
+	key(stored string) (string, error)
+	// ordered - if stored keys keep order and prefixes of keys
+	ordered() bool
+	// seal - value stored for value of key
+	seal(key string, value []byte) ([]byte, error)
+	// open - value of a stored value of key
+	open(key string, stored []byte) ([]byte, error)
+}
+
+// transformDB - database storing transformed kvs in db
+// it implements every optional interface, those db doesn't implement
+// fail with an error
+type transformDB struct {
+	db    KVMethods
+	store KVStore
+	t     transformer
+}
+
+// newTransformDB - wrap db with transformer t
+func newTransformDB(db KVMethods, t transformer) *transformDB {
+	return &transformDB{
+		db:    db,
+		store: KVStoreOf(db),
+		t:     t,
+	}
+}
+
+// unsupportedError - error of an optional interface a db doesn't
+// implement, called on a middleware implementing it
+type unsupportedError struct {
+	name, what string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.name + " doesn't support " + e.what
+}
+
+// unsupported - error of what db doesn't support
+func unsupported(db KVMethods, what string) error {
+	return &unsupportedError{db.Name(), what}
+}
+
+// unsupported - error of an optional interface db doesn't implement
+func (w *transformDB) unsupported(what string) error {
+	return unsupported(w.db, what)
+}
+
+// failed - failed result of err
+func failed(err error) *KVResult {
+	return &KVResult{
+		Result: false,
+		Info:   err.Error(),
+	}
+}
+
+// Name - name of db
+func (w *transformDB) Name() string {
+	return w.db.Name()
+}
+
+// DBType - type of db
+func (w *transformDB) DBType() *KVDBType {
+	return w.db.DBType()
+}
+
+// Close - close db
+func (w *transformDB) Close() error {
+	return w.db.Close()
+}
+
+// KeyCount - keys of db
+func (w *transformDB) KeyCount() int {
+	return w.db.KeyCount()
+}
+
+// Codec - codec of db
+func (w *transformDB) Codec() Codec {
+	return w.db.Codec()
+}
+
+// Exists - if key existed
+func (w *transformDB) Exists(key string) bool {
+	ok, _ := w.ExistsContext(context.Background(), key)
+	return ok
+}
+
+// Get - get value of key
+func (w *transformDB) Get(key string) *KVResult {
+	v, err := w.GetContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   v,
+		Result: true,
+	}
+}
+
+// Set - set key value
+func (w *transformDB) Set(kv *KVData) *KVResult {
+	if err := w.SetContext(context.Background(), kv.Key, kv.Value); err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+	}
+}
+
+// Delete - delete key
+func (w *transformDB) Delete(key string) *KVResult {
+	err := w.DeleteContext(context.Background(), key)
+	if err == ErrNotFound {
+		return notExisted()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return &KVResult{
+		Result: true,
+	}
+}
+
+// handler - handler of db calling h with key and value
+// kvs that can't be opened are not matched
+func (w *transformDB) handler(h func(k, v []byte) *KVResult) func(k, v []byte) *KVResult {
+	return func(k, v []byte) *KVResult {
+		key, err := w.t.key(string(k))
+		if err != nil {
+			return failed(err)
+		}
+		value, err := w.t.open(key, v)
+		if err != nil {
+			return failed(err)
+		}
+		return h([]byte(key), value)
+	}
+}
+
+// FindOne - find first kv matched by handler
+func (w *transformDB) FindOne(handler func(k, v []byte) *KVResult) *KVResult {
+	return w.db.FindOne(w.handler(handler))
+}
+
+// ListKeys - keys on page
+func (w *transformDB) ListKeys(page uint) []string {
+	stored := w.db.ListKeys(page)
+	keys := make([]string, 0, len(stored))
+	for _, s := range stored {
+		if k, err := w.t.key(s); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// List - data handler returned on page
+func (w *transformDB) List(page uint, handler func(k, v []byte) *KVResult) *KVResult {
+	return w.db.List(page, w.handler(handler))
+}
+
+// SetData - set data encoded by codec of db
+func (w *transformDB) SetData(key string, data interface{}) *KVResult {
+	return setData(w, w.Codec(), key, data)
+}
+
+// GetData - decode value of key into out
+func (w *transformDB) GetData(key string, out interface{}) *KVResult {
+	return getData(w, key, out)
+}
+
+// GetContext - get value of key
+func (w *transformDB) GetContext(ctx context.Context, key string) ([]byte, error) {
+	for _, s := range w.t.storedKeys(key) {
+		v, err := w.store.GetContext(ctx, s)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return w.t.open(key, v)
+	}
+	return nil, ErrNotFound
+}
+
+// SetContext - set key value, values of key stored by older settings
+// are removed
+func (w *transformDB) SetContext(ctx context.Context, key string, value []byte) error {
+	v, err := w.t.seal(key, value)
+	if err != nil {
+		return err
+	}
+	stored := w.t.storedKeys(key)
+	if err := w.store.SetContext(ctx, stored[0], v); err != nil {
+		return err
+	}
+	for _, s := range stored[1:] {
+		if err := w.store.DeleteContext(ctx, s); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteContext - delete key
+func (w *transformDB) DeleteContext(ctx context.Context, key string) error {
+	found := false
+	for _, s := range w.t.storedKeys(key) {
+		err := w.store.DeleteContext(ctx, s)
+		if err == nil {
+			found = true
+		} else if err != ErrNotFound {
+			return err
+		}
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ExistsContext - if key existed
+func (w *transformDB) ExistsContext(ctx context.Context, key string) (bool, error) {
+	for _, s := range w.t.storedKeys(key) {
+		ok, err := w.store.ExistsContext(ctx, s)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// ScanContext - call handler on every kv
+func (w *transformDB) ScanContext(ctx context.Context, handler func(k, v []byte) error) error {
+	return w.store.ScanContext(ctx, func(k, v []byte) error {
+		key, err := w.t.key(string(k))
+		if err != nil {
+			return err
+		}
+		value, err := w.t.open(key, v)
+		if err != nil {
+			return err
+		}
+		return handler([]byte(key), value)
+	})
+}
+
+// opened - kvs of stored kvs
+func (w *transformDB) opened(items []KVData) ([]KVData, error) {
+	kvs := make([]KVData, len(items))
+	for i := range items {
+		key, err := w.t.key(items[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := w.t.open(key, items[i].Value)
+		if err != nil {
+			return nil, err
+		}
+		kvs[i] = KVData{key, value}
+	}
+	return kvs, nil
+}
+
+// Scan - list at most limit kvs after cursor in order of db
+func (w *transformDB) Scan(cursor string, limit int) ([]KVData, string, error) {
+	s, ok := w.db.(KVScanner)
+	if !ok {
+		return nil, "", w.unsupported("scan")
+	}
+	items, next, err := s.Scan(cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	kvs, err := w.opened(items)
+	return kvs, next, err
+}
+
+// ScanPrefix - all kvs whose key starts with prefix in key order
+func (w *transformDB) ScanPrefix(prefix string) ([]KVData, error) {
+	s, ok := w.db.(KVScanner)
+	if !ok {
+		return nil, w.unsupported("scan")
+	}
+	if w.t.ordered() {
+		items, err := s.ScanPrefix(w.t.storedKey(prefix))
+		if err != nil {
+			return nil, err
+		}
+		return w.opened(items)
+	}
+	return w.filter(s, func(k string) bool {
+		return strings.HasPrefix(k, prefix)
+	})
+}
+
+// ScanRange - all kvs with start <= key < end in key order
+func (w *transformDB) ScanRange(start, end string) ([]KVData, error) {
+	s, ok := w.db.(KVScanner)
+	if !ok {
+		return nil, w.unsupported("scan")
+	}
+	if w.t.ordered() {
+		stored := ""
+		if end != "" {
+			stored = w.t.storedKey(end)
+		}
+		items, err := s.ScanRange(w.t.storedKey(start), stored)
+		if err != nil {
+			return nil, err
+		}
+		return w.opened(items)
+	}
+	return w.filter(s, func(k string) bool {
+		return k >= start && inRange(k, end)
+	})
+}
+
+// ScanRangeLimit - at most limit kvs with start <= key < end in key order
+func (w *transformDB) ScanRangeLimit(start, end string, limit int) ([]KVData, error) {
+	if limit <= 0 {
+		return nil, wrongLimit()
+	}
+	s, ok := w.db.(KVScanner)
+	if !ok {
+		return nil, w.unsupported("scan")
+	}
+	if w.t.ordered() {
+		stored := ""
+		if end != "" {
+			stored = w.t.storedKey(end)
+		}
+		items, err := scanRangeLimit(s, w.t.storedKey(start), stored, limit)
+		if err != nil {
+			return nil, err
+		}
+		return w.opened(items)
+	}
+	items, err := w.ScanRange(start, end)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items, err
+}
+
+// filter - kvs whose key is matched by in, in key order
+// for stored keys out of order every kv is scanned
+func (w *transformDB) filter(s KVScanner, in func(k string) bool) ([]KVData, error) {
+	var kvs []KVData
+	var err error
+	serr := scanPages(s, scanBatch, func(kv *KVData) bool {
+		var key string
+		key, err = w.t.key(kv.Key)
+		if err != nil {
+			return false
+		}
+		if !in(key) {
+			return true
+		}
+		var value []byte
+		value, err = w.t.open(key, kv.Value)
+		if err != nil {
+			return false
+		}
+		kvs = append(kvs, KVData{key, value})
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, nil
+}
+
+// batch - KVBatch of db when every key has one stored key
+func (w *transformDB) batch(keys []string) (KVBatch, bool) {
+	b, ok := w.db.(KVBatch)
+	if !ok {
+		return nil, false
+	}
+	for _, k := range keys {
+		if len(w.t.storedKeys(k)) > 1 {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+// MGet - get values of keys
+func (w *transformDB) MGet(keys []string) []*KVResult {
+	b, ok := w.batch(keys)
+	if !ok {
+		res := make([]*KVResult, len(keys))
+		for i, k := range keys {
+			res[i] = w.Get(k)
+		}
+		return res
+	}
+	stored := make([]string, len(keys))
+	for i, k := range keys {
+		stored[i] = w.t.storedKey(k)
+	}
+	res := b.MGet(stored)
+	for i, r := range res {
+		if !r.Result {
+			continue
+		}
+		v, err := resultBytes(r.Data)
+		if err == nil {
+			v, err = w.t.open(keys[i], v)
+		}
+		if err != nil {
+			res[i] = failed(err)
+			continue
+		}
+		res[i] = &KVResult{
+			Data:   v,
+			Result: true,
+		}
+	}
+	return res
+}
+
+// MSet - set kvs
+func (w *transformDB) MSet(kvs []KVData) []*KVResult {
+	keys := make([]string, len(kvs))
+	for i := range kvs {
+		keys[i] = kvs[i].Key
+	}
+	b, ok := w.batch(keys)
+	if !ok {
+		res := make([]*KVResult, len(kvs))
+		for i := range kvs {
+			res[i] = w.Set(&kvs[i])
+		}
+		return res
+	}
+	stored := make([]KVData, len(kvs))
+	for i := range kvs {
+		v, err := w.t.seal(kvs[i].Key, kvs[i].Value)
+		if err != nil {
+			return batchFailed(len(kvs), err)
+		}
+		stored[i] = KVData{w.t.storedKey(kvs[i].Key), v}
+	}
+	res := b.MSet(stored)
+	for i, r := range res {
+		if r.Result {
+			r.Data = &kvs[i]
+		}
+	}
+	return res
+}
+
+// MDelete - delete keys
+func (w *transformDB) MDelete(keys []string) []*KVResult {
+	b, ok := w.batch(keys)
+	if !ok {
+		res := make([]*KVResult, len(keys))
+		for i, k := range keys {
+			res[i] = w.Delete(k)
+		}
+		return res
+	}
+	stored := make([]string, len(keys))
+	for i, k := range keys {
+		stored[i] = w.t.storedKey(k)
+	}
+	res := b.MDelete(stored)
+	for _, r := range res {
+		if r.Result {
+			r.Data = nil
+		}
+	}
+	return res
+}
+
+// SetWithTTL - set key value that expires after ttl
+func (w *transformDB) SetWithTTL(kv *KVData, ttl time.Duration) *KVResult {
+	e, ok := w.db.(KVExpire)
+	if !ok {
+		return failed(w.unsupported("ttl"))
+	}
+	v, err := w.t.seal(kv.Key, kv.Value)
+	if err != nil {
+		return failed(err)
+	}
+	stored := w.t.storedKeys(kv.Key)
+	if kvr := e.SetWithTTL(&KVData{stored[0], v}, ttl); !kvr.Result {
+		return kvr
+	}
+	for _, s := range stored[1:] {
+		w.store.DeleteContext(context.Background(), s)
+	}
+	return &KVResult{
+		Data:   kv,
+		Result: true,
+	}
+}
+
+// TTL - remaining time to live of key
+func (w *transformDB) TTL(key string) (time.Duration, error) {
+	e, ok := w.db.(KVExpire)
+	if !ok {
+		return 0, w.unsupported("ttl")
+	}
+	for _, s := range w.t.storedKeys(key) {
+		ttl, err := e.TTL(s)
+		if err != ErrNotFound {
+			return ttl, err
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// transformTxn - transaction of db transforming kvs
+type transformTxn struct {
+	tx KVTxn
+	t  transformer
+}
+
+func (t *transformTxn) Get(key string) ([]byte, error) {
+	for _, s := range t.t.storedKeys(key) {
+		v, err := t.tx.Get(s)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return t.t.open(key, v)
+	}
+	return nil, ErrNotFound
+}
+
+func (t *transformTxn) Exists(key string) (bool, error) {
+	for _, s := range t.t.storedKeys(key) {
+		ok, err := t.tx.Exists(s)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (t *transformTxn) Set(key string, value []byte) error {
+	v, err := t.t.seal(key, value)
+	if err != nil {
+		return err
+	}
+	stored := t.t.storedKeys(key)
+	if err := t.tx.Set(stored[0], v); err != nil {
+		return err
+	}
+	for _, s := range stored[1:] {
+		if err := t.tx.Delete(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transformTxn) Delete(key string) error {
+	for _, s := range t.t.storedKeys(key) {
+		if err := t.tx.Delete(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update - run fn in a transaction of db
+func (w *transformDB) Update(fn func(tx KVTxn) error) error {
+	tr, ok := w.db.(KVTransaction)
+	if !ok {
+		return w.unsupported("transactions")
+	}
+	return tr.Update(func(tx KVTxn) error {
+		return fn(&transformTxn{tx: tx, t: w.t})
+	})
+}
+
+// View - run fn in a read only transaction of db
+func (w *transformDB) View(fn func(tx KVTxn) error) error {
+	tr, ok := w.db.(KVTransaction)
+	if !ok {
+		return w.unsupported("transactions")
+	}
+	return tr.View(func(tx KVTxn) error {
+		return fn(&transformTxn{tx: tx, t: w.t})
+	})
+}
+
+// Watch - events of keys starting with prefix, until ctx is done
+// events that can't be opened are dropped
+func (w *transformDB) Watch(ctx context.Context, prefix string) <-chan KVEvent {
+	ch := make(chan KVEvent, WatchBuffer)
+	wt, ok := w.db.(KVWatcher)
+	if !ok {
+		close(ch)
+		return ch
+	}
+	stored := ""
+	if w.t.ordered() {
+		stored = w.t.storedKey(prefix)
+	}
+	events := wt.Watch(ctx, stored)
+	go func() {
+		defer func() {
+			// drain db watcher until ctx is done
+			for range events {
+			}
+		}()
+		defer close(ch)
+		for ev := range events {
+			key, err := w.t.key(ev.Key)
+			if err != nil || !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if ev.OldValue != nil {
+				if ev.OldValue, err = w.t.open(key, ev.OldValue); err != nil {
+					continue
+				}
+			}
+			if ev.NewValue != nil {
+				if ev.NewValue, err = w.t.open(key, ev.NewValue); err != nil {
+					continue
+				}
+			}
+			ev.Key = key
+			select {
+			case ch <- ev:
+			default:
+				// fell behind
+				return
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,47 @@
+package db
+
+import "errors"
+
+var (
+	// ErrReadOnly - write in a read only transaction
+	ErrReadOnly = errors.New("kvdb: read only transaction")
+	// ErrTxConflict - transaction kept conflicting with other writers
+	ErrTxConflict = errors.New("kvdb: transaction conflict")
+)
+
+// KVTxn - operations in a transaction
+// reads see the writes made earlier in the same transaction
+type KVTxn interface {
+	Get(key string) ([]byte, error)
+	Exists(key string) (bool, error)
+	Set(key string, value []byte) error
+	// Delete key, missing key is not an error
+	Delete(key string) error
+}
+
+// KVTransaction - interface for atomic multi-key operations
+// Update commits all changes made by fn when it returned nil, and
+// discards all of them when it returned an error
+// View runs fn in a read only transaction
+type KVTransaction interface {
+	Update(fn func(tx KVTxn) error) error
+	View(fn func(tx KVTxn) error) error
+}
+
+// txWrite - write buffered in a transaction
+type txWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// txBatch - writes buffered in a transaction, applied on commit
+type txBatch map[string]txWrite
+
+// get - value written in batch, ok is false when key wasn't touched
+func (b txBatch) get(key string) (value []byte, found bool, ok bool) {
+	w, ok := b[key]
+	if !ok {
+		return nil, false, false
+	}
+	return w.value, !w.deleted, true
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func testKVTransaction(t *testing.T, db KVMethods) {
+	tr, ok := db.(KVTransaction)
+	if !ok {
+		t.Fatalf("%s doesn't support transactions", db.Name())
+	}
+	if err := tr.Update(func(tx KVTxn) error {
+		if err := tx.Set("record", []byte("value")); err != nil {
+			return err
+		}
+		return tx.Set("index", []byte("record"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("failed")
+	if err := tr.Update(func(tx KVTxn) error {
+		tx.Delete("record")
+		tx.Set("index", []byte("other"))
+		if ok, _ := tx.Exists("record"); ok {
+			t.Error("deleted key visible in transaction")
+		}
+		return failed
+	}); err != failed {
+		t.Fatalf("update: %v", err)
+	}
+	if err := tr.View(func(tx KVTxn) error {
+		v, err := tx.Get("index")
+		if err != nil || string(v) != "record" {
+			t.Errorf("index after rollback: %q %v", v, err)
+		}
+		if ok, _ := tx.Exists("record"); !ok {
+			t.Error("record lost after rollback")
+		}
+		if err := tx.Set("index", nil); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("set in view: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKVTransaction_Mem(t *testing.T) {
+	db, err := OpenOrGet("mem://txn/txntest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVTransaction(t, db)
+}
+
+func TestKVTransaction_Bolt(t *testing.T) {
+	db, err := OpenOrGet("bolt://txn.db/txntest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVTransaction(t, db)
+}
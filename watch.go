@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// KVEventType - kind of change of a key
+type KVEventType int
+
+const (
+	// KVEventPut - key was set
+	KVEventPut KVEventType = iota
+	// KVEventDelete - key was deleted or expired
+	KVEventDelete
+)
+
+// WatchBuffer - events buffered for a watcher
+// a watcher falling further behind has its channel closed, so it
+// knows events were lost and can reload what it caches
+var WatchBuffer = 128
+
+// KVEvent - change of a key
+// OldValue is nil for new keys, NewValue is nil for deleted keys
+type KVEvent struct {
+	Type     KVEventType
+	Key      string
+	OldValue []byte `json:",omitempty"`
+	NewValue []byte `json:",omitempty"`
+}
+
+// KVWatcher - interface of change notification
+type KVWatcher interface {
+	// Watch - events of keys starting with prefix, until ctx is done
+	// the channel is closed when ctx is done or the watcher fell behind
+	Watch(ctx context.Context, prefix string) <-chan KVEvent
+}
+
+// watchHub - in process watchers of a database
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[*hubWatcher]bool
+}
+
+// hubWatcher - one watcher of a hub
+type hubWatcher struct {
+	prefix string
+	ch     chan KVEvent
+}
+
+// watch - add a watcher until ctx is done
+func (h *watchHub) watch(ctx context.Context, prefix string) <-chan KVEvent {
+	w := &hubWatcher{
+		prefix: prefix,
+		ch:     make(chan KVEvent, WatchBuffer),
+	}
+	h.mu.Lock()
+	if h.watchers == nil {
+		h.watchers = make(map[*hubWatcher]bool)
+	}
+	h.watchers[w] = true
+	h.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		h.remove(w)
+	}()
+	return w.ch
+}
+
+// remove - remove watcher and close its channel, caller doesn't hold mu
+func (h *watchHub) remove(w *hubWatcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watchers[w] {
+		delete(h.watchers, w)
+		close(w.ch)
+	}
+}
+
+// active - if anybody is watching
+// callers use it to skip building events nobody receives
+func (h *watchHub) active() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.watchers) > 0
+}
+
+// publish - send ev to watchers of its key, never blocks
+func (h *watchHub) publish(ev KVEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w := range h.watchers {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			delete(h.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+// closeAll - close channels of all watchers
+func (h *watchHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for w := range h.watchers {
+		delete(h.watchers, w)
+		close(w.ch)
+	}
+}
+
+// putEvent - event of key set from old to new value
+func putEvent(key string, old, new []byte) KVEvent {
+	return KVEvent{
+		Type:     KVEventPut,
+		Key:      key,
+		OldValue: old,
+		NewValue: new,
+	}
+}
+
+// deleteEvent - event of key deleted with old value
+func deleteEvent(key string, old []byte) KVEvent {
+	return KVEvent{
+		Type:     KVEventDelete,
+		Key:      key,
+		OldValue: old,
+	}
+}
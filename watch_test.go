@@ -0,0 +1,98 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, ch <-chan KVEvent) KVEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return KVEvent{}
+}
+
+func testKVWatcher(t *testing.T, db KVMethods) {
+	w := db.(KVWatcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := w.Watch(ctx, "user:")
+
+	db.Set(&KVData{"other", []byte("x")})
+	db.Set(&KVData{"user:1", []byte("a")})
+	db.Set(&KVData{"user:1", []byte("b")})
+	db.Delete("user:1")
+
+	ev := nextEvent(t, ch)
+	if ev.Type != KVEventPut || ev.Key != "user:1" || ev.OldValue != nil || string(ev.NewValue) != "a" {
+		t.Fatalf("first put: %+v", ev)
+	}
+	ev = nextEvent(t, ch)
+	if ev.Type != KVEventPut || string(ev.OldValue) != "a" || string(ev.NewValue) != "b" {
+		t.Fatalf("second put: %+v", ev)
+	}
+	ev = nextEvent(t, ch)
+	if ev.Type != KVEventDelete || string(ev.OldValue) != "b" || ev.NewValue != nil {
+		t.Fatalf("delete: %+v", ev)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("event after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+}
+
+func TestKVWatcher_Mem(t *testing.T) {
+	db, err := NewKVDataBase("mem://watch/watchtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKVWatcher(t, db)
+}
+
+func TestKVWatcher_Bolt(t *testing.T) {
+	db, err := NewKVDataBase("bolt://watch.db/watchtest?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	testKVWatcher(t, db)
+}
+
+func TestBoltDB_WatchRollback(t *testing.T) {
+	db, err := NewKVDataBase("bolt://watch.db/rollback?path=" + t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseKVDataBase(db.Name())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := db.(KVWatcher).Watch(ctx, "")
+
+	failed := errors.New("rollback")
+	err = db.(KVTransaction).Update(func(tx KVTxn) error {
+		tx.Set("key", []byte("dropped"))
+		return failed
+	})
+	if err != failed {
+		t.Fatal(err)
+	}
+	db.Set(&KVData{"key", []byte("kept")})
+	ev := nextEvent(t, ch)
+	if string(ev.NewValue) != "kept" || ev.OldValue != nil {
+		t.Fatalf("event of rolled back update: %+v", ev)
+	}
+}